@@ -47,6 +47,7 @@ import (
 	httpSwagger "github.com/swaggo/http-swagger"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -134,10 +135,12 @@ func initEnv() {
 }
 
 func main() {
-	var flagReinitDB, flagInit, flagServer, flagSyncLDAP, flagGops, flagMigrateDB, flagRevertDB, flagForceDB, flagDev, flagVersion, flagLogDateTime bool
+	var flagReinitDB, flagSyncDB, flagRetryArchiving, flagInit, flagServer, flagSyncLDAP, flagGops, flagMigrateDB, flagRevertDB, flagForceDB, flagDev, flagVersion, flagLogDateTime bool
 	var flagNewUser, flagDelUser, flagGenJWT, flagConfigFile, flagImportJob, flagLogLevel string
 	flag.BoolVar(&flagInit, "init", false, "Setup var directory, initialize swlite database file, config.json and .env")
 	flag.BoolVar(&flagReinitDB, "init-db", false, "Go through job-archive and re-initialize the 'job', 'tag', and 'jobtag' tables (all running jobs will be lost!)")
+	flag.BoolVar(&flagSyncDB, "sync-db", false, "Go through job-archive and insert jobs missing in the 'job' table, updating statistics of changed jobs (running jobs are kept)")
+	flag.BoolVar(&flagRetryArchiving, "retry-archiving", false, "Queue all jobs whose archiving has failed for another attempt (archived by the server)")
 	flag.BoolVar(&flagSyncLDAP, "sync-ldap", false, "Sync the 'user' table with ldap")
 	flag.BoolVar(&flagServer, "server", false, "Start a server, continues listening on port after initialization and argument handling")
 	flag.BoolVar(&flagGops, "gops", false, "Listen via github.com/google/gops/agent (for debugging)")
@@ -305,6 +308,20 @@ func main() {
 		}
 	}
 
+	if flagSyncDB {
+		if err := importer.SyncDB(); err != nil {
+			log.Fatalf("failed to sync repository DB: %s", err.Error())
+		}
+	}
+
+	if flagRetryArchiving {
+		cnt, err := repository.GetJobRepository().RetryFailedArchiving()
+		if err != nil {
+			log.Fatalf("failed to queue jobs for archiving: %s", err.Error())
+		}
+		log.Printf("Queued %d jobs for archiving", cnt)
+	}
+
 	if flagImportJob != "" {
 		if err := importer.HandleImportFlag(flagImportJob); err != nil {
 			log.Fatalf("job import failed: %s", err.Error())
@@ -317,6 +334,7 @@ func main() {
 
 	// Setup the http.Handler/Router used by the server
 	jobRepo := repository.GetJobRepository()
+	jobRepo.StartArchiving()
 	resolver := &graph.Resolver{DB: db.DB, Repo: jobRepo}
 	graphQLEndpoint := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver}))
 	if os.Getenv("DEBUG") != "1" {
@@ -552,6 +570,9 @@ func main() {
 
 		// Then, wait for any async archivings still pending...
 		api.JobRepository.WaitForArchiving()
+
+		// Finally, write the checkpoints of embedded metric stores
+		metricdata.Shutdown()
 	}()
 
 	s := gocron.NewScheduler(time.Local)
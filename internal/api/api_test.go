@@ -17,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ClusterCockpit/cc-backend/internal/api"
 	"github.com/ClusterCockpit/cc-backend/internal/config"
@@ -327,7 +328,7 @@ func TestRestApi(t *testing.T) {
 	}
 
 	t.Run("CheckArchive", func(t *testing.T) {
-		data, err := metricdata.LoadData(stoppedJob, []string{"load_one"}, []schema.MetricScope{schema.MetricScopeNode}, context.Background())
+		data, err := metricdata.LoadData(stoppedJob, []string{"load_one"}, []schema.MetricScope{schema.MetricScopeNode}, 0, context.Background())
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -337,6 +338,43 @@ func TestRestApi(t *testing.T) {
 		}
 	})
 
+	t.Run("RetryArchiving", func(t *testing.T) {
+		if err := restapi.JobRepository.UpdateMonitoringStatus(stoppedJob.ID, schema.MonitoringStatusArchivingFailed); err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/api/jobs/retry_archiving/", nil)
+		recorder := httptest.NewRecorder()
+
+		r.ServeHTTP(recorder, req)
+		response := recorder.Result()
+		if response.StatusCode != http.StatusOK {
+			t.Fatal(response.Status, recorder.Body.String())
+		}
+
+		var res api.RetryArchivingApiResponse
+		if err := json.NewDecoder(response.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Count != 1 {
+			t.Fatalf("unexpected number of queued jobs: %d", res.Count)
+		}
+
+		for i := 0; ; i++ {
+			job, err := restapi.JobRepository.FindById(stoppedJob.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job.MonitoringStatus == schema.MonitoringStatusArchivingSuccessful {
+				break
+			}
+			if i == 50 {
+				t.Fatal("job was not archived again")
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	})
+
 	t.Run("CheckDoubleStart", func(t *testing.T) {
 		// Starting a job with the same jobId and cluster should only be allowed if the startTime is far appart!
 		body := strings.Replace(startJobBody, `"startTime": 123456789`, `"startTime": 123456790`, -1)
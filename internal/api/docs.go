@@ -460,6 +460,49 @@ const docTemplate = `{
                 }
             }
         },
+        "/jobs/retry_archiving/": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Queue all jobs whose archiving has failed again. The jobs are archived asynchronously.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Job add and modify"
+                ],
+                "summary": "Retry archiving of failed jobs",
+                "responses": {
+                    "200": {
+                        "description": "Number of queued jobs",
+                        "schema": {
+                            "$ref": "#/definitions/api.RetryArchivingApiResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
         "/jobs/start_job/": {
             "post": {
                 "security": [
@@ -915,6 +958,123 @@ const docTemplate = `{
                 }
             }
         },
+        "/metricdata/health/": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get the circuit breaker state of the metric data repositories of all clusters.\nRepositories of a specific cluster can be requested using query parameter.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Cluster query"
+                ],
+                "summary": "Lists the health of all metric data repositories",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job Cluster",
+                        "name": "cluster",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Array of repositories",
+                        "schema": {
+                            "$ref": "#/definitions/api.GetMetricDataHealthApiResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/metricdata/write/": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Write metric data in InfluxDB line protocol to the embedded metric stores of the clusters given by the cluster tags.\nLines without cluster tag are written to the cluster given as query parameter.\nValid lines are written even if other lines fail.",
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Cluster query"
+                ],
+                "summary": "Adds metric data to the embedded metric store",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job Cluster",
+                        "name": "cluster",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Metric data in InfluxDB line protocol",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Success"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
         "/user/{id}": {
             "post": {
                 "security": [
@@ -1393,6 +1553,18 @@ const docTemplate = `{
                 }
             }
         },
+        "api.GetMetricDataHealthApiResponse": {
+            "type": "object",
+            "properties": {
+                "repositories": {
+                    "description": "Array of metric data repositories",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/metricdata.RepositoryHealth"
+                    }
+                }
+            }
+        },
         "api.JobMetricWithName": {
             "type": "object",
             "properties": {
@@ -1407,6 +1579,18 @@ const docTemplate = `{
                 }
             }
         },
+        "api.RetryArchivingApiResponse": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "description": "Number of jobs queued for archiving",
+                    "type": "integer"
+                },
+                "msg": {
+                    "type": "string"
+                }
+            }
+        },
         "api.StartJobApiResponse": {
             "type": "object",
             "properties": {
@@ -1454,6 +1638,32 @@ const docTemplate = `{
                 }
             }
         },
+        "metricdata.RepositoryHealth": {
+            "type": "object",
+            "properties": {
+                "cluster": {
+                    "type": "string"
+                },
+                "consecutiveFailures": {
+                    "type": "integer"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "lastError": {
+                    "type": "string"
+                },
+                "lastFailure": {
+                    "type": "string"
+                },
+                "lastSuccess": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string"
+                }
+            }
+        },
         "schema.Accelerator": {
             "type": "object",
             "properties": {
@@ -66,6 +66,7 @@ func (api *RestApi) MountRoutes(r *mux.Router) {
 	r.HandleFunc("/jobs/start_job/", api.startJob).Methods(http.MethodPost, http.MethodPut)
 	r.HandleFunc("/jobs/stop_job/", api.stopJobByRequest).Methods(http.MethodPost, http.MethodPut)
 	r.HandleFunc("/jobs/stop_job/{id}", api.stopJobById).Methods(http.MethodPost, http.MethodPut)
+	r.HandleFunc("/jobs/retry_archiving/", api.retryArchiving).Methods(http.MethodPost)
 	// r.HandleFunc("/jobs/import/", api.importJob).Methods(http.MethodPost, http.MethodPut)
 
 	r.HandleFunc("/jobs/", api.getJobs).Methods(http.MethodGet)
@@ -79,6 +80,8 @@ func (api *RestApi) MountRoutes(r *mux.Router) {
 	r.HandleFunc("/jobs/delete_job_before/{ts}", api.deleteJobBefore).Methods(http.MethodDelete)
 
 	r.HandleFunc("/clusters/", api.getClusters).Methods(http.MethodGet)
+	r.HandleFunc("/metricdata/health/", api.getMetricDataHealth).Methods(http.MethodGet)
+	r.HandleFunc("/metricdata/write/", api.writeMetricData).Methods(http.MethodPost)
 
 	if api.MachineStateDir != "" {
 		r.HandleFunc("/machine_state/{cluster}/{host}", api.getMachineState).Methods(http.MethodGet)
@@ -107,6 +110,12 @@ type DeleteJobApiResponse struct {
 	Message string `json:"msg"`
 }
 
+// RetryArchivingApiResponse model
+type RetryArchivingApiResponse struct {
+	Message string `json:"msg"`
+	Count   int    `json:"count"` // Number of jobs queued for archiving
+}
+
 // UpdateUserApiResponse model
 type UpdateUserApiResponse struct {
 	Message string `json:"msg"`
@@ -140,6 +149,11 @@ type GetClustersApiResponse struct {
 	Clusters []*schema.Cluster `json:"clusters"` // Array of clusters
 }
 
+// GetMetricDataHealthApiResponse model
+type GetMetricDataHealthApiResponse struct {
+	Repositories []*metricdata.RepositoryHealth `json:"repositories"` // Array of metric data repositories
+}
+
 // ErrorResponse model
 type ErrorResponse struct {
 	// Statustext of Errorcode
@@ -291,6 +305,80 @@ func (api *RestApi) getClusters(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// getMetricDataHealth godoc
+// @summary     Lists the health of all metric data repositories
+// @tags Cluster query
+// @description Get the circuit breaker state of the metric data repositories of all clusters.
+// @description Repositories of a specific cluster can be requested using query parameter.
+// @produce     json
+// @param       cluster        query    string            false "Job Cluster"
+// @success     200            {object} api.GetMetricDataHealthApiResponse  "Array of repositories"
+// @failure     400            {object} api.ErrorResponse       "Bad Request"
+// @failure     401            {object} api.ErrorResponse       "Unauthorized"
+// @failure     403            {object} api.ErrorResponse       "Forbidden"
+// @failure     500            {object} api.ErrorResponse       "Internal Server Error"
+// @security    ApiKeyAuth
+// @router      /metricdata/health/ [get]
+func (api *RestApi) getMetricDataHealth(rw http.ResponseWriter, r *http.Request) {
+	if user := repository.GetUserFromContext(r.Context()); user != nil &&
+		!user.HasRole(schema.RoleApi) {
+
+		handleError(fmt.Errorf("missing role: %v", schema.GetRoleString(schema.RoleApi)), http.StatusForbidden, rw)
+		return
+	}
+
+	name := r.URL.Query().Get("cluster")
+	if name != "" && archive.GetCluster(name) == nil {
+		handleError(fmt.Errorf("unknown cluster: %s", name), http.StatusBadRequest, rw)
+		return
+	}
+
+	rw.Header().Add("Content-Type", "application/json")
+	bw := bufio.NewWriter(rw)
+	defer bw.Flush()
+
+	payload := GetMetricDataHealthApiResponse{
+		Repositories: metricdata.GetHealth(name),
+	}
+
+	if err := json.NewEncoder(bw).Encode(payload); err != nil {
+		handleError(err, http.StatusInternalServerError, rw)
+		return
+	}
+}
+
+// writeMetricData godoc
+// @summary     Adds metric data to the embedded metric store
+// @tags Cluster query
+// @description Write metric data in InfluxDB line protocol to the embedded metric stores of the clusters given by the cluster tags.
+// @description Lines without cluster tag are written to the cluster given as query parameter.
+// @description Valid lines are written even if other lines fail.
+// @accept      plain
+// @produce     json
+// @param       cluster        query    string            false "Job Cluster"
+// @param       request        body     string            true  "Metric data in InfluxDB line protocol"
+// @success     200            "Success"
+// @failure     400            {object} api.ErrorResponse       "Bad Request"
+// @failure     401            {object} api.ErrorResponse       "Unauthorized"
+// @failure     403            {object} api.ErrorResponse       "Forbidden"
+// @security    ApiKeyAuth
+// @router      /metricdata/write/ [post]
+func (api *RestApi) writeMetricData(rw http.ResponseWriter, r *http.Request) {
+	if user := repository.GetUserFromContext(r.Context()); user != nil &&
+		!user.HasRole(schema.RoleApi) {
+
+		handleError(fmt.Errorf("missing role: %v", schema.GetRoleString(schema.RoleApi)), http.StatusForbidden, rw)
+		return
+	}
+
+	if err := metricdata.WriteLineProtocol(r.URL.Query().Get("cluster"), r.Body); err != nil {
+		handleError(err, http.StatusBadRequest, rw)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
 // getJobs godoc
 // @summary     Lists all jobs
 // @tags Job query
@@ -504,7 +592,7 @@ func (api *RestApi) getCompleteJobById(rw http.ResponseWriter, r *http.Request)
 	var data schema.JobData
 
 	if r.URL.Query().Get("all-metrics") == "true" {
-		data, err = metricdata.LoadData(job, nil, scopes, r.Context())
+		data, err = metricdata.LoadData(job, nil, scopes, 0, r.Context())
 		if err != nil {
 			log.Warn("Error while loading job data")
 			return
@@ -601,7 +689,7 @@ func (api *RestApi) getJobById(rw http.ResponseWriter, r *http.Request) {
 		scopes = []schema.MetricScope{"node"}
 	}
 
-	data, err := metricdata.LoadData(job, metrics, scopes, r.Context())
+	data, err := metricdata.LoadData(job, metrics, scopes, 0, r.Context())
 	if err != nil {
 		log.Warn("Error while loading job data")
 		return
@@ -1093,6 +1181,38 @@ func (api *RestApi) deleteJobBefore(rw http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// retryArchiving godoc
+// @summary     Retry archiving of failed jobs
+// @tags Job add and modify
+// @description Queue all jobs whose archiving has failed again. The jobs are archived asynchronously.
+// @produce     json
+// @success     200     {object} api.RetryArchivingApiResponse "Number of queued jobs"
+// @failure     401     {object} api.ErrorResponse             "Unauthorized"
+// @failure     403     {object} api.ErrorResponse             "Forbidden"
+// @failure     500     {object} api.ErrorResponse             "Internal Server Error"
+// @security    ApiKeyAuth
+// @router      /jobs/retry_archiving/ [post]
+func (api *RestApi) retryArchiving(rw http.ResponseWriter, r *http.Request) {
+	if user := repository.GetUserFromContext(r.Context()); user != nil && !user.HasRole(schema.RoleApi) {
+		handleError(fmt.Errorf("missing role: %v", schema.GetRoleString(schema.RoleApi)), http.StatusForbidden, rw)
+		return
+	}
+
+	cnt, err := api.JobRepository.RetryFailedArchiving()
+	if err != nil {
+		handleError(fmt.Errorf("queueing jobs for archiving failed: %w", err), http.StatusInternalServerError, rw)
+		return
+	}
+	api.JobRepository.StartArchiving()
+
+	rw.Header().Add("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(RetryArchivingApiResponse{
+		Message: fmt.Sprintf("Successfully queued %d jobs for archiving", cnt),
+		Count:   cnt,
+	})
+}
+
 func (api *RestApi) checkAndHandleStopJob(rw http.ResponseWriter, job *schema.Job, req StopJobApiRequest) {
 	// Sanity checks
 	if job == nil || job.StartTime.Unix() >= req.StopTime || job.State != schema.JobStateRunning {
@@ -1146,6 +1266,17 @@ func (api *RestApi) getJobMetrics(rw http.ResponseWriter, r *http.Request) {
 		scopes = append(scopes, s)
 	}
 
+	var maxPoints *int
+	if v := r.URL.Query().Get("maxPoints"); v != "" {
+		points, err := strconv.Atoi(v)
+		if err != nil || points < 0 {
+			http.Error(rw, fmt.Sprintf("invalid maxPoints: %s", v), http.StatusBadRequest)
+			return
+		}
+		maxPoints = &points
+	}
+	units := r.URL.Query()["unit"]
+
 	rw.Header().Add("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusOK)
 
@@ -1158,7 +1289,7 @@ func (api *RestApi) getJobMetrics(rw http.ResponseWriter, r *http.Request) {
 		} `json:"error"`
 	}
 
-	data, err := api.Resolver.Query().JobMetrics(r.Context(), id, metrics, scopes)
+	data, err := api.Resolver.Query().JobMetrics(r.Context(), id, metrics, scopes, maxPoints, units)
 	if err != nil {
 		json.NewEncoder(rw).Encode(Respone{
 			Error: &struct {
@@ -21,6 +21,9 @@ var Keys schema.ProgramConfig = schema.ProgramConfig{
 	DB:                        "./var/job.db",
 	Archive:                   json.RawMessage(`{\"kind\":\"file\",\"path\":\"./var/job-archive\"}`),
 	DisableArchive:            false,
+	ArchiveWorkers:            4,
+	ArchiveTimeout:            300,
+	StatisticsPercentiles:     []int{10, 25, 50, 75, 90},
 	Validate:                  false,
 	SessionMaxAge:             "168h",
 	StopJobsExceedingWalltime: 0,
@@ -142,6 +142,11 @@ type PageRequest struct {
 	Page         int `json:"page"`
 }
 
+type PercentileSeries struct {
+	Percentile int            `json:"percentile"`
+	Data       []schema.Float `json:"data"`
+}
+
 type Query struct {
 }
 
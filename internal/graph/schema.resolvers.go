@@ -8,6 +8,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"time"
 
@@ -26,6 +27,19 @@ func (r *clusterResolver) Partitions(ctx context.Context, obj *schema.Cluster) (
 	return r.Repo.Partitions(obj.Name)
 }
 
+// MetricConfig is the resolver for the metricConfig field.
+func (r *clusterResolver) MetricConfig(ctx context.Context, obj *schema.Cluster, units []string) ([]*schema.MetricConfig, error) {
+	if len(units) == 0 {
+		return obj.MetricConfig, nil
+	}
+
+	res := make([]*schema.MetricConfig, 0, len(obj.MetricConfig))
+	for _, mc := range obj.MetricConfig {
+		res = append(res, metricdata.ConvertMetricConfig(mc, units))
+	}
+	return res, nil
+}
+
 // Tags is the resolver for the tags field.
 func (r *jobResolver) Tags(ctx context.Context, obj *schema.Job) ([]*schema.Tag, error) {
 	return r.Repo.GetTags(&obj.ID)
@@ -188,14 +202,19 @@ func (r *queryResolver) Job(ctx context.Context, id string) (*schema.Job, error)
 }
 
 // JobMetrics is the resolver for the jobMetrics field.
-func (r *queryResolver) JobMetrics(ctx context.Context, id string, metrics []string, scopes []schema.MetricScope) ([]*model.JobMetricWithName, error) {
+func (r *queryResolver) JobMetrics(ctx context.Context, id string, metrics []string, scopes []schema.MetricScope, maxPoints *int, units []string) ([]*model.JobMetricWithName, error) {
 	job, err := r.Query().Job(ctx, id)
 	if err != nil {
 		log.Warn("Error while querying job for metrics")
 		return nil, err
 	}
 
-	data, err := metricdata.LoadData(job, metrics, scopes, ctx)
+	points := 0
+	if maxPoints != nil {
+		points = *maxPoints
+	}
+
+	data, err := metricdata.LoadData(job, metrics, scopes, points, ctx)
 	if err != nil {
 		log.Warn("Error while loading job data")
 		return nil, err
@@ -207,7 +226,7 @@ func (r *queryResolver) JobMetrics(ctx context.Context, id string, metrics []str
 			res = append(res, &model.JobMetricWithName{
 				Name:   name,
 				Scope:  scope,
-				Metric: metric,
+				Metric: metricdata.ConvertJobMetric(metric, units),
 			})
 		}
 	}
@@ -261,7 +280,7 @@ func (r *queryResolver) Jobs(ctx context.Context, filter []*model.JobFilter, pag
 }
 
 // JobsStatistics is the resolver for the jobsStatistics field.
-func (r *queryResolver) JobsStatistics(ctx context.Context, filter []*model.JobFilter, metrics []string, page *model.PageRequest, sortBy *model.SortByAggregate, groupBy *model.Aggregate) ([]*model.JobsStatistics, error) {
+func (r *queryResolver) JobsStatistics(ctx context.Context, filter []*model.JobFilter, metrics []string, page *model.PageRequest, sortBy *model.SortByAggregate, groupBy *model.Aggregate, units []string) ([]*model.JobsStatistics, error) {
 	var err error
 	var stats []*model.JobsStatistics
 
@@ -314,6 +333,7 @@ func (r *queryResolver) JobsStatistics(ctx context.Context, filter []*model.JobF
 			if err != nil {
 				return nil, err
 			}
+			convertMetricHistograms(stats[0].HistMetrics, units)
 		} else {
 			return nil, errors.New("metric histograms only implemented without groupBy argument")
 		}
@@ -328,7 +348,7 @@ func (r *queryResolver) RooflineHeatmap(ctx context.Context, filter []*model.Job
 }
 
 // NodeMetrics is the resolver for the nodeMetrics field.
-func (r *queryResolver) NodeMetrics(ctx context.Context, cluster string, nodes []string, scopes []schema.MetricScope, metrics []string, from time.Time, to time.Time) ([]*model.NodeMetrics, error) {
+func (r *queryResolver) NodeMetrics(ctx context.Context, cluster string, nodes []string, scopes []schema.MetricScope, metrics []string, from time.Time, to time.Time, units []string) ([]*model.NodeMetrics, error) {
 	user := repository.GetUserFromContext(ctx)
 	if user != nil && !user.HasRole(schema.RoleAdmin) {
 		return nil, errors.New("you need to be an administrator for this query")
@@ -359,7 +379,7 @@ func (r *queryResolver) NodeMetrics(ctx context.Context, cluster string, nodes [
 				host.Metrics = append(host.Metrics, &model.JobMetricWithName{
 					Name:   metric,
 					Scope:  schema.MetricScopeNode,
-					Metric: scopedMetric,
+					Metric: metricdata.ConvertJobMetric(scopedMetric, units),
 				})
 			}
 		}
@@ -370,6 +390,31 @@ func (r *queryResolver) NodeMetrics(ctx context.Context, cluster string, nodes [
 	return nodeMetrics, nil
 }
 
+// MetricDataHealth is the resolver for the metricDataHealth field.
+func (r *queryResolver) MetricDataHealth(ctx context.Context, cluster *string) ([]*metricdata.RepositoryHealth, error) {
+	name := ""
+	if cluster != nil {
+		name = *cluster
+	}
+	return metricdata.GetHealth(name), nil
+}
+
+// Percentiles is the resolver for the percentiles field.
+func (r *statsSeriesResolver) Percentiles(ctx context.Context, obj *schema.StatsSeries) ([]*model.PercentileSeries, error) {
+	if obj.Percentiles == nil {
+		return nil, nil
+	}
+
+	res := make([]*model.PercentileSeries, 0, len(obj.Percentiles))
+	for p, data := range obj.Percentiles {
+		res = append(res, &model.PercentileSeries{Percentile: p, Data: data})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Percentile < res[j].Percentile
+	})
+	return res, nil
+}
+
 // NumberOfNodes is the resolver for the numberOfNodes field.
 func (r *subClusterResolver) NumberOfNodes(ctx context.Context, obj *schema.SubCluster) (int, error) {
 	nodeList, err := archive.ParseNodeList(obj.Nodes)
@@ -391,6 +436,9 @@ func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResol
 // Query returns generated.QueryResolver implementation.
 func (r *Resolver) Query() generated.QueryResolver { return &queryResolver{r} }
 
+// StatsSeries returns generated.StatsSeriesResolver implementation.
+func (r *Resolver) StatsSeries() generated.StatsSeriesResolver { return &statsSeriesResolver{r} }
+
 // SubCluster returns generated.SubClusterResolver implementation.
 func (r *Resolver) SubCluster() generated.SubClusterResolver { return &subClusterResolver{r} }
 
@@ -398,4 +446,5 @@ type clusterResolver struct{ *Resolver }
 type jobResolver struct{ *Resolver }
 type mutationResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
+type statsSeriesResolver struct{ *Resolver }
 type subClusterResolver struct{ *Resolver }
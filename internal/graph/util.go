@@ -47,7 +47,7 @@ func (r *queryResolver) rooflineHeatmap(
 			continue
 		}
 
-		jobdata, err := metricdata.LoadData(job, []string{"flops_any", "mem_bw"}, []schema.MetricScope{schema.MetricScopeNode}, ctx)
+		jobdata, err := metricdata.LoadData(job, []string{"flops_any", "mem_bw"}, []schema.MetricScope{schema.MetricScopeNode}, 0, ctx)
 		if err != nil {
 			log.Errorf("Error while loading roofline metrics for job %d", job.ID)
 			return nil, err
@@ -115,16 +115,20 @@ func (r *queryResolver) jobsFootprints(ctx context.Context, filter []*model.JobF
 	timeweights.AccHours = make([]schema.Float, 0, len(jobs))
 	timeweights.CoreHours = make([]schema.Float, 0, len(jobs))
 
+	monitored := make([]*schema.Job, 0, len(jobs))
 	for _, job := range jobs {
 		if job.MonitoringStatus == schema.MonitoringStatusDisabled || job.MonitoringStatus == schema.MonitoringStatusArchivingFailed {
 			continue
 		}
+		monitored = append(monitored, job)
+	}
 
-		if err := metricdata.LoadAverages(job, metrics, avgs, ctx); err != nil {
-			log.Error("Error while loading averages for footprint")
-			return nil, err
-		}
+	if err := metricdata.LoadAveragesBatch(monitored, metrics, avgs, ctx); err != nil {
+		log.Error("Error while loading averages for footprint")
+		return nil, err
+	}
 
+	for _, job := range monitored {
 		// #166 collect arrays: Null values or no null values?
 		timeweights.NodeHours = append(timeweights.NodeHours, schema.Float(float64(job.Duration)/60.0*float64(job.NumNodes)))
 		if job.NumAcc > 0 {
@@ -176,6 +180,47 @@ func (r *queryResolver) jobsFootprints(ctx context.Context, filter []*model.JobF
 // 	return totalJobCores
 // }
 
+// Convert the bin boundaries of metric histograms to the first matching unit
+// of units. The boundaries are integers, so a histogram is kept in its unit if
+// any converted boundary would have to be rounded.
+func convertMetricHistograms(hists []*model.MetricHistoPoints, units []string) {
+	for _, hist := range hists {
+		conv, unit, ok := metricdata.UnitConversion(schema.Unit{Base: hist.Unit}, units)
+		if !ok || !integralBoundaries(hist.Data, conv) {
+			continue
+		}
+
+		hist.Unit = unit.Prefix + unit.Base
+		for _, point := range hist.Data {
+			if point.Min != nil {
+				min := int(math.Round(conv(float64(*point.Min))))
+				point.Min = &min
+			}
+			if point.Max != nil {
+				max := int(math.Round(conv(float64(*point.Max))))
+				point.Max = &max
+			}
+		}
+	}
+}
+
+func integralBoundaries(points []*model.MetricHistoPoint, conv func(float64) float64) bool {
+	integral := func(x *int) bool {
+		if x == nil {
+			return true
+		}
+		y := conv(float64(*x))
+		return math.Abs(y-math.Round(y)) <= 1e-9*math.Max(1, math.Abs(y))
+	}
+
+	for _, point := range points {
+		if !integral(point.Min) || !integral(point.Max) {
+			return false
+		}
+	}
+	return true
+}
+
 func requireField(ctx context.Context, name string) bool {
 	fields := graphql.CollectAllFields(ctx)
 
@@ -0,0 +1,29 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package graph
+
+import (
+	"testing"
+
+	"github.com/ClusterCockpit/cc-backend/internal/graph/model"
+)
+
+func TestConvertMetricHistograms(t *testing.T) {
+	point := func(min, max int) *model.MetricHistoPoint {
+		return &model.MetricHistoPoint{Min: &min, Max: &max}
+	}
+	hists := []*model.MetricHistoPoints{
+		{Metric: "mem_used", Unit: "MB", Data: []*model.MetricHistoPoint{point(0, 1000), point(1000, 2000)}},
+		{Metric: "mem_bw", Unit: "MB/s", Data: []*model.MetricHistoPoint{point(0, 1500)}},
+	}
+
+	convertMetricHistograms(hists, []string{"GB", "GB/s"})
+	if h := hists[0]; h.Unit != "GB" || *h.Data[0].Max != 1 || *h.Data[1].Max != 2 {
+		t.Fatalf("unexpected histogram %s %+v", h.Unit, *h.Data[1])
+	}
+	if h := hists[1]; h.Unit != "MB/s" || *h.Data[0].Max != 1500 {
+		t.Fatalf("expected histogram with fractional boundaries to be unchanged, got %s", h.Unit)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/ClusterCockpit/cc-backend/internal/repository"
 	"github.com/ClusterCockpit/cc-backend/pkg/archive"
 	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
 )
 
 func copyFile(s string, d string) error {
@@ -173,3 +174,191 @@ func TestHandleImportFlag(t *testing.T) {
 		})
 	}
 }
+
+func TestReconcile(t *testing.T) {
+	r := setup(t)
+
+	// The database connection is shared between tests, remove the job
+	// in case it was already imported
+	result := readResult(t, "fritzMinimal")
+	if job, err := r.Find(&result.JobId, &result.Cluster, &result.StartTime); err == nil {
+		if err := r.DeleteJobById(job.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := fmt.Sprintf("%s:%s", filepath.Join("testdata", "meta-fritzMinimal.input"),
+		filepath.Join("testdata", "data-fritzMinimal.json"))
+	if err := importer.HandleImportFlag(s); err != nil {
+		t.Fatal(err)
+	}
+
+	// Jobs of other tests are not part of the fresh archive
+	report, err := importer.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != 0 {
+		t.Fatalf("unexpected orphans %+v", report.Orphans)
+	}
+	if err := importer.MarkMissing(report); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = importer.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != 0 || len(report.Missing) != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	job, err := r.Find(&result.JobId, &result.Cluster, &result.StartTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteJobById(job.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = importer.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0].JobID != result.JobId {
+		t.Fatalf("expected 1 orphan, got %d", len(report.Orphans))
+	}
+	if n, err := importer.ImportOrphans(report); err != nil || n != 1 {
+		t.Fatalf("import of orphans failed: %d, %v", n, err)
+	}
+
+	job, err = r.Find(&result.JobId, &result.Cluster, &result.StartTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive.GetHandle().CleanUp([]*schema.Job{job})
+
+	report, err = importer.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != 0 || len(report.Missing) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if err := importer.MarkMissing(report); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err = r.FindById(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.MonitoringStatus != schema.MonitoringStatusArchivingFailed {
+		t.Errorf("wrong monitoring status\ngot: %d \nwant: %d", job.MonitoringStatus, schema.MonitoringStatusArchivingFailed)
+	}
+}
+
+func TestSyncDB(t *testing.T) {
+	r := setup(t)
+
+	result := readResult(t, "fritzMinimal")
+	if job, err := r.Find(&result.JobId, &result.Cluster, &result.StartTime); err == nil {
+		if err := r.DeleteJobById(job.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := fmt.Sprintf("%s:%s", filepath.Join("testdata", "meta-fritzMinimal.input"),
+		filepath.Join("testdata", "data-fritzMinimal.json"))
+	if err := importer.HandleImportFlag(s); err != nil {
+		t.Fatal(err)
+	}
+	job, err := r.Find(&result.JobId, &result.Cluster, &result.StartTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteJobById(job.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := importer.SyncDB(); err != nil {
+		t.Fatal(err)
+	}
+	job, err = r.Find(&result.JobId, &result.Cluster, &result.StartTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Duration != result.Duration {
+		t.Errorf("wrong duration for job\ngot: %d \nwant: %d", job.Duration, result.Duration)
+	}
+
+	// A monitoring status set by -verify or -mark-missing is kept
+	if _, err := r.DB.Exec(`UPDATE job SET duration = ?, monitoring_status = ? WHERE id = ?`,
+		1, schema.MonitoringStatusArchivingFailed, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := importer.SyncDB(); err != nil {
+		t.Fatal(err)
+	}
+
+	synced, err := r.Find(&result.JobId, &result.Cluster, &result.StartTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if synced.ID != job.ID {
+		t.Errorf("job was inserted again\ngot id: %d \nwant id: %d", synced.ID, job.ID)
+	}
+	if synced.Duration != result.Duration {
+		t.Errorf("wrong duration for synced job\ngot: %d \nwant: %d", synced.Duration, result.Duration)
+	}
+	if synced.MonitoringStatus != schema.MonitoringStatusArchivingFailed {
+		t.Errorf("wrong monitoring status\ngot: %d \nwant: %d", synced.MonitoringStatus, schema.MonitoringStatusArchivingFailed)
+	}
+
+	// Running jobs are left alone
+	if _, err := r.DB.Exec(`UPDATE job SET job_state = ?, monitoring_status = ? WHERE id = ?`,
+		schema.JobStateRunning, schema.MonitoringStatusRunningOrArchiving, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := importer.SyncDB(); err != nil {
+		t.Fatal(err)
+	}
+	synced, err = r.FindById(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if synced.State != schema.JobStateRunning || synced.MonitoringStatus != schema.MonitoringStatusRunningOrArchiving {
+		t.Errorf("running job was updated\ngot: %s, %d", synced.State, synced.MonitoringStatus)
+	}
+}
+
+func TestInitDB(t *testing.T) {
+	r := setup(t)
+
+	// Start with an empty job table
+	if err := importer.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := fmt.Sprintf("%s:%s,%s:%s",
+		filepath.Join("testdata", "meta-fritzMinimal.input"), filepath.Join("testdata", "data-fritzMinimal.json"),
+		filepath.Join("testdata", "meta-fritzError.input"), filepath.Join("testdata", "data-fritzError.json"))
+	if err := importer.HandleImportFlag(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := importer.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, testname := range []string{"fritzMinimal", "fritzError"} {
+		result := readResult(t, testname)
+		job, err := r.Find(&result.JobId, &result.Cluster, &result.StartTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Duration != result.Duration {
+			t.Errorf("wrong duration for job\ngot: %d \nwant: %d", job.Duration, result.Duration)
+		}
+	}
+}
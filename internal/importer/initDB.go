@@ -7,7 +7,10 @@ package importer
 import (
 	"encoding/json"
 	"fmt"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ClusterCockpit/cc-backend/internal/repository"
@@ -16,6 +19,9 @@ import (
 	"github.com/ClusterCockpit/cc-backend/pkg/schema"
 )
 
+// Number of jobs inserted per transaction by InitDB
+const initDBBatchSize = 1000
+
 // Delete the tables "job", "tag" and "jobtag" from the database and
 // repopulate them using the jobs found in `archive`.
 func InitDB() error {
@@ -27,10 +33,59 @@ func InitDB() error {
 	starttime := time.Now()
 	log.Print("Building job table...")
 
+	// The archived jobs are converted by a pool of workers while a single
+	// writer bundles the inserts into transactions, as sqlite only
+	// supports one writer at a time.
+	var errorOccured int64
+	jobs := make(chan *schema.Job, initDBBatchSize)
+	containers := archive.GetHandle().Iter(false)
+
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for jobContainer := range containers {
+				jobMeta := jobContainer.Meta
+				jobMeta.MonitoringStatus = schema.MonitoringStatusArchivingSuccessful
+				job, err := buildJob(jobMeta)
+				if err != nil {
+					log.Errorf("repository initDB(): %v", err)
+					atomic.AddInt64(&errorOccured, 1)
+					continue
+				}
+				jobs <- job
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(jobs)
+	}()
+
+	n, err := insertJobs(r, jobs, starttime)
+	if err != nil {
+		// Drain the channel so that the workers can terminate
+		for range jobs {
+		}
+		return err
+	}
+
+	if cnt := atomic.LoadInt64(&errorOccured); cnt > 0 {
+		log.Warnf("Error in import of %d jobs!", cnt)
+	}
+
+	log.Printf("A total of %d jobs have been registered in %.3f seconds.\n", n, time.Since(starttime).Seconds())
+	return nil
+}
+
+// Insert all jobs received from the channel in batches of initDBBatchSize
+// jobs per transaction. Returns the number of inserted jobs.
+func insertJobs(r *repository.JobRepository, jobs <-chan *schema.Job, starttime time.Time) (int, error) {
 	t, err := r.TransactionInit()
 	if err != nil {
 		log.Warn("Error while initializing SQL transactions")
-		return err
+		return 0, err
 	}
 	tags := make(map[string]int64)
 
@@ -39,58 +94,132 @@ func InitDB() error {
 	// is passed anyways.
 	fmt.Printf("%d jobs inserted...\r", 0)
 
-	ar := archive.GetHandle()
 	i := 0
 	errorOccured := 0
+	for job := range jobs {
+		id, err := r.TransactionAdd(t, *job)
+		if err != nil {
+			log.Errorf("repository initDB(): %v", err)
+			errorOccured++
+			continue
+		}
 
-	for jobContainer := range ar.Iter(false) {
-
-		jobMeta := jobContainer.Meta
+		for _, tag := range job.Tags {
+			tagstr := tag.Name + ":" + tag.Type
+			tagId, ok := tags[tagstr]
+			if !ok {
+				tagId, err = r.TransactionAddTag(t, tag)
+				if err != nil {
+					log.Errorf("Error adding tag: %v", err)
+					errorOccured++
+					continue
+				}
+				tags[tagstr] = tagId
+			}
 
-		// Bundle 100 inserts into one transaction for better performance
-		if i%100 == 0 {
-			r.TransactionCommit(t)
-			fmt.Printf("%d jobs inserted...\r", i)
+			r.TransactionSetTag(t, id, tagId)
 		}
 
-		jobMeta.MonitoringStatus = schema.MonitoringStatusArchivingSuccessful
-		job := schema.Job{
-			BaseJob:       jobMeta.BaseJob,
-			StartTime:     time.Unix(jobMeta.StartTime, 0),
-			StartTimeUnix: jobMeta.StartTime,
+		i += 1
+		if i%initDBBatchSize == 0 {
+			if err := r.TransactionCommit(t); err != nil {
+				return i, err
+			}
+			fmt.Printf("%d jobs inserted (%.0f jobs/s)...\r", i, float64(i)/time.Since(starttime).Seconds())
 		}
+	}
 
-		// TODO: Other metrics...
-		job.LoadAvg = loadJobStat(jobMeta, "cpu_load")
-		job.FlopsAnyAvg = loadJobStat(jobMeta, "flops_any")
-		job.MemUsedMax = loadJobStat(jobMeta, "mem_used")
-		job.MemBwAvg = loadJobStat(jobMeta, "mem_bw")
-		job.NetBwAvg = loadJobStat(jobMeta, "net_bw")
-		job.FileBwAvg = loadJobStat(jobMeta, "file_bw")
+	if errorOccured > 0 {
+		log.Warnf("Error in insert of %d jobs!", errorOccured)
+	}
 
-		job.RawResources, err = json.Marshal(job.Resources)
-		if err != nil {
-			log.Errorf("repository initDB(): %v", err)
-			errorOccured++
-			continue
+	if err := r.TransactionEnd(t); err != nil {
+		return i, err
+	}
+	return i, nil
+}
+
+// Walk the jobs found in `archive` and insert those missing in the table
+// "job". Jobs already present (matched on job id, cluster and start time)
+// keep their database id, tags and monitoring status, only duration, state
+// and statistics are updated if they changed. Running jobs are not affected.
+func SyncDB() error {
+	r := repository.GetJobRepository()
+	starttime := time.Now()
+	log.Print("Syncing job table...")
+
+	dbJobs, err := r.FindAllFootprints()
+	if err != nil {
+		log.Errorf("repository syncDB(): %v", err)
+		return err
+	}
+	known := make(map[jobKey]*schema.Job, len(dbJobs))
+	for _, job := range dbJobs {
+		known[jobKey{job.Cluster, job.JobID, job.StartTimeUnix}] = job
+	}
+
+	dbTags, err := r.GetTags(nil)
+	if err != nil {
+		log.Errorf("repository syncDB(): %v", err)
+		return err
+	}
+	tags := make(map[string]int64, len(dbTags))
+	for _, tag := range dbTags {
+		tags[tag.Name+":"+tag.Type] = tag.ID
+	}
+
+	t, err := r.TransactionInit()
+	if err != nil {
+		log.Warn("Error while initializing SQL transactions")
+		return err
+	}
+
+	fmt.Printf("%d jobs checked...\r", 0)
+
+	ar := archive.GetHandle()
+	i, inserted, updated := 0, 0, 0
+	errorOccured := 0
+
+	for jobContainer := range ar.Iter(false) {
+		jobMeta := jobContainer.Meta
+
+		// Bundle changes into one transaction for better performance
+		if i > 0 && i%initDBBatchSize == 0 {
+			if err := r.TransactionCommit(t); err != nil {
+				log.Errorf("repository syncDB(): %v", err)
+				return err
+			}
+			fmt.Printf("%d jobs checked...\r", i)
 		}
+		i += 1
 
-		job.RawMetaData, err = json.Marshal(job.MetaData)
+		jobMeta.MonitoringStatus = schema.MonitoringStatusArchivingSuccessful
+		job, err := buildJob(jobMeta)
 		if err != nil {
-			log.Errorf("repository initDB(): %v", err)
+			log.Errorf("repository syncDB(): %v", err)
 			errorOccured++
 			continue
 		}
 
-		if err := SanityChecks(&job.BaseJob); err != nil {
-			log.Errorf("repository initDB(): %v", err)
-			errorOccured++
+		if dbJob, ok := known[jobKey{job.Cluster, job.JobID, job.StartTimeUnix}]; ok {
+			// Running jobs are owned by the REST API, the archive may hold a
+			// stale copy of them.
+			if dbJob.State == schema.JobStateRunning || !jobChanged(dbJob, job) {
+				continue
+			}
+
+			if err := r.TransactionUpdate(t, dbJob.ID, *job); err != nil {
+				log.Errorf("repository syncDB(): %v", err)
+				errorOccured++
+				continue
+			}
+			updated++
 			continue
 		}
 
-		id, err := r.TransactionAdd(t, job)
+		id, err := r.TransactionAdd(t, *job)
 		if err != nil {
-			log.Errorf("repository initDB(): %v", err)
+			log.Errorf("repository syncDB(): %v", err)
 			errorOccured++
 			continue
 		}
@@ -110,21 +239,70 @@ func InitDB() error {
 
 			r.TransactionSetTag(t, id, tagId)
 		}
-
-		if err == nil {
-			i += 1
-		}
+		inserted++
 	}
 
 	if errorOccured > 0 {
-		log.Warnf("Error in import of %d jobs!", errorOccured)
+		log.Warnf("Error in sync of %d jobs!", errorOccured)
 	}
 
-	r.TransactionEnd(t)
-	log.Printf("A total of %d jobs have been registered in %.3f seconds.\n", i, time.Since(starttime).Seconds())
+	if err := r.TransactionEnd(t); err != nil {
+		log.Errorf("repository syncDB(): %v", err)
+		return err
+	}
+	log.Printf("Checked %d jobs in %.3f seconds: %d inserted, %d updated.\n",
+		i, time.Since(starttime).Seconds(), inserted, updated)
 	return nil
 }
 
+func jobChanged(dbJob *schema.Job, job *schema.Job) bool {
+	return dbJob.Duration != job.Duration ||
+		dbJob.State != job.State ||
+		dbJob.MemUsedMax != job.MemUsedMax ||
+		dbJob.FlopsAnyAvg != job.FlopsAnyAvg ||
+		dbJob.MemBwAvg != job.MemBwAvg ||
+		dbJob.LoadAvg != job.LoadAvg ||
+		dbJob.NetBwAvg != job.NetBwAvg ||
+		dbJob.FileBwAvg != job.FileBwAvg
+}
+
+// Create a job for insertion into the database from its archived meta
+// data. The statistics footprint is taken from the job statistics.
+func buildJob(jobMeta *schema.JobMeta) (*schema.Job, error) {
+	job := schema.Job{
+		BaseJob:       jobMeta.BaseJob,
+		StartTime:     time.Unix(jobMeta.StartTime, 0),
+		StartTimeUnix: jobMeta.StartTime,
+	}
+
+	// TODO: Other metrics...
+	job.LoadAvg = loadJobStat(jobMeta, "cpu_load")
+	job.FlopsAnyAvg = loadJobStat(jobMeta, "flops_any")
+	job.MemUsedMax = loadJobStat(jobMeta, "mem_used")
+	job.MemBwAvg = loadJobStat(jobMeta, "mem_bw")
+	job.NetBwAvg = loadJobStat(jobMeta, "net_bw")
+	job.FileBwAvg = loadJobStat(jobMeta, "file_bw")
+
+	var err error
+	job.RawResources, err = json.Marshal(job.Resources)
+	if err != nil {
+		log.Warn("Error while marshaling job resources")
+		return nil, err
+	}
+	job.RawMetaData, err = json.Marshal(job.MetaData)
+	if err != nil {
+		log.Warn("Error while marshaling job metadata")
+		return nil, err
+	}
+
+	if err := SanityChecks(&job.BaseJob); err != nil {
+		log.Warn("BaseJob SanityChecks failed")
+		return nil, err
+	}
+
+	return &job, nil
+}
+
 // This function also sets the subcluster if necessary!
 func SanityChecks(job *schema.BaseJob) error {
 	if c := archive.GetCluster(job.Cluster); c == nil {
@@ -0,0 +1,111 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package importer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/ClusterCockpit/cc-backend/internal/repository"
+	"github.com/ClusterCockpit/cc-backend/pkg/archive"
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+type ReconcileReport struct {
+	Orphans []*schema.JobMeta // Archived jobs without entry in the job table
+	Missing []*schema.Job     // Jobs marked as archived but not found in the archive
+}
+
+type jobKey struct {
+	cluster   string
+	jobID     int64
+	startTime int64
+}
+
+// Cross-check the job table against the jobs found in `archive`.
+func Reconcile() (*ReconcileReport, error) {
+	r := repository.GetJobRepository()
+	report := &ReconcileReport{}
+	archived := make(map[jobKey]bool)
+
+	for jobContainer := range archive.GetHandle().Iter(false) {
+		jobMeta := jobContainer.Meta
+		archived[jobKey{jobMeta.Cluster, jobMeta.JobID, jobMeta.StartTime}] = true
+
+		_, err := r.Find(&jobMeta.JobID, &jobMeta.Cluster, &jobMeta.StartTime)
+		if errors.Is(err, sql.ErrNoRows) {
+			report.Orphans = append(report.Orphans, jobMeta)
+		} else if err != nil {
+			log.Errorf("repository Reconcile(): %v", err)
+			return nil, err
+		}
+	}
+
+	jobs, err := r.FindJobsByMonitoringStatus(schema.MonitoringStatusArchivingSuccessful)
+	if err != nil {
+		log.Errorf("repository Reconcile(): %v", err)
+		return nil, err
+	}
+
+	for _, job := range jobs {
+		if !archived[jobKey{job.Cluster, job.JobID, job.StartTime.Unix()}] {
+			report.Missing = append(report.Missing, job)
+		}
+	}
+
+	return report, nil
+}
+
+// Insert the orphaned jobs of the report into the job table. Returns the
+// number of inserted jobs.
+func ImportOrphans(report *ReconcileReport) (int, error) {
+	r := repository.GetJobRepository()
+	cnt := 0
+
+	for _, jobMeta := range report.Orphans {
+		jobMeta.MonitoringStatus = schema.MonitoringStatusArchivingSuccessful
+		job, err := buildJob(jobMeta)
+		if err != nil {
+			log.Errorf("Skip orphan %d on %s: %v", jobMeta.JobID, jobMeta.Cluster, err)
+			continue
+		}
+
+		id, err := r.InsertJob(job)
+		if err != nil {
+			log.Warn("Error while job db insert")
+			return cnt, err
+		}
+
+		for _, tag := range job.Tags {
+			if _, err := r.AddTagOrCreate(id, tag.Type, tag.Name); err != nil {
+				log.Error("Error while adding or creating tag")
+				return cnt, err
+			}
+		}
+		cnt++
+	}
+
+	if cnt != len(report.Orphans) {
+		return cnt, fmt.Errorf("REPOSITORY/RECONCILE > %d orphans could not be imported", len(report.Orphans)-cnt)
+	}
+	return cnt, nil
+}
+
+// Set the monitoring status of all missing jobs of the report to
+// MonitoringStatusArchivingFailed.
+func MarkMissing(report *ReconcileReport) error {
+	r := repository.GetJobRepository()
+
+	for _, job := range report.Missing {
+		if err := r.UpdateMonitoringStatus(job.ID, schema.MonitoringStatusArchivingFailed); err != nil {
+			log.Errorf("Error while updating monitoring status of job %d", job.ID)
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,341 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+// Configuration of the "circuit-breaker" key of a metric data repository.
+// Durations are parsed using time.ParseDuration.
+type CircuitBreakerConfig struct {
+	// Timeout of a single attempt
+	Timeout string `json:"timeout"`
+	// Number of retries of a failed call
+	Retries int `json:"retries"`
+	// Number of consecutive failed calls opening the circuit
+	FailureThreshold int `json:"failure-threshold"`
+	// Time after which an open circuit lets a trial call pass
+	ResetTimeout string `json:"reset-timeout"`
+}
+
+const (
+	BreakerClosed   string = "closed"    // Repository is healthy
+	BreakerOpen     string = "open"      // Calls fail immediately
+	BreakerHalfOpen string = "half-open" // A trial call is in progress
+)
+
+// Health of a metric data repository as seen by its circuit breaker.
+type RepositoryHealth struct {
+	Cluster             string     `json:"cluster"`
+	Kind                string     `json:"kind"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastError           *string    `json:"lastError,omitempty"`
+	LastFailure         *time.Time `json:"lastFailure,omitempty"`
+	LastSuccess         *time.Time `json:"lastSuccess,omitempty"`
+}
+
+// Wraps a MetricDataRepository with a timeout and retries per call and a
+// circuit breaker: After failure-threshold consecutive failed calls the
+// repository is not queried anymore for reset-timeout, calls fail
+// immediately instead. Afterwards a single trial call decides whether the
+// circuit is closed again. Calls returning data together with an error
+// (partial errors) are not counted as failures.
+type guardedRepository struct {
+	cluster, kind    string
+	repo             MetricDataRepository
+	timeout          time.Duration
+	retries          int
+	failureThreshold int
+	resetTimeout     time.Duration
+
+	mu          sync.Mutex
+	state       string
+	failures    int
+	openedAt    time.Time
+	lastError   string
+	lastFailure time.Time
+	lastSuccess time.Time
+}
+
+var (
+	guardedReposMu sync.Mutex
+	guardedRepos   []*guardedRepository
+)
+
+func newGuardedRepository(
+	cluster, kind string,
+	repo MetricDataRepository,
+	rawConfig json.RawMessage) (*guardedRepository, error) {
+
+	var config struct {
+		CircuitBreaker CircuitBreakerConfig `json:"circuit-breaker"`
+	}
+	config.CircuitBreaker = CircuitBreakerConfig{
+		Timeout:          "30s",
+		Retries:          1,
+		FailureThreshold: 5,
+		ResetTimeout:     "1m",
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		log.Warn("Error while unmarshaling raw json circuit-breaker config")
+		return nil, err
+	}
+
+	g := &guardedRepository{
+		cluster:          cluster,
+		kind:             kind,
+		repo:             repo,
+		retries:          config.CircuitBreaker.Retries,
+		failureThreshold: config.CircuitBreaker.FailureThreshold,
+		state:            BreakerClosed,
+	}
+	var err error
+	if g.timeout, err = time.ParseDuration(config.CircuitBreaker.Timeout); err != nil {
+		log.Warnf("Error while parsing circuit-breaker timeout '%s'", config.CircuitBreaker.Timeout)
+		return nil, err
+	}
+	if g.resetTimeout, err = time.ParseDuration(config.CircuitBreaker.ResetTimeout); err != nil {
+		log.Warnf("Error while parsing circuit-breaker reset-timeout '%s'", config.CircuitBreaker.ResetTimeout)
+		return nil, err
+	}
+	if g.failureThreshold < 1 {
+		g.failureThreshold = 1
+	}
+
+	guardedReposMu.Lock()
+	guardedRepos = append(guardedRepos, g)
+	guardedReposMu.Unlock()
+
+	return g, nil
+}
+
+// Returns false if the circuit is open and the call must not be made.
+func (g *guardedRepository) allow() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch g.state {
+	case BreakerOpen:
+		if time.Since(g.openedAt) < g.resetTimeout {
+			return false
+		}
+		g.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		// Only the trial call passes
+		return false
+	default:
+		return true
+	}
+}
+
+func (g *guardedRepository) record(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err == nil {
+		if g.state != BreakerClosed {
+			log.Infof("Metric data repository %s of cluster %s is available again", g.kind, g.cluster)
+		}
+		g.state = BreakerClosed
+		g.failures = 0
+		g.lastSuccess = time.Now()
+		return
+	}
+
+	g.failures++
+	g.lastError = err.Error()
+	g.lastFailure = time.Now()
+	if g.state == BreakerHalfOpen || (g.state == BreakerClosed && g.failures >= g.failureThreshold) {
+		if g.state == BreakerClosed {
+			log.Warnf("Metric data repository %s of cluster %s unavailable after %d failed calls, pausing requests for %s: %s",
+				g.kind, g.cluster, g.failures, g.resetTimeout, g.lastError)
+		}
+		g.state = BreakerOpen
+		g.openedAt = time.Now()
+	}
+}
+
+// Run call with a timeout per attempt and retries, guarded by the circuit
+// breaker. call returns whether data was returned.
+func (g *guardedRepository) do(ctx context.Context, call func(ctx context.Context) (bool, error)) error {
+	if !g.allow() {
+		g.mu.Lock()
+		lastError := g.lastError
+		g.mu.Unlock()
+		return fmt.Errorf("METRICDATA/BREAKER > metric data repository %s of cluster %s unavailable: %s", g.kind, g.cluster, lastError)
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, g.timeout)
+		var hasData bool
+		hasData, err = call(attemptCtx)
+		cancel()
+		if err == nil || hasData {
+			g.record(nil)
+			return err
+		}
+		if attempt >= g.retries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Duration(attempt+1) * 100 * time.Millisecond):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if errors.Is(ctx.Err(), context.Canceled) {
+		// Not the fault of the repository
+		g.abort()
+		return err
+	}
+	g.record(err)
+	return err
+}
+
+// Release a trial call without result, the next call will be a trial again.
+func (g *guardedRepository) abort() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.state == BreakerHalfOpen {
+		g.state = BreakerOpen
+	}
+}
+
+func (g *guardedRepository) Init(rawConfig json.RawMessage) error {
+	return g.repo.Init(rawConfig)
+}
+
+func (g *guardedRepository) LoadData(
+	job *schema.Job,
+	metrics []string,
+	scopes []schema.MetricScope,
+	ctx context.Context) (schema.JobData, error) {
+
+	var data schema.JobData
+	err := g.do(ctx, func(ctx context.Context) (bool, error) {
+		var err error
+		data, err = g.repo.LoadData(job, metrics, scopes, ctx)
+		return len(data) != 0, err
+	})
+	return data, err
+}
+
+func (g *guardedRepository) LoadStats(
+	job *schema.Job,
+	metrics []string,
+	ctx context.Context) (map[string]map[string]schema.MetricStatistics, error) {
+
+	var stats map[string]map[string]schema.MetricStatistics
+	err := g.do(ctx, func(ctx context.Context) (bool, error) {
+		var err error
+		stats, err = g.repo.LoadStats(job, metrics, ctx)
+		return len(stats) != 0, err
+	})
+	return stats, err
+}
+
+func (g *guardedRepository) LoadStatsBatch(
+	jobs []*schema.Job,
+	metrics []string,
+	ctx context.Context) ([]map[string]map[string]schema.MetricStatistics, error) {
+
+	if _, ok := g.repo.(nativeStatsBatchLoader); !ok {
+		// One guarded call per job, so that every job gets the full timeout
+		return loadStatsPerJob(g, jobs, metrics, ctx)
+	}
+
+	var stats []map[string]map[string]schema.MetricStatistics
+	err := g.do(ctx, func(ctx context.Context) (bool, error) {
+		var err error
+		stats, err = g.repo.LoadStatsBatch(jobs, metrics, ctx)
+		for _, s := range stats {
+			if len(s) != 0 {
+				return true, err
+			}
+		}
+		return false, err
+	})
+	return stats, err
+}
+
+func (g *guardedRepository) LoadNodeData(
+	cluster string,
+	metrics, nodes []string,
+	scopes []schema.MetricScope,
+	from, to time.Time,
+	ctx context.Context) (map[string]map[string][]*schema.JobMetric, error) {
+
+	var data map[string]map[string][]*schema.JobMetric
+	err := g.do(ctx, func(ctx context.Context) (bool, error) {
+		var err error
+		data, err = g.repo.LoadNodeData(cluster, metrics, nodes, scopes, from, to, ctx)
+		return len(data) != 0, err
+	})
+	return data, err
+}
+
+func (g *guardedRepository) health() *RepositoryHealth {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	state := g.state
+	if state == BreakerOpen && time.Since(g.openedAt) >= g.resetTimeout {
+		// The next call will be a trial call
+		state = BreakerHalfOpen
+	}
+
+	h := &RepositoryHealth{
+		Cluster:             g.cluster,
+		Kind:                g.kind,
+		State:               state,
+		ConsecutiveFailures: g.failures,
+	}
+	if g.lastError != "" {
+		lastError, lastFailure := g.lastError, g.lastFailure
+		h.LastError, h.LastFailure = &lastError, &lastFailure
+	}
+	if !g.lastSuccess.IsZero() {
+		lastSuccess := g.lastSuccess
+		h.LastSuccess = &lastSuccess
+	}
+	return h
+}
+
+// Returns the health of all metric data repositories, or only those of
+// cluster if it is not empty, ordered by cluster.
+func GetHealth(cluster string) []*RepositoryHealth {
+	guardedReposMu.Lock()
+	defer guardedReposMu.Unlock()
+
+	health := make([]*RepositoryHealth, 0, len(guardedRepos))
+	for _, g := range guardedRepos {
+		if cluster == "" || g.cluster == cluster {
+			health = append(health, g.health())
+		}
+	}
+	sort.SliceStable(health, func(i, j int) bool {
+		return health[i].Cluster < health[j].Cluster
+	})
+
+	return health
+}
@@ -0,0 +1,122 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	repo := &fakeRepository{metrics: []string{"flops_any"}, nodes: []string{"n1"}, err: errors.New("down")}
+	g, err := newGuardedRepository("testcluster", "fake", repo, json.RawMessage(`{
+		"circuit-breaker": {"timeout": "1s", "retries": 1, "failure-threshold": 2, "reset-timeout": "50ms"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { guardedRepos = nil })
+
+	load := func() error {
+		_, err := g.LoadNodeData("testcluster", []string{"flops_any"}, nil, nil, time.Now(), time.Now(), context.Background())
+		return err
+	}
+
+	// every call is retried once
+	load()
+	if len(repo.calls) != 2 || g.health().State != BreakerClosed {
+		t.Fatalf("expected retry and closed circuit, got %d calls", len(repo.calls))
+	}
+
+	load()
+	if h := g.health(); h.State != BreakerOpen || h.ConsecutiveFailures != 2 || *h.LastError != "down" {
+		t.Fatalf("expected open circuit, got %+v", h)
+	}
+
+	// no calls while the circuit is open
+	if err := load(); err == nil || len(repo.calls) != 4 {
+		t.Fatal("expected call to fail without querying the repository")
+	}
+
+	// a successful trial call closes the circuit
+	time.Sleep(60 * time.Millisecond)
+	repo.err = nil
+	if err := load(); err != nil {
+		t.Fatal(err)
+	}
+	if h := g.health(); h.State != BreakerClosed || h.ConsecutiveFailures != 0 || h.LastSuccess == nil {
+		t.Fatalf("expected closed circuit, got %+v", h)
+	}
+
+	if health := GetHealth("testcluster"); len(health) != 1 || health[0].Kind != "fake" {
+		t.Fatal("unexpected health")
+	}
+	if health := GetHealth("other"); len(health) != 0 {
+		t.Fatal("unexpected health for other cluster")
+	}
+}
+
+type fakeBatchRepository struct {
+	fakeRepository
+	batches int
+}
+
+func (f *fakeBatchRepository) LoadStatsBatch(
+	jobs []*schema.Job,
+	metrics []string,
+	ctx context.Context) ([]map[string]map[string]schema.MetricStatistics, error) {
+
+	f.batches++
+	stats := make([]map[string]map[string]schema.MetricStatistics, len(jobs))
+	for i := range jobs {
+		stats[i], _ = f.LoadStats(jobs[i], metrics, ctx)
+	}
+	return stats, f.err
+}
+
+func (f *fakeBatchRepository) nativeStatsBatch() {}
+
+func TestGuardedLoadStatsBatch(t *testing.T) {
+	repo := &fakeRepository{metrics: []string{"flops_any"}}
+	g, err := newGuardedRepository("testcluster", "fake", repo, json.RawMessage(`{
+		"circuit-breaker": {"retries": 0, "failure-threshold": 3}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { guardedRepos = nil })
+
+	// fakeRepository has no native LoadStatsBatch, LoadStats is guarded per job
+	stats, err := g.LoadStatsBatch([]*schema.Job{{}, {}}, []string{"flops_any", "mem_bw"}, context.Background())
+	if err != nil || len(stats) != 2 || len(repo.calls) != 2 || stats[1]["flops_any"]["n1"].Avg != 1 {
+		t.Fatalf("unexpected statistics %v, %v", stats, err)
+	}
+
+	// every failed job counts as a failed call
+	repo.err = errors.New("down")
+	for i := 1; i <= 2; i++ {
+		if _, err := g.LoadStatsBatch([]*schema.Job{{}, {}}, []string{"mem_bw"}, context.Background()); err == nil {
+			t.Fatal("expected error")
+		}
+		if h := g.health(); h.ConsecutiveFailures != i {
+			t.Fatalf("expected %d failures, got %+v", i, h)
+		}
+	}
+
+	batchRepo := &fakeBatchRepository{fakeRepository: fakeRepository{metrics: []string{"flops_any"}}}
+	g, err = newGuardedRepository("testcluster", "fake", batchRepo, json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err = g.LoadStatsBatch([]*schema.Job{{}, {}}, []string{"flops_any"}, context.Background())
+	if err != nil || len(stats) != 2 || batchRepo.batches != 1 || stats[0]["flops_any"]["n1"].Avg != 1 {
+		t.Fatalf("unexpected batch statistics %v, %v", stats, err)
+	}
+}
@@ -10,11 +10,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ClusterCockpit/cc-backend/internal/util"
 	"github.com/ClusterCockpit/cc-backend/pkg/archive"
 	"github.com/ClusterCockpit/cc-backend/pkg/log"
 	"github.com/ClusterCockpit/cc-backend/pkg/schema"
@@ -564,6 +567,153 @@ func (ccms *CCMetricStore) LoadStats(
 	return stats, nil
 }
 
+// Maximum number of jobs whose statistics are requested at once by
+// LoadStatsBatch
+const ccmsStatsBatchSize = 64
+
+// Loads the statistics of many jobs with one request per cluster and batch
+// of jobs: As the time range is common to all queries of a request, the
+// data of the union of the time ranges of the jobs is requested and the
+// statistics of every job are computed from the samples of the timestep
+// slots of its own time range. Jobs are batched in order of their start
+// time to keep the union short.
+func (ccms *CCMetricStore) LoadStatsBatch(
+	jobs []*schema.Job,
+	metrics []string,
+	ctx context.Context,
+) ([]map[string]map[string]schema.MetricStatistics, error) {
+	clusters := make(map[string][]int)
+	names := make([]string, 0, 1)
+	for i, job := range jobs {
+		if _, ok := clusters[job.Cluster]; !ok {
+			names = append(names, job.Cluster)
+		}
+		clusters[job.Cluster] = append(clusters[job.Cluster], i)
+	}
+
+	stats := make([]map[string]map[string]schema.MetricStatistics, len(jobs))
+	for _, cluster := range names {
+		indices := clusters[cluster]
+		sort.SliceStable(indices, func(i, j int) bool {
+			return jobs[indices[i]].StartTime.Before(jobs[indices[j]].StartTime)
+		})
+
+		for len(indices) > 0 {
+			batch := indices[:util.Min(len(indices), ccmsStatsBatchSize)]
+			indices = indices[len(batch):]
+
+			if len(batch) == 1 {
+				var err error
+				if stats[batch[0]], err = ccms.LoadStats(jobs[batch[0]], metrics, ctx); err != nil {
+					return nil, err
+				}
+			} else if err := ccms.loadStatsBatch(jobs, batch, metrics, stats, ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+func (ccms *CCMetricStore) nativeStatsBatch() {}
+
+func ccmsTimeRange(job *schema.Job) (int64, int64) {
+	return job.StartTime.Unix(), job.StartTime.Add(time.Duration(job.Duration) * time.Second).Unix()
+}
+
+// Returns the indices [first, last) of the samples of a series of n samples
+// starting at dataFrom that fall into the timestep slots of [from, to].
+func ccmsSlots(from, to, dataFrom int64, timestep, n int) (int, int) {
+	if to < dataFrom {
+		return 0, 0
+	}
+	first := util.Min(util.Max(int((from-dataFrom)/int64(timestep)), 0), n)
+	last := util.Min(util.Max(int((to-dataFrom)/int64(timestep))+1, first), n)
+	return first, last
+}
+
+// Load the statistics of the jobs with the indices batch, all of the same
+// cluster, in one request for the union of their time ranges.
+func (ccms *CCMetricStore) loadStatsBatch(
+	jobs []*schema.Job,
+	batch []int,
+	metrics []string,
+	stats []map[string]map[string]schema.MetricStatistics,
+	ctx context.Context,
+) error {
+	from, to := ccmsTimeRange(jobs[batch[0]])
+	for _, i := range batch[1:] {
+		jobFrom, jobTo := ccmsTimeRange(jobs[i])
+		from, to = util.Min(from, jobFrom), util.Max(to, jobTo)
+	}
+
+	req := ApiQueryRequest{
+		Cluster:   jobs[batch[0]].Cluster,
+		From:      from,
+		To:        to,
+		WithStats: false,
+		WithData:  true,
+	}
+	// Index of the job of each query
+	queryJobs := make([]int, 0)
+	for _, i := range batch {
+		queries, _, err := ccms.buildQueries(jobs[i], metrics, []schema.MetricScope{schema.MetricScopeNode})
+		if err != nil {
+			log.Warn("Error while building query")
+			return err
+		}
+		req.Queries = append(req.Queries, queries...)
+		for range queries {
+			queryJobs = append(queryJobs, i)
+		}
+		stats[i] = make(map[string]map[string]schema.MetricStatistics, len(metrics))
+	}
+
+	resBody, err := ccms.doRequest(ctx, &req)
+	if err != nil {
+		log.Error("Error while performing request")
+		return err
+	}
+
+	for i, res := range resBody.Results {
+		query, job := req.Queries[i], jobs[queryJobs[i]]
+		metric := ccms.toLocalName(query.Metric)
+		data := res[0]
+		if data.Error != nil {
+			log.Infof("fetching %s for node %s failed: %s", metric, query.Hostname, *data.Error)
+			continue
+		}
+
+		mc := archive.GetMetricConfig(job.Cluster, metric)
+		if mc == nil || mc.Timestep <= 0 {
+			log.Infof("fetching %s for node %s failed: no timestep configured", metric, query.Hostname)
+			continue
+		}
+
+		dataFrom := data.From
+		if dataFrom == 0 {
+			dataFrom = from
+		}
+		jobFrom, jobTo := ccmsTimeRange(job)
+		first, last := ccmsSlots(jobFrom, jobTo, dataFrom, mc.Timestep, len(data.Data))
+		min, max, avg := MinMaxMean(data.Data[first:last])
+		if first == last || math.IsNaN(avg) {
+			log.Infof("fetching %s for node %s failed: one of avg/min/max is NaN", metric, query.Hostname)
+			continue
+		}
+
+		metricdata, ok := stats[queryJobs[i]][metric]
+		if !ok {
+			metricdata = make(map[string]schema.MetricStatistics, job.NumNodes)
+			stats[queryJobs[i]][metric] = metricdata
+		}
+		metricdata[query.Hostname] = schema.MetricStatistics{Avg: avg, Min: min, Max: max}
+	}
+
+	return nil
+}
+
 // TODO: Support sub-node-scope metrics! For this, the partition of a node needs to be known!
 func (ccms *CCMetricStore) LoadNodeData(
 	cluster string,
@@ -0,0 +1,98 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+func setupCCMetricStore(t *testing.T) (*CCMetricStore, *[]ApiQueryRequest) {
+	cluster := setupTestCluster(t,
+		&schema.MetricConfig{Name: "flops_any", Unit: schema.Unit{Base: "F/s"}, Scope: schema.MetricScopeNode, Timestep: 60})
+	cluster.SubClusters = []*schema.SubCluster{{Name: "main", Topology: schema.Topology{Node: []int{0, 1}}}}
+
+	srv, requests := newRecordingServer(t, func(w http.ResponseWriter, r *http.Request, record func(ApiQueryRequest)) {
+		var req ApiQueryRequest
+		if r.URL.Path != "/api/query" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		record(req)
+
+		// One sample per minute with the minutes since from as value, the
+		// average is the number of the query
+		res := ApiQueryResponse{Results: make([][]ApiMetricData, len(req.Queries))}
+		for i := range req.Queries {
+			data := ApiMetricData{From: req.From, To: req.To, Avg: schema.Float(i + 1), Min: 1, Max: 1}
+			for t := req.From; t < req.To; t += 60 {
+				data.Data = append(data.Data, schema.Float((t-req.From)/60))
+			}
+			res.Results[i] = []ApiMetricData{data}
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+
+	ccms := &CCMetricStore{}
+	if err := ccms.Init(json.RawMessage(`{"kind": "cc-metric-store", "url": "` + srv.URL + `"}`)); err != nil {
+		t.Fatal(err)
+	}
+	return ccms, requests
+}
+
+func TestCCMetricStoreLoadStatsBatch(t *testing.T) {
+	ccms, requests := setupCCMetricStore(t)
+
+	newJob := func(start int64, duration int32) *schema.Job {
+		job := &schema.Job{}
+		job.Cluster = "testcluster"
+		job.SubCluster = "main"
+		job.StartTime = time.Unix(start, 0)
+		job.Duration = duration
+		job.Resources = []*schema.Resource{{Hostname: "n1"}}
+		return job
+	}
+	jobs := []*schema.Job{
+		newJob(1700000000, 1200),
+		newJob(1700000600, 600),
+		newJob(1700000030, 60),
+	}
+
+	stats, err := ccms.LoadStatsBatch(jobs, []string{"flops_any"}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("expected one request, got %+v", *requests)
+	}
+	if req := (*requests)[0]; len(req.Queries) != 3 || req.From != 1700000000 || req.To != 1700001200 || req.WithStats || !req.WithData {
+		t.Fatalf("unexpected batch request %+v", req)
+	}
+
+	// The samples are the minutes since the start of the first job
+	if s := stats[0]["flops_any"]["n1"]; s.Avg != 9.5 || s.Min != 0 || s.Max != 19 {
+		t.Fatalf("unexpected statistics %+v", s)
+	}
+	if s := stats[1]["flops_any"]["n1"]; s.Avg != 14.5 || s.Min != 10 || s.Max != 19 {
+		t.Fatalf("unexpected statistics %+v", s)
+	}
+	if s := stats[2]["flops_any"]["n1"]; s.Avg != 0.5 || s.Min != 0 || s.Max != 1 {
+		t.Fatalf("unexpected statistics %+v", s)
+	}
+
+	// A single job is requested with the statistics of cc-metric-store
+	stats, err = ccms.LoadStatsBatch(jobs[1:2], []string{"flops_any"}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req := (*requests)[1]; !req.WithStats || req.WithData || stats[0]["flops_any"]["n1"].Avg != 1 {
+		t.Fatalf("unexpected single job request %+v", req)
+	}
+}
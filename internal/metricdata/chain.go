@@ -0,0 +1,250 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/internal/util"
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+type chainedRepository struct {
+	kind string
+	repo MetricDataRepository
+	// Only data newer than maxAge is available in repo (0 for no limit)
+	maxAge time.Duration
+}
+
+// A list of metric data repositories of one cluster, configured by using a
+// JSON array as metricDataRepository. The repositories are consulted in
+// order: Data missing in a result, because the repository failed or did not
+// know the metric or node, is requested from the next one and the results
+// are merged. Repositories whose max-age does not cover the start of the
+// requested time range are skipped, unless no repository covers it.
+type ChainedDataRepository struct {
+	cluster string
+	repos   []chainedRepository
+}
+
+func newChainedDataRepository(cluster string, rawConfig json.RawMessage) (*ChainedDataRepository, error) {
+	cdr := &ChainedDataRepository{cluster: cluster}
+	if err := cdr.Init(rawConfig); err != nil {
+		return nil, err
+	}
+	return cdr, nil
+}
+
+func (cdr *ChainedDataRepository) Init(rawConfig json.RawMessage) error {
+	var configs []json.RawMessage
+	if err := json.Unmarshal(rawConfig, &configs); err != nil {
+		log.Warn("Error while unmarshaling raw json MetricDataRepository list")
+		return err
+	}
+	if len(configs) == 0 {
+		return errors.New("METRICDATA/CHAIN > empty list of metric data repositories")
+	}
+
+	cdr.repos = make([]chainedRepository, 0, len(configs))
+	for _, rawConfig := range configs {
+		var config struct {
+			MaxAge string `json:"max-age"`
+		}
+		if err := json.Unmarshal(rawConfig, &config); err != nil {
+			log.Warn("Error while unmarshaling raw json MetricDataRepository")
+			return err
+		}
+
+		var maxAge time.Duration
+		if config.MaxAge != "" {
+			var err error
+			if maxAge, err = time.ParseDuration(config.MaxAge); err != nil {
+				log.Warnf("Error while parsing max-age '%s'", config.MaxAge)
+				return err
+			}
+		}
+
+		repo, kind, err := newMetricDataRepository(cdr.cluster, rawConfig)
+		if err != nil {
+			return err
+		}
+		cdr.repos = append(cdr.repos, chainedRepository{kind: kind, repo: repo, maxAge: maxAge})
+	}
+
+	return nil
+}
+
+// Returns the repositories holding data starting at from.
+func (cdr *ChainedDataRepository) candidates(from time.Time) []chainedRepository {
+	repos := make([]chainedRepository, 0, len(cdr.repos))
+	for _, r := range cdr.repos {
+		if r.maxAge == 0 || !from.Before(time.Now().Add(-r.maxAge)) {
+			repos = append(repos, r)
+		}
+	}
+
+	if len(repos) == 0 {
+		return cdr.repos
+	}
+	return repos
+}
+
+// Combine the errors of all repositories into one.
+func chainError(errs []string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("METRICDATA/CHAIN > Errors: %s", strings.Join(errs, ", "))
+}
+
+func (cdr *ChainedDataRepository) LoadData(
+	job *schema.Job,
+	metrics []string,
+	scopes []schema.MetricScope,
+	ctx context.Context) (schema.JobData, error) {
+
+	jobData := make(schema.JobData)
+	missing := metrics
+	var errs []string
+	for _, r := range cdr.candidates(job.StartTime) {
+		data, err := r.repo.LoadData(job, missing, scopes, ctx)
+		if err != nil {
+			log.Warnf("Error while loading job data from %s: %s", r.kind, err.Error())
+			errs = append(errs, fmt.Sprintf("%s: %s", r.kind, err.Error()))
+		}
+
+		for metric, scopes := range data {
+			if _, ok := jobData[metric]; !ok && len(scopes) != 0 {
+				jobData[metric] = scopes
+			}
+		}
+
+		missing = make([]string, 0, len(metrics))
+		for _, metric := range metrics {
+			if _, ok := jobData[metric]; !ok {
+				missing = append(missing, metric)
+			}
+		}
+		if len(missing) == 0 {
+			return jobData, nil
+		}
+	}
+
+	return jobData, chainError(errs)
+}
+
+func (cdr *ChainedDataRepository) LoadStats(
+	job *schema.Job,
+	metrics []string,
+	ctx context.Context) (map[string]map[string]schema.MetricStatistics, error) {
+
+	stats := make(map[string]map[string]schema.MetricStatistics)
+	missing := metrics
+	var errs []string
+	for _, r := range cdr.candidates(job.StartTime) {
+		data, err := r.repo.LoadStats(job, missing, ctx)
+		if err != nil {
+			log.Warnf("Error while loading statistics from %s: %s", r.kind, err.Error())
+			errs = append(errs, fmt.Sprintf("%s: %s", r.kind, err.Error()))
+		}
+
+		for metric, nodes := range data {
+			if _, ok := stats[metric]; !ok && len(nodes) != 0 {
+				stats[metric] = nodes
+			}
+		}
+
+		missing = make([]string, 0, len(metrics))
+		for _, metric := range metrics {
+			if _, ok := stats[metric]; !ok {
+				missing = append(missing, metric)
+			}
+		}
+		if len(missing) == 0 {
+			return stats, nil
+		}
+	}
+
+	return stats, chainError(errs)
+}
+
+func (cdr *ChainedDataRepository) LoadStatsBatch(
+	jobs []*schema.Job,
+	metrics []string,
+	ctx context.Context) ([]map[string]map[string]schema.MetricStatistics, error) {
+
+	return loadStatsPerJob(cdr, jobs, metrics, ctx)
+}
+
+func (cdr *ChainedDataRepository) LoadNodeData(
+	cluster string,
+	metrics, nodes []string,
+	scopes []schema.MetricScope,
+	from, to time.Time,
+	ctx context.Context) (map[string]map[string][]*schema.JobMetric, error) {
+
+	data := make(map[string]map[string][]*schema.JobMetric)
+	missingMetrics, missingNodes := metrics, nodes
+	var errs []string
+	for _, r := range cdr.candidates(from) {
+		repoData, err := r.repo.LoadNodeData(cluster, missingMetrics, missingNodes, scopes, from, to, ctx)
+		if err != nil {
+			log.Warnf("Error while loading node data from %s: %s", r.kind, err.Error())
+			errs = append(errs, fmt.Sprintf("%s: %s", r.kind, err.Error()))
+		}
+
+		for host, metrics := range repoData {
+			hostdata, ok := data[host]
+			if !ok {
+				hostdata = make(map[string][]*schema.JobMetric)
+				data[host] = hostdata
+			}
+			for metric, jms := range metrics {
+				if _, ok := hostdata[metric]; !ok && len(jms) != 0 {
+					hostdata[metric] = jms
+				}
+			}
+		}
+
+		// Ask the next repository for the metrics not available for all
+		// nodes. If all nodes were requested, only completely missing
+		// metrics are requested again.
+		missingMetrics, missingNodes = make([]string, 0), make([]string, 0)
+		for _, metric := range metrics {
+			found, complete := false, true
+			for _, node := range nodes {
+				if _, ok := data[node][metric]; !ok {
+					complete = false
+					if !util.Contains(missingNodes, node) {
+						missingNodes = append(missingNodes, node)
+					}
+				}
+			}
+			for _, hostdata := range data {
+				if _, ok := hostdata[metric]; ok {
+					found = true
+					break
+				}
+			}
+			if !found || !complete {
+				missingMetrics = append(missingMetrics, metric)
+			}
+		}
+		if nodes == nil {
+			missingNodes = nil
+		}
+		if len(missingMetrics) == 0 {
+			return data, nil
+		}
+	}
+
+	return data, chainError(errs)
+}
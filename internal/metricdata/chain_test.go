@@ -0,0 +1,147 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/internal/util"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+// Repository with data for a fixed set of metrics and nodes
+type fakeRepository struct {
+	metrics, nodes []string
+	err            error
+	calls          [][]string
+}
+
+func (f *fakeRepository) Init(_ json.RawMessage) error { return nil }
+
+func (f *fakeRepository) LoadData(
+	job *schema.Job,
+	metrics []string,
+	scopes []schema.MetricScope,
+	ctx context.Context) (schema.JobData, error) {
+
+	f.calls = append(f.calls, metrics)
+	jd := make(schema.JobData)
+	for _, metric := range metrics {
+		if util.Contains(f.metrics, metric) {
+			jd[metric] = map[schema.MetricScope]*schema.JobMetric{schema.MetricScopeNode: {Timestep: 60}}
+		}
+	}
+	return jd, f.err
+}
+
+func (f *fakeRepository) LoadStats(
+	job *schema.Job,
+	metrics []string,
+	ctx context.Context) (map[string]map[string]schema.MetricStatistics, error) {
+
+	f.calls = append(f.calls, metrics)
+	stats := make(map[string]map[string]schema.MetricStatistics)
+	for _, metric := range metrics {
+		if util.Contains(f.metrics, metric) {
+			stats[metric] = map[string]schema.MetricStatistics{"n1": {Avg: 1}}
+		}
+	}
+	return stats, f.err
+}
+
+func (f *fakeRepository) LoadStatsBatch(
+	jobs []*schema.Job,
+	metrics []string,
+	ctx context.Context) ([]map[string]map[string]schema.MetricStatistics, error) {
+
+	return loadStatsPerJob(f, jobs, metrics, ctx)
+}
+
+func (f *fakeRepository) LoadNodeData(
+	cluster string,
+	metrics, nodes []string,
+	scopes []schema.MetricScope,
+	from, to time.Time,
+	ctx context.Context) (map[string]map[string][]*schema.JobMetric, error) {
+
+	f.calls = append(f.calls, append(append([]string{}, metrics...), nodes...))
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	data := make(map[string]map[string][]*schema.JobMetric)
+	for _, node := range f.nodes {
+		if nodes != nil && !util.Contains(nodes, node) {
+			continue
+		}
+		data[node] = make(map[string][]*schema.JobMetric)
+		for _, metric := range metrics {
+			if util.Contains(f.metrics, metric) {
+				data[node][metric] = []*schema.JobMetric{{Timestep: 60}}
+			}
+		}
+	}
+	return data, nil
+}
+
+func TestChainLoadData(t *testing.T) {
+	recent := &fakeRepository{metrics: []string{"flops_any"}}
+	old := &fakeRepository{metrics: []string{"flops_any", "mem_bw"}}
+	cdr := ChainedDataRepository{repos: []chainedRepository{
+		{kind: "recent", repo: recent, maxAge: 24 * time.Hour},
+		{kind: "old", repo: old},
+	}}
+
+	job := &schema.Job{}
+	job.StartTime = time.Now().Add(-time.Hour)
+	jd, err := cdr.LoadData(job, []string{"flops_any", "mem_bw"}, nil, context.Background())
+	if err != nil || len(jd) != 2 {
+		t.Fatalf("unexpected result %v, %v", jd, err)
+	}
+	if len(old.calls) != 1 || len(old.calls[0]) != 1 || old.calls[0][0] != "mem_bw" {
+		t.Fatalf("expected only missing metric from fallback, got %v", old.calls)
+	}
+
+	// too old for the first repository
+	job.StartTime = time.Now().Add(-48 * time.Hour)
+	recent.calls = nil
+	if _, err := cdr.LoadStats(job, []string{"flops_any"}, context.Background()); err != nil || len(recent.calls) != 0 {
+		t.Fatal("expected first repository to be skipped")
+	}
+
+	// errors are only returned if data is still missing
+	recent.err, old.err = errors.New("down"), errors.New("down")
+	job.StartTime = time.Now()
+	if jd, err := cdr.LoadData(job, []string{"flops_any", "cpu_load"}, nil, context.Background()); err == nil || len(jd) != 1 {
+		t.Fatalf("expected partial result and error, got %v, %v", jd, err)
+	}
+}
+
+func TestChainLoadNodeData(t *testing.T) {
+	down := &fakeRepository{err: errors.New("down")}
+	partial := &fakeRepository{metrics: []string{"flops_any", "mem_bw"}, nodes: []string{"n1"}}
+	full := &fakeRepository{metrics: []string{"flops_any", "mem_bw"}, nodes: []string{"n1", "n2"}}
+	cdr := ChainedDataRepository{repos: []chainedRepository{
+		{kind: "down", repo: down},
+		{kind: "partial", repo: partial},
+		{kind: "full", repo: full},
+	}}
+
+	data, err := cdr.LoadNodeData("testcluster", []string{"flops_any", "mem_bw"}, []string{"n1", "n2"},
+		nil, time.Now().Add(-time.Hour), time.Now(), context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2 || len(data["n1"]) != 2 || len(data["n2"]) != 2 {
+		t.Fatalf("unexpected node data %v", data)
+	}
+	if len(full.calls) != 1 || len(full.calls[0]) != 3 || full.calls[0][2] != "n2" {
+		t.Fatalf("expected only missing node from last repository, got %v", full.calls)
+	}
+}
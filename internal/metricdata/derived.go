@@ -0,0 +1,635 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/ClusterCockpit/cc-backend/internal/util"
+	"github.com/ClusterCockpit/cc-backend/pkg/archive"
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+	ccunits "github.com/ClusterCockpit/cc-units"
+)
+
+// A metric computed from other metrics (its dependencies) of a cluster
+// after loading them, see schema.DerivedMetric.
+type derivedMetric struct {
+	name string
+	expr exprNode
+	deps []string
+	unit schema.Unit
+}
+
+// Map of clusters to a map of derived metric names to derived metrics.
+var derivedMetrics map[string]map[string]*derivedMetric = map[string]map[string]*derivedMetric{}
+
+func initDerivedMetrics(cluster string, configs []schema.DerivedMetric) error {
+	metrics := make(map[string]*derivedMetric, len(configs))
+	for _, config := range configs {
+		expr, deps, err := parseExpression(config.Expression)
+		if err != nil {
+			log.Errorf("Error while parsing expression of derived metric %s", config.Name)
+			return err
+		}
+		if config.Name == "" {
+			return fmt.Errorf("METRICDATA/DERIVED > derived metric without name in cluster %s", cluster)
+		}
+
+		metrics[config.Name] = &derivedMetric{
+			name: config.Name,
+			expr: expr,
+			deps: deps,
+			unit: derivedUnit(config.Unit),
+		}
+	}
+
+	for _, dm := range metrics {
+		for _, dep := range dm.deps {
+			if _, ok := metrics[dep]; ok {
+				return fmt.Errorf("METRICDATA/DERIVED > derived metric %s depends on derived metric %s", dm.name, dep)
+			}
+		}
+	}
+
+	derivedMetrics[cluster] = metrics
+	return registerDerivedMetricConfigs(cluster, metrics)
+}
+
+// Metric configurations added to the cluster configurations for derived
+// metrics by registerDerivedMetricConfigs.
+var derivedMetricConfigs []*schema.MetricConfig
+
+// Add a metric configuration for every derived metric of cluster to its
+// cluster configuration, so that derived metrics are archived with their
+// statistics and listed in the metric config like all other metrics.
+// Derived metrics replacing a configured metric or depending on metrics
+// missing in the cluster configuration are not added.
+func registerDerivedMetricConfigs(cluster string, metrics map[string]*derivedMetric) error {
+	c := archive.GetCluster(cluster)
+	if c == nil {
+		return nil
+	}
+
+	// Drop the configurations of a previous initialization
+	mcs := make([]*schema.MetricConfig, 0, len(c.MetricConfig)+len(metrics))
+	for _, mc := range c.MetricConfig {
+		if !util.Contains(derivedMetricConfigs, mc) {
+			mcs = append(mcs, mc)
+		}
+	}
+	c.MetricConfig = mcs
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if archive.GetMetricConfig(cluster, name) != nil {
+			continue
+		}
+
+		mc, err := metrics[name].metricConfig(c)
+		if err != nil {
+			return err
+		}
+		if mc == nil {
+			log.Warnf("Dependencies of derived metric %s not configured for cluster %s", name, cluster)
+			continue
+		}
+		c.MetricConfig = append(c.MetricConfig, mc)
+		derivedMetricConfigs = append(derivedMetricConfigs, mc)
+	}
+	return nil
+}
+
+// Build the metric configuration of dm from the ones of its dependencies
+// in cluster. Timestep and unit (if none is configured) are taken from the
+// dependencies, the scope is the coarsest scope of the dependencies. The
+// values of the metric are summed up if all dependencies are and dm is a
+// sum of them, otherwise averaged. Returns nil if a dependency is missing.
+func (dm *derivedMetric) metricConfig(cluster *schema.Cluster) (*schema.MetricConfig, error) {
+	mc := &schema.MetricConfig{Name: dm.name, Unit: dm.unit}
+	removed := make(map[string]bool)
+	for i, dep := range dm.deps {
+		dc := archive.GetMetricConfig(cluster.Name, dep)
+		if dc == nil {
+			return nil, nil
+		}
+		for _, sc := range dc.SubClusters {
+			removed[sc.Name] = removed[sc.Name] || sc.Remove
+		}
+
+		if i == 0 {
+			mc.Timestep, mc.Scope, mc.Aggregation = dc.Timestep, dc.Scope, dc.Aggregation
+			if mc.Unit.Base == "" {
+				mc.Unit = dc.Unit
+			}
+			continue
+		}
+
+		if dc.Timestep != mc.Timestep {
+			return nil, fmt.Errorf("METRICDATA/DERIVED > dependencies of derived metric %s have different timesteps", dm.name)
+		}
+		mc.Scope = mc.Scope.Max(dc.Scope)
+		if dc.Aggregation != mc.Aggregation {
+			mc.Aggregation = "avg"
+		}
+	}
+	if mc.Aggregation == "sum" && !isSum(dm.expr) {
+		mc.Aggregation = "avg"
+	}
+
+	// Not available where a dependency is removed
+	for _, sc := range cluster.SubClusters {
+		if removed[sc.Name] {
+			mc.SubClusters = append(mc.SubClusters, &schema.SubClusterConfig{Name: sc.Name, Remove: true})
+		}
+	}
+
+	dm.unit = mc.Unit
+	return mc, nil
+}
+
+// Reports whether e is a weighted sum of metrics, which can be summed up
+// over hardware threads like its operands.
+func isSum(e exprNode) bool {
+	switch e := e.(type) {
+	case *exprNegation:
+		return isSum(e.x)
+	case *exprBinary:
+		_, lhsNumber := e.lhs.(exprNumber)
+		_, rhsNumber := e.rhs.(exprNumber)
+		switch e.op {
+		case '+', '-':
+			return isSum(e.lhs) && isSum(e.rhs)
+		case '*':
+			return (lhsNumber && isSum(e.rhs)) || (rhsNumber && isSum(e.lhs))
+		default:
+			return rhsNumber && isSum(e.lhs)
+		}
+	}
+	return true
+}
+
+// Convert a cc-units unit string. Units not known to cc-units like IPC
+// are used as base unit.
+func derivedUnit(us string) schema.Unit {
+	u := ccunits.NewUnit(us)
+	if !u.Valid() {
+		return schema.Unit{Base: us}
+	}
+
+	p, m, d := u.GetPrefix(), u.GetMeasure(), u.GetUnitDenominator()
+	unit := schema.Unit{Prefix: p.Prefix(), Base: m.Short()}
+	if d != ccunits.InvalidMeasure {
+		unit.Base = fmt.Sprintf("%s/%s", m.Short(), d.Short())
+	}
+	return unit
+}
+
+// Replaces the derived metrics of cluster in metrics by their dependencies.
+// The second return value is false if no metric is derived.
+func expandDerivedMetrics(cluster string, metrics []string) ([]string, bool) {
+	derived := derivedMetrics[cluster]
+	if len(derived) == 0 {
+		return metrics, false
+	}
+
+	expanded := make([]string, 0, len(metrics))
+	found := false
+	for _, metric := range metrics {
+		deps := []string{metric}
+		if dm, ok := derived[metric]; ok {
+			deps, found = dm.deps, true
+		}
+		for _, dep := range deps {
+			if !util.Contains(expanded, dep) {
+				expanded = append(expanded, dep)
+			}
+		}
+	}
+	return expanded, found
+}
+
+// Returns the derived metrics of cluster in metrics, or all of them if
+// metrics is nil.
+func requestedDerivedMetrics(cluster string, metrics []string) []*derivedMetric {
+	derived := derivedMetrics[cluster]
+	res := make([]*derivedMetric, 0, len(derived))
+	if metrics == nil {
+		for _, dm := range derived {
+			res = append(res, dm)
+		}
+		return res
+	}
+
+	for _, metric := range metrics {
+		if dm, ok := derived[metric]; ok {
+			res = append(res, dm)
+		}
+	}
+	return res
+}
+
+// Computes the derived metrics of cluster in metrics missing in jobData at
+// all scopes available for all of their dependencies. If the dependencies
+// have no scope in common, the derived metric is computed at node scope.
+// Dependencies not in metrics are removed from jobData afterwards, unless
+// metrics is nil.
+func addDerivedJobData(cluster string, jobData schema.JobData, metrics []string) {
+	for _, dm := range requestedDerivedMetrics(cluster, metrics) {
+		if _, ok := jobData[dm.name]; ok {
+			continue
+		}
+
+		// Copy, AddNodeScope modifies the scopes
+		deps := make(schema.JobData, len(dm.deps))
+		for _, dep := range dm.deps {
+			if scopes, ok := jobData[dep]; ok {
+				deps[dep] = make(map[schema.MetricScope]*schema.JobMetric, len(scopes))
+				for scope, jm := range scopes {
+					deps[dep][scope] = jm
+				}
+			}
+		}
+		if len(deps) != len(dm.deps) {
+			log.Debugf("Dependencies of derived metric %s missing", dm.name)
+			continue
+		}
+
+		scopes := dm.commonScopes(deps)
+		if len(scopes) == 0 {
+			for _, dep := range dm.deps {
+				deps.AddNodeScope(dep)
+			}
+			scopes = dm.commonScopes(deps)
+		}
+
+		res := make(map[schema.MetricScope]*schema.JobMetric, len(scopes))
+		for _, scope := range scopes {
+			jms := make([]*schema.JobMetric, 0, len(dm.deps))
+			for _, dep := range dm.deps {
+				jms = append(jms, deps[dep][scope])
+			}
+			if jm := dm.deriveJobMetric(jms); jm != nil {
+				res[scope] = jm
+			}
+		}
+		if len(res) != 0 {
+			jobData[dm.name] = res
+		}
+	}
+
+	if metrics == nil {
+		return
+	}
+	for metric := range jobData {
+		if !util.Contains(metrics, metric) {
+			delete(jobData, metric)
+		}
+	}
+}
+
+func (dm *derivedMetric) commonScopes(deps schema.JobData) []schema.MetricScope {
+	scopes := make([]schema.MetricScope, 0)
+	for scope := range deps[dm.deps[0]] {
+		common := true
+		for _, dep := range dm.deps[1:] {
+			if _, ok := deps[dep][scope]; !ok {
+				common = false
+				break
+			}
+		}
+		if common {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// Derive a JobMetric from the JobMetrics of the dependencies (in the order
+// of dm.deps) at the same scope. Series are matched by hostname and id.
+func (dm *derivedMetric) deriveJobMetric(jms []*schema.JobMetric) *schema.JobMetric {
+	for _, jm := range jms[1:] {
+		if jm.Timestep != jms[0].Timestep {
+			log.Warnf("Cannot derive metric %s from dependencies with different timesteps", dm.name)
+			return nil
+		}
+	}
+
+	index := make([]map[string]*schema.Series, len(jms))
+	for i, jm := range jms {
+		index[i] = make(map[string]*schema.Series, len(jm.Series))
+		for j := range jm.Series {
+			index[i][seriesKey(&jm.Series[j])] = &jm.Series[j]
+		}
+	}
+
+	res := &schema.JobMetric{
+		Unit:     dm.unit,
+		Timestep: jms[0].Timestep,
+		Series:   make([]schema.Series, 0, len(jms[0].Series)),
+	}
+	for i := range jms[0].Series {
+		if series := dm.deriveSeries(index, seriesKey(&jms[0].Series[i])); series != nil {
+			res.Series = append(res.Series, *series)
+		}
+	}
+
+	if len(res.Series) == 0 {
+		return nil
+	}
+	return res
+}
+
+func seriesKey(series *schema.Series) string {
+	if series.Id == nil {
+		return series.Hostname
+	}
+	return series.Hostname + "/" + *series.Id
+}
+
+// Evaluate dm for every timestep of the series identified by key in index,
+// a map of keys to the series of every dependency. Returns nil if a
+// dependency is missing.
+func (dm *derivedMetric) deriveSeries(index []map[string]*schema.Series, key string) *schema.Series {
+	deps := make([]*schema.Series, len(index))
+	n := math.MaxInt32
+	for i := range index {
+		series, ok := index[i][key]
+		if !ok {
+			return nil
+		}
+		deps[i] = series
+		n = util.Min(n, len(series.Data))
+	}
+
+	data := make([]schema.Float, n)
+	values := make(map[string]float64, len(deps))
+	for t := 0; t < n; t++ {
+		nan := false
+		for i, series := range deps {
+			values[dm.deps[i]] = float64(series.Data[t])
+			nan = nan || series.Data[t].IsNaN()
+		}
+
+		x := math.NaN()
+		if !nan {
+			x = dm.expr.eval(values)
+		}
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			data[t] = schema.NaN
+		} else {
+			data[t] = schema.Float(x)
+		}
+	}
+
+	min, max, avg := MinMaxMean(data)
+	return &schema.Series{
+		Hostname:   deps[0].Hostname,
+		Id:         deps[0].Id,
+		Statistics: schema.MetricStatistics{Avg: avg, Min: min, Max: max},
+		Data:       data,
+	}
+}
+
+// Computes the derived metrics of cluster in metrics for every host of data
+// (as returned by LoadNodeData) and removes the dependencies not in metrics.
+func addDerivedNodeData(cluster string, data map[string]map[string][]*schema.JobMetric, metrics []string) {
+	derived := requestedDerivedMetrics(cluster, metrics)
+	for _, hostdata := range data {
+		for _, dm := range derived {
+			index := make([]map[string]*schema.Series, len(dm.deps))
+			for i, dep := range dm.deps {
+				index[i] = make(map[string]*schema.Series)
+				for _, jm := range hostdata[dep] {
+					for j := range jm.Series {
+						index[i][seriesKey(&jm.Series[j])] = &jm.Series[j]
+					}
+				}
+			}
+
+			// Keep the layout of the first dependency
+			jms := make([]*schema.JobMetric, 0, len(hostdata[dm.deps[0]]))
+			for _, jm := range hostdata[dm.deps[0]] {
+				res := &schema.JobMetric{
+					Unit:     dm.unit,
+					Timestep: jm.Timestep,
+					Series:   make([]schema.Series, 0, len(jm.Series)),
+				}
+				for i := range jm.Series {
+					if series := dm.deriveSeries(index, seriesKey(&jm.Series[i])); series != nil {
+						res.Series = append(res.Series, *series)
+					}
+				}
+				if len(res.Series) != 0 {
+					jms = append(jms, res)
+				}
+			}
+			if len(jms) != 0 {
+				hostdata[dm.name] = jms
+			}
+		}
+
+		for metric := range hostdata {
+			if !util.Contains(metrics, metric) {
+				delete(hostdata, metric)
+			}
+		}
+	}
+}
+
+// Arithmetic expression of a derived metric.
+type exprNode interface {
+	eval(values map[string]float64) float64
+}
+
+type exprNumber float64
+
+type exprMetric string
+
+type exprNegation struct {
+	x exprNode
+}
+
+type exprBinary struct {
+	op       byte
+	lhs, rhs exprNode
+}
+
+func (e exprNumber) eval(_ map[string]float64) float64 {
+	return float64(e)
+}
+
+func (e exprMetric) eval(values map[string]float64) float64 {
+	return values[string(e)]
+}
+
+func (e *exprNegation) eval(values map[string]float64) float64 {
+	return -e.x.eval(values)
+}
+
+func (e *exprBinary) eval(values map[string]float64) float64 {
+	lhs, rhs := e.lhs.eval(values), e.rhs.eval(values)
+	switch e.op {
+	case '+':
+		return lhs + rhs
+	case '-':
+		return lhs - rhs
+	case '*':
+		return lhs * rhs
+	default:
+		if rhs == 0 {
+			return math.NaN()
+		}
+		return lhs / rhs
+	}
+}
+
+// Recursive descent parser for expressions of the grammar
+//
+//	sum     = product { ("+" | "-") product }
+//	product = factor { ("*" | "/") factor }
+//	factor  = "-" factor | number | metric | "(" sum ")"
+type exprParser struct {
+	input string
+	pos   int
+	deps  []string
+}
+
+// Parse input and return the expression and the metrics used in it.
+func parseExpression(input string) (exprNode, []string, error) {
+	p := &exprParser{input: input}
+	expr, err := p.parseSum()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.peek() != 0 {
+		return nil, nil, p.errorf("unexpected '%c'", p.peek())
+	}
+	if len(p.deps) == 0 {
+		return nil, nil, p.errorf("no metric used")
+	}
+	return expr, p.deps, nil
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("METRICDATA/DERIVED > invalid expression '%s' at position %d: %s",
+		p.input, p.pos, fmt.Sprintf(format, args...))
+}
+
+// Skip whitespace and return the next character (0 at the end).
+func (p *exprParser) peek() byte {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+	if p.pos == len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	lhs, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		rhs, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &exprBinary{op: op, lhs: lhs, rhs: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	lhs, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		rhs, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &exprBinary{op: op, lhs: lhs, rhs: rhs}
+	}
+	return lhs, nil
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || isDigit(c)
+}
+
+func (p *exprParser) parseFactor() (exprNode, error) {
+	c := p.peek()
+	switch {
+	case c == '-':
+		p.pos++
+		x, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNegation{x: x}, nil
+	case c == '(':
+		p.pos++
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf("missing ')'")
+		}
+		p.pos++
+		return x, nil
+	case isDigit(c) || c == '.':
+		start := p.pos
+		for p.pos < len(p.input) && (isDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		if p.pos < len(p.input) && (p.input[p.pos] == 'e' || p.input[p.pos] == 'E') {
+			p.pos++
+			if p.pos < len(p.input) && (p.input[p.pos] == '+' || p.input[p.pos] == '-') {
+				p.pos++
+			}
+			for p.pos < len(p.input) && isDigit(p.input[p.pos]) {
+				p.pos++
+			}
+		}
+		x, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, p.errorf("invalid number '%s'", p.input[start:p.pos])
+		}
+		return exprNumber(x), nil
+	case isIdentChar(c):
+		start := p.pos
+		for p.pos < len(p.input) && isIdentChar(p.input[p.pos]) {
+			p.pos++
+		}
+		metric := p.input[start:p.pos]
+		if !util.Contains(p.deps, metric) {
+			p.deps = append(p.deps, metric)
+		}
+		return exprMetric(metric), nil
+	case c == 0:
+		return nil, p.errorf("unexpected end")
+	default:
+		return nil, p.errorf("unexpected '%c'", c)
+	}
+}
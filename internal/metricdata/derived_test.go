@@ -0,0 +1,231 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/archive"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+func TestParseExpression(t *testing.T) {
+	values := map[string]float64{"flops_dp": 2, "flops_sp": 3, "instructions": 10, "cycles": 4}
+	for input, expected := range map[string]float64{
+		"flops_dp * 2 + flops_sp":     7,
+		"flops_dp * (2 + flops_sp)":   10,
+		"instructions / cycles":       2.5,
+		"-flops_dp - -1.5e1":          13,
+		"instructions / (cycles-4)":   math.NaN(),
+		" flops_sp/flops_sp*flops_dp": 2,
+	} {
+		expr, _, err := parseExpression(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x := expr.eval(values); x != expected && !(math.IsNaN(x) && math.IsNaN(expected)) {
+			t.Errorf("%s: expected %f, got %f", input, expected, x)
+		}
+	}
+
+	if _, deps, _ := parseExpression("flops_dp * 2 + flops_sp + flops_dp"); len(deps) != 2 {
+		t.Errorf("unexpected dependencies %v", deps)
+	}
+
+	for _, input := range []string{"", "flops_dp +", "(flops_dp", "flops_dp $ 2", "2 * 3", "flops_dp flops_sp"} {
+		if _, _, err := parseExpression(input); err == nil {
+			t.Errorf("%s: expected error", input)
+		}
+	}
+}
+
+func TestDerivedUnit(t *testing.T) {
+	if u := derivedUnit("GB/s"); u.Prefix != "G" || u.Base != "B/s" {
+		t.Errorf("unexpected unit %+v", u)
+	}
+	if u := derivedUnit("IPC"); u.Prefix != "" || u.Base != "IPC" {
+		t.Errorf("unexpected unit %+v", u)
+	}
+}
+
+func TestAddDerivedJobData(t *testing.T) {
+	t.Cleanup(func() { derivedMetrics = map[string]map[string]*derivedMetric{} })
+	if err := initDerivedMetrics("testcluster", []schema.DerivedMetric{
+		{Name: "ipc", Expression: "instructions / cycles", Unit: "IPC"},
+		{Name: "flops_any", Expression: "flops_dp * 2 + flops_sp", Unit: "GF/s"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := initDerivedMetrics("other", []schema.DerivedMetric{
+		{Name: "a", Expression: "b * 2"}, {Name: "b", Expression: "c"},
+	}); err == nil {
+		t.Fatal("expected error for derived metric depending on derived metric")
+	}
+
+	metrics, derived := expandDerivedMetrics("testcluster", []string{"ipc", "cycles", "mem_bw"})
+	if !derived || len(metrics) != 3 || metrics[0] != "instructions" || metrics[1] != "cycles" {
+		t.Fatalf("unexpected expanded metrics %v", metrics)
+	}
+
+	id0, id1 := "0", "1"
+	jd := schema.JobData{
+		"instructions": {schema.MetricScopeHWThread: {Timestep: 60, Series: []schema.Series{
+			{Hostname: "n1", Id: &id0, Data: []schema.Float{4, 6, schema.NaN}},
+			{Hostname: "n1", Id: &id1, Data: []schema.Float{8, 8, 8}},
+		}}},
+		"cycles": {schema.MetricScopeHWThread: {Timestep: 60, Series: []schema.Series{
+			{Hostname: "n1", Id: &id1, Data: []schema.Float{4, 0, 2}},
+			{Hostname: "n1", Id: &id0, Data: []schema.Float{2, 2, 2}},
+		}}},
+		// No common scope, computed at node scope
+		"flops_dp": {schema.MetricScopeCore: {Timestep: 60, Series: []schema.Series{
+			{Hostname: "n1", Id: &id0, Data: []schema.Float{1, 1}},
+			{Hostname: "n1", Id: &id1, Data: []schema.Float{1, 1}},
+		}}},
+		"flops_sp": {schema.MetricScopeSocket: {Timestep: 60, Series: []schema.Series{
+			{Hostname: "n1", Id: &id0, Data: []schema.Float{3, 5}},
+		}}},
+	}
+	addDerivedJobData("testcluster", jd, []string{"ipc", "cycles", "flops_any"})
+
+	if _, ok := jd["instructions"]; ok || jd["cycles"] == nil {
+		t.Fatal("expected only requested metrics")
+	}
+	ipc := jd["ipc"][schema.MetricScopeHWThread]
+	if ipc == nil || len(ipc.Series) != 2 || ipc.Unit.Base != "IPC" {
+		t.Fatalf("unexpected ipc %+v", ipc)
+	}
+	if s := ipc.Series[0]; *s.Id != "0" || s.Data[0] != 2 || s.Data[1] != 3 || !s.Data[2].IsNaN() || s.Statistics.Avg != 2.5 {
+		t.Fatalf("unexpected ipc series %+v", s)
+	}
+	if s := ipc.Series[1]; s.Data[0] != 2 || !s.Data[1].IsNaN() || s.Data[2] != 4 {
+		t.Fatalf("unexpected ipc series %+v", s)
+	}
+
+	flops := jd["flops_any"][schema.MetricScopeNode]
+	if flops == nil || len(flops.Series) != 1 || flops.Series[0].Data[0] != 7 || flops.Series[0].Data[1] != 9 {
+		t.Fatalf("unexpected flops_any %+v", jd["flops_any"])
+	}
+	if flops.Unit.Prefix != "G" {
+		t.Fatalf("unexpected unit %+v", flops.Unit)
+	}
+}
+
+func TestAddDerivedNodeData(t *testing.T) {
+	t.Cleanup(func() { derivedMetrics = map[string]map[string]*derivedMetric{} })
+	if err := initDerivedMetrics("testcluster", []schema.DerivedMetric{
+		{Name: "ipc", Expression: "instructions / cycles"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := map[string]map[string][]*schema.JobMetric{
+		"n1": {
+			"instructions": {{Timestep: 60, Series: []schema.Series{{Hostname: "n1", Data: []schema.Float{4, 6}}}}},
+			"cycles":       {{Timestep: 60, Series: []schema.Series{{Hostname: "n1", Data: []schema.Float{2, 2}}}}},
+		},
+		"n2": {
+			"cycles": {{Timestep: 60, Series: []schema.Series{{Hostname: "n2", Data: []schema.Float{2, 2}}}}},
+		},
+	}
+	addDerivedNodeData("testcluster", data, []string{"ipc"})
+
+	if len(data["n1"]) != 1 || len(data["n1"]["ipc"]) != 1 || data["n1"]["ipc"][0].Series[0].Data[1] != 3 {
+		t.Fatalf("unexpected node data %v", data["n1"])
+	}
+	if len(data["n2"]) != 0 {
+		t.Fatalf("unexpected node data %v", data["n2"])
+	}
+}
+
+func TestDerivedMetricConfig(t *testing.T) {
+	cluster := setupTestCluster(t,
+		&schema.MetricConfig{Name: "instructions", Scope: schema.MetricScopeHWThread, Aggregation: "sum", Timestep: 60,
+			SubClusters: []*schema.SubClusterConfig{{Name: "gpu", Remove: true}}},
+		&schema.MetricConfig{Name: "cycles", Scope: schema.MetricScopeHWThread, Aggregation: "sum", Timestep: 60},
+		&schema.MetricConfig{Name: "flops_dp", Unit: schema.Unit{Prefix: "G", Base: "F/s"}, Scope: schema.MetricScopeHWThread, Aggregation: "sum", Timestep: 60},
+		&schema.MetricConfig{Name: "flops_sp", Unit: schema.Unit{Prefix: "G", Base: "F/s"}, Scope: schema.MetricScopeSocket, Aggregation: "sum", Timestep: 60},
+		&schema.MetricConfig{Name: "mem_used", Unit: schema.Unit{Prefix: "G", Base: "B"}, Scope: schema.MetricScopeNode, Timestep: 30})
+	cluster.SubClusters = []*schema.SubCluster{{Name: "main"}, {Name: "gpu"}}
+	t.Cleanup(func() {
+		derivedMetrics = map[string]map[string]*derivedMetric{}
+		derivedMetricConfigs = nil
+	})
+
+	configs := []schema.DerivedMetric{
+		{Name: "ipc", Expression: "instructions / cycles", Unit: "IPC"},
+		{Name: "flops_any", Expression: "flops_dp * 2 + flops_sp"},
+		{Name: "missing", Expression: "unknown * 2"},
+	}
+	for i := 0; i < 2; i++ {
+		if err := initDerivedMetrics("testcluster", configs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(archive.GetCluster("testcluster").MetricConfig); n != 7 {
+		t.Fatalf("expected 7 metric configs, got %d", n)
+	}
+
+	ipc := archive.GetMetricConfig("testcluster", "ipc")
+	if ipc == nil || ipc.Unit.Base != "IPC" || ipc.Scope != schema.MetricScopeHWThread ||
+		ipc.Aggregation != "avg" || ipc.Timestep != 60 || len(ipc.SubClusters) != 1 || !ipc.SubClusters[0].Remove {
+		t.Fatalf("unexpected config %+v", ipc)
+	}
+	flops := archive.GetMetricConfig("testcluster", "flops_any")
+	if flops == nil || flops.Unit.Prefix != "G" || flops.Scope != schema.MetricScopeSocket || flops.Aggregation != "sum" {
+		t.Fatalf("unexpected config %+v", flops)
+	}
+	if archive.GetMetricConfig("testcluster", "missing") != nil {
+		t.Fatal("derived metric with unknown dependency configured")
+	}
+
+	if err := initDerivedMetrics("testcluster", []schema.DerivedMetric{
+		{Name: "mixed", Expression: "mem_used + flops_dp"},
+	}); err == nil {
+		t.Fatal("expected error for dependencies with different timesteps")
+	}
+}
+
+func TestArchiveJobDerivedMetric(t *testing.T) {
+	metricDataRepos["testcluster"] = setupEmbedded(t, "")
+	prevUseArchive := useArchive
+	useArchive = false
+	t.Cleanup(func() {
+		delete(metricDataRepos, "testcluster")
+		useArchive = prevUseArchive
+		derivedMetrics = map[string]map[string]*derivedMetric{}
+		derivedMetricConfigs = nil
+	})
+
+	if err := initDerivedMetrics("testcluster", []schema.DerivedMetric{
+		{Name: "mem_used_mb", Expression: "mem_used * 1000", Unit: "MB"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteLineProtocol("testcluster", strings.NewReader(embeddedTestData)); err != nil {
+		t.Fatal(err)
+	}
+
+	job := &schema.Job{}
+	job.Cluster = "testcluster"
+	job.State = schema.JobStateCompleted
+	job.NumNodes = 1
+	job.StartTime = time.Unix(1700000000, 0)
+	job.Duration = 120
+	job.Resources = []*schema.Resource{{Hostname: "n1"}}
+
+	jobMeta, err := ArchiveJob(job, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, ok := jobMeta.Statistics["mem_used_mb"]
+	if !ok || stats.Avg != 15000 || stats.Max != 20000 || stats.Unit.Prefix != "M" || stats.Unit.Base != "B" {
+		t.Fatalf("unexpected statistics %+v", jobMeta.Statistics)
+	}
+}
@@ -0,0 +1,547 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/internal/util"
+	"github.com/ClusterCockpit/cc-backend/pkg/archive"
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+type EmbeddedMetricStoreConfig struct {
+	// Time for which samples are kept (default: 48h)
+	Retention string `json:"retention"`
+	// Directory for checkpoints, checkpointing is disabled if empty
+	Path string `json:"path"`
+	// Time between checkpoints (default: 1h)
+	CheckpointInterval string `json:"checkpoint-interval"`
+}
+
+type embeddedKey struct {
+	metric string
+	scope  schema.MetricScope
+	host   string
+}
+
+// Ring buffer holding the samples of the slots (time / timestep) in the range
+// (Last - len(Data), Last].
+type embeddedBuffer struct {
+	Timestep int            `json:"timestep"`
+	Last     int64          `json:"last"`
+	Data     []schema.Float `json:"data"`
+}
+
+func newEmbeddedBuffer(timestep, size int, t int64) *embeddedBuffer {
+	b := &embeddedBuffer{
+		Timestep: timestep,
+		Last:     t/int64(timestep) - 1,
+		Data:     make([]schema.Float, size),
+	}
+	for i := range b.Data {
+		b.Data[i] = schema.NaN
+	}
+	return b
+}
+
+// Samples older than the buffer or before 1970 are dropped.
+func (b *embeddedBuffer) write(t int64, value schema.Float) {
+	if b.Timestep <= 0 || len(b.Data) == 0 || t < 0 {
+		return
+	}
+
+	slot, n := t/int64(b.Timestep), int64(len(b.Data))
+	if slot <= b.Last-n {
+		return
+	}
+
+	// Clear the slots skipped since the last sample
+	for s := util.Max(b.Last+1, slot-n+1); s < slot; s++ {
+		b.Data[s%n] = schema.NaN
+	}
+	b.Data[slot%n] = value
+	if slot > b.Last {
+		b.Last = slot
+	}
+}
+
+// Maximum number of NaN slots read pads before the oldest and after the
+// newest slot of a buffer. Longer time ranges (e.g. from=0) are cut to bound
+// the allocation, their data then starts later than requested.
+const embeddedMaxPadding = 1440
+
+func (b *embeddedBuffer) read(from, to int64) []schema.Float {
+	first, last, n := from/int64(b.Timestep), to/int64(b.Timestep), int64(len(b.Data))
+	if last < first {
+		last = first
+	}
+	first = util.Max(first, b.Last-n+1-embeddedMaxPadding)
+	last = util.Min(last, b.Last+embeddedMaxPadding)
+	if last < first {
+		return []schema.Float{}
+	}
+
+	data := make([]schema.Float, last-first+1)
+	for s := first; s <= last; s++ {
+		if s < 0 || s > b.Last || s <= b.Last-n {
+			data[s-first] = schema.NaN
+		} else {
+			data[s-first] = b.Data[s%n]
+		}
+	}
+	return data
+}
+
+// In-process metric store for small clusters: Samples pushed in InfluxDB
+// line protocol (see WriteLineProtocol) are kept in ring buffers per metric,
+// scope, host and id, sized by the retention and the timestep of the metric
+// in the cluster config. The buffers are checkpointed to disk periodically and
+// on Shutdown and restored on startup.
+type EmbeddedMetricStore struct {
+	cluster            string
+	retention          time.Duration
+	path               string
+	checkpointInterval time.Duration
+	done               chan struct{}
+
+	mu sync.RWMutex
+	// Map of metrics, scopes and hosts to ids (empty for node scope) to buffers
+	buffers      map[embeddedKey]map[string]*embeddedBuffer
+	metricScopes map[string][]schema.MetricScope
+}
+
+var (
+	embeddedStoresMu sync.Mutex
+	embeddedStores   map[string]*EmbeddedMetricStore = map[string]*EmbeddedMetricStore{}
+)
+
+func (ems *EmbeddedMetricStore) Init(rawConfig json.RawMessage) error {
+	config := EmbeddedMetricStoreConfig{
+		Retention:          "48h",
+		CheckpointInterval: "1h",
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		log.Warn("Error while unmarshaling raw json config")
+		return err
+	}
+
+	var err error
+	if ems.retention, err = time.ParseDuration(config.Retention); err != nil || ems.retention <= 0 {
+		return fmt.Errorf("METRICDATA/EMBEDDED > invalid retention '%s'", config.Retention)
+	}
+	if ems.checkpointInterval, err = time.ParseDuration(config.CheckpointInterval); err != nil || ems.checkpointInterval <= 0 {
+		return fmt.Errorf("METRICDATA/EMBEDDED > invalid checkpoint-interval '%s'", config.CheckpointInterval)
+	}
+
+	ems.path = config.Path
+	ems.buffers = make(map[embeddedKey]map[string]*embeddedBuffer)
+	ems.metricScopes = make(map[string][]schema.MetricScope)
+	if ems.path != "" {
+		if err := os.MkdirAll(ems.path, 0777); err != nil {
+			log.Errorf("Error while creating checkpoint directory %s", ems.path)
+			return err
+		}
+		if err := ems.restore(); err != nil {
+			return err
+		}
+
+		done := make(chan struct{})
+		ems.done = done
+		go func() {
+			ticker := time.NewTicker(ems.checkpointInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if err := ems.checkpoint(); err != nil {
+						log.Errorf("Error while writing checkpoint of cluster %s: %s", ems.cluster, err.Error())
+					}
+				}
+			}
+		}()
+	}
+
+	embeddedStoresMu.Lock()
+	embeddedStores[ems.cluster] = ems
+	embeddedStoresMu.Unlock()
+	return nil
+}
+
+// Timestep of metric in the cluster and the buffer size in slots for it, 0 for
+// unknown metrics.
+func (ems *EmbeddedMetricStore) timestepAndSize(metric string) (int, int) {
+	mc := archive.GetMetricConfig(ems.cluster, metric)
+	if mc == nil {
+		return 0, 0
+	}
+
+	timestep := mc.Timestep
+	if timestep <= 0 {
+		timestep = 60
+	}
+	size := int(ems.retention / (time.Duration(timestep) * time.Second))
+	if size < 1 {
+		size = 1
+	}
+	return timestep, size
+}
+
+// Write a sample, the caller has to hold the write lock.
+func (ems *EmbeddedMetricStore) write(p linePoint) bool {
+	timestep, size := ems.timestepAndSize(p.metric)
+	if timestep == 0 {
+		return false
+	}
+
+	key := embeddedKey{metric: p.metric, scope: p.scope, host: p.host}
+	ids, ok := ems.buffers[key]
+	if !ok {
+		ids = make(map[string]*embeddedBuffer)
+		ems.buffers[key] = ids
+		if !contains(ems.metricScopes[p.metric], p.scope) {
+			ems.metricScopes[p.metric] = append(ems.metricScopes[p.metric], p.scope)
+		}
+	}
+
+	if p.scope == schema.MetricScopeNode {
+		p.id = ""
+	}
+	b, ok := ids[p.id]
+	if !ok || b.Timestep != timestep {
+		b = newEmbeddedBuffer(timestep, size, p.time)
+		ids[p.id] = b
+	}
+
+	b.write(p.time, schema.Float(p.value))
+	return true
+}
+
+// Writes the samples in InfluxDB line protocol read from r (see decodeLine)
+// to the embedded metric stores of their clusters. Samples without cluster
+// tag are written to the store of cluster. Invalid lines and samples of
+// unknown clusters or metrics are skipped and reported in the returned error.
+func WriteLineProtocol(cluster string, r io.Reader) error {
+	points := make(map[string][]linePoint)
+	invalid, skipped := 0, make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		p, ok := decodeLine(line)
+		if !ok {
+			invalid++
+			continue
+		}
+		if p.cluster == "" {
+			p.cluster = cluster
+		}
+		points[p.cluster] = append(points[p.cluster], p)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Warn("Error while reading line protocol")
+		return err
+	}
+
+	stores := make(map[string]*EmbeddedMetricStore, len(points))
+	embeddedStoresMu.Lock()
+	for cluster := range points {
+		if ems, ok := embeddedStores[cluster]; ok {
+			stores[cluster] = ems
+		}
+	}
+	embeddedStoresMu.Unlock()
+
+	for cluster, points := range points {
+		ems, ok := stores[cluster]
+		if !ok {
+			skipped[fmt.Sprintf("no embedded metric store for cluster '%s'", cluster)] = true
+			continue
+		}
+		ems.writePoints(points, skipped)
+	}
+
+	var errs []string
+	if invalid > 0 {
+		errs = append(errs, fmt.Sprintf("%d invalid lines", invalid))
+	}
+	for s := range skipped {
+		errs = append(errs, s)
+	}
+	if len(errs) != 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("METRICDATA/EMBEDDED > Errors: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Write samples of the cluster of the store, unknown metrics are added to
+// skipped.
+func (ems *EmbeddedMetricStore) writePoints(points []linePoint, skipped map[string]bool) {
+	ems.mu.Lock()
+	defer ems.mu.Unlock()
+
+	for _, p := range points {
+		if !ems.write(p) {
+			skipped[fmt.Sprintf("unknown metric '%s' of cluster '%s'", p.metric, p.cluster)] = true
+		}
+	}
+}
+
+type embeddedCheckpoint struct {
+	Metric string             `json:"metric"`
+	Scope  schema.MetricScope `json:"scope"`
+	Host   string             `json:"host"`
+	Id     string             `json:"id,omitempty"`
+	embeddedBuffer
+}
+
+func (ems *EmbeddedMetricStore) checkpointFile() string {
+	return filepath.Join(ems.path, fmt.Sprintf("%s.json", ems.cluster))
+}
+
+// Write all buffers to the checkpoint file of the cluster.
+func (ems *EmbeddedMetricStore) checkpoint() error {
+	ems.mu.RLock()
+	checkpoints := make([]embeddedCheckpoint, 0, len(ems.buffers))
+	for key, ids := range ems.buffers {
+		for id, b := range ids {
+			checkpoints = append(checkpoints, embeddedCheckpoint{
+				Metric:         key.metric,
+				Scope:          key.scope,
+				Host:           key.host,
+				Id:             id,
+				embeddedBuffer: *b,
+			})
+		}
+	}
+	data, err := json.Marshal(checkpoints)
+	ems.mu.RUnlock()
+	if err != nil {
+		log.Warn("Error while marshaling checkpoint")
+		return err
+	}
+
+	tmp := ems.checkpointFile() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		log.Warnf("Error while writing checkpoint %s", tmp)
+		return err
+	}
+	return os.Rename(tmp, ems.checkpointFile())
+}
+
+// Restore the buffers from the checkpoint file of the cluster. Samples are
+// written again so that changed timesteps or retentions apply.
+func (ems *EmbeddedMetricStore) restore() error {
+	data, err := os.ReadFile(ems.checkpointFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		log.Warnf("Error while reading checkpoint %s", ems.checkpointFile())
+		return err
+	}
+
+	var checkpoints []embeddedCheckpoint
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		log.Warnf("Error while unmarshaling checkpoint %s", ems.checkpointFile())
+		return err
+	}
+
+	ems.mu.Lock()
+	defer ems.mu.Unlock()
+	n := 0
+	for _, c := range checkpoints {
+		if c.Timestep <= 0 || len(c.Data) == 0 {
+			continue
+		}
+
+		for s := util.Max(c.Last-int64(len(c.Data))+1, 0); s <= c.Last; s++ {
+			x := c.Data[s%int64(len(c.Data))]
+			if x.IsNaN() {
+				continue
+			}
+			if ems.write(linePoint{
+				metric: c.Metric,
+				scope:  c.Scope,
+				host:   c.Host,
+				id:     c.Id,
+				value:  float64(x),
+				time:   s * int64(c.Timestep),
+			}) {
+				n++
+			}
+		}
+	}
+
+	log.Infof("Restored %d samples of cluster %s from %s", n, ems.cluster, ems.checkpointFile())
+	return nil
+}
+
+// Write the checkpoints of all embedded metric stores and stop checkpointing.
+func Shutdown() {
+	embeddedStoresMu.Lock()
+	defer embeddedStoresMu.Unlock()
+
+	for _, ems := range embeddedStores {
+		if ems.done == nil {
+			continue
+		}
+
+		close(ems.done)
+		ems.done = nil
+		if err := ems.checkpoint(); err != nil {
+			log.Errorf("Error while writing checkpoint of cluster %s: %s", ems.cluster, err.Error())
+		}
+	}
+}
+
+func (ems *EmbeddedMetricStore) scopes(metric string) []schema.MetricScope {
+	ems.mu.RLock()
+	defer ems.mu.RUnlock()
+	return ems.metricScopes[metric]
+}
+
+func (ems *EmbeddedMetricStore) series(
+	metric string,
+	scope schema.MetricScope,
+	host string,
+	ids []string,
+	from, to time.Time) []schema.Series {
+
+	ems.mu.RLock()
+	defer ems.mu.RUnlock()
+
+	buffers := ems.buffers[embeddedKey{metric: metric, scope: scope, host: host}]
+	if ids == nil {
+		ids = make([]string, 0, len(buffers))
+		for id := range buffers {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+
+	res := make([]schema.Series, 0, len(ids))
+	for _, id := range ids {
+		b, ok := buffers[id]
+		if !ok {
+			continue
+		}
+
+		var idPtr *string
+		if scope != schema.MetricScopeNode {
+			id := id
+			idPtr = &id
+		}
+		res = append(res, newSeries(host, idPtr, b.read(from.Unix(), to.Unix())))
+	}
+	return res
+}
+
+func (ems *EmbeddedMetricStore) timestep(metric string, scope schema.MetricScope) int {
+	timestep, _ := ems.timestepAndSize(metric)
+	return timestep
+}
+
+func (ems *EmbeddedMetricStore) unit(metric string) schema.Unit {
+	if mc := archive.GetMetricConfig(ems.cluster, metric); mc != nil {
+		return mc.Unit
+	}
+	return schema.Unit{}
+}
+
+func (ems *EmbeddedMetricStore) load(
+	resources []*schema.Resource,
+	metrics []string,
+	scopes []schema.MetricScope,
+	from, to time.Time) (schema.JobData, error) {
+
+	jobData, errs := loadLocal(ems, resources, metrics, scopes, from, to)
+	if len(errs) != 0 {
+		return jobData, fmt.Errorf("METRICDATA/EMBEDDED > Errors: %s", strings.Join(errs, ", "))
+	}
+	return jobData, nil
+}
+
+func (ems *EmbeddedMetricStore) LoadData(
+	job *schema.Job,
+	metrics []string,
+	scopes []schema.MetricScope,
+	ctx context.Context) (schema.JobData, error) {
+
+	to := job.StartTime.Add(time.Duration(job.Duration) * time.Second)
+	if job.State == schema.JobStateRunning {
+		to = time.Now()
+	}
+
+	return ems.load(job.Resources, metrics, scopes, job.StartTime, to)
+}
+
+func (ems *EmbeddedMetricStore) LoadStats(
+	job *schema.Job,
+	metrics []string,
+	ctx context.Context) (map[string]map[string]schema.MetricStatistics, error) {
+
+	jobData, err := ems.LoadData(job, metrics, []schema.MetricScope{schema.MetricScopeNode}, ctx)
+	return localStats(jobData), err
+}
+
+func (ems *EmbeddedMetricStore) LoadStatsBatch(
+	jobs []*schema.Job,
+	metrics []string,
+	ctx context.Context) ([]map[string]map[string]schema.MetricStatistics, error) {
+
+	return loadStatsPerJob(ems, jobs, metrics, ctx)
+}
+
+func (ems *EmbeddedMetricStore) LoadNodeData(
+	cluster string,
+	metrics, nodes []string,
+	scopes []schema.MetricScope,
+	from, to time.Time,
+	ctx context.Context) (map[string]map[string][]*schema.JobMetric, error) {
+
+	if nodes == nil {
+		ems.mu.RLock()
+		hosts := make(map[string]bool)
+		for key := range ems.buffers {
+			hosts[key.host] = true
+		}
+		ems.mu.RUnlock()
+
+		for host := range hosts {
+			nodes = append(nodes, host)
+		}
+		sort.Strings(nodes)
+	}
+	if len(scopes) == 0 {
+		scopes = []schema.MetricScope{schema.MetricScopeNode}
+	}
+
+	resources := make([]*schema.Resource, 0, len(nodes))
+	for _, node := range nodes {
+		resources = append(resources, &schema.Resource{Hostname: node})
+	}
+
+	jobData, err := ems.load(resources, metrics, scopes, from, to)
+	return localNodeData(jobData), err
+}
@@ -0,0 +1,178 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+const embeddedTestData = `
+flops_any,cluster=testcluster,hostname=n1,type=hwthread,type-id=0 value=1 1700000000000000000
+flops_any,cluster=testcluster,hostname=n1,type=hwthread,type-id=1 value=2 1700000000000000000
+flops_any,hostname=n1,type=hwthread,type-id=0 value=3 1700000060000000000
+flops_any,hostname=n1,type=hwthread,type-id=1 value=4 1700000060000000000
+mem_used,hostname=n1 value=10 1700000000
+mem_used,hostname=n1 value=20 1700000120
+`
+
+func setupEmbedded(t *testing.T, path string) *EmbeddedMetricStore {
+	setupTestCluster(t,
+		&schema.MetricConfig{Name: "flops_any", Unit: schema.Unit{Prefix: "G", Base: "F/s"}, Timestep: 60},
+		&schema.MetricConfig{Name: "mem_used", Unit: schema.Unit{Prefix: "G", Base: "B"}, Timestep: 60})
+
+	ems := &EmbeddedMetricStore{cluster: "testcluster"}
+	config, _ := json.Marshal(EmbeddedMetricStoreConfig{Retention: "5m", CheckpointInterval: "1h", Path: path})
+	if err := ems.Init(config); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(Shutdown)
+	return ems
+}
+
+func TestEmbeddedBuffer(t *testing.T) {
+	b := newEmbeddedBuffer(10, 3, 100)
+	b.write(100, 1)
+	b.write(120, 3)
+	b.write(110, 2)
+	b.write(90, 0)
+	if data := b.read(90, 130); !data[0].IsNaN() || data[1] != 1 || data[2] != 2 || data[3] != 3 || !data[4].IsNaN() {
+		t.Fatalf("unexpected data %v", data)
+	}
+
+	// Older slots are overwritten
+	b.write(140, 5)
+	if data := b.read(100, 140); !data[0].IsNaN() || !data[1].IsNaN() || data[2] != 3 || !data[3].IsNaN() || data[4] != 5 {
+		t.Fatalf("unexpected data %v", data)
+	}
+
+	b.write(1000, 6)
+	if data := b.read(980, 1000); !data[0].IsNaN() || !data[1].IsNaN() || data[2] != 6 {
+		t.Fatalf("unexpected data %v", data)
+	}
+
+	// Samples before 1970 are dropped
+	b = newEmbeddedBuffer(10, 3, 0)
+	b.write(-10, 1)
+	b.write(0, 2)
+	if data := b.read(-20, 0); !data[0].IsNaN() || !data[1].IsNaN() || data[2] != 2 {
+		t.Fatalf("unexpected data %v", data)
+	}
+
+	(&embeddedBuffer{Timestep: 10}).write(100, 1)
+
+	// Time ranges far beyond the buffer are cut
+	if data := b.read(-1700000000, 1700000000); len(data) != 3+2*embeddedMaxPadding || data[embeddedMaxPadding+2] != 2 {
+		t.Fatalf("unexpected data of length %d", len(data))
+	}
+	if data := b.read(1700000000, 1700000060); len(data) != 0 {
+		t.Fatalf("unexpected data %v", data)
+	}
+}
+
+func TestEmbeddedLoadData(t *testing.T) {
+	ems := setupEmbedded(t, "")
+
+	err := WriteLineProtocol("testcluster", strings.NewReader(embeddedTestData+"unknown,hostname=n1 value=1\ninvalid\n"))
+	if err == nil || !strings.Contains(err.Error(), "1 invalid lines") || !strings.Contains(err.Error(), "unknown metric 'unknown'") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := WriteLineProtocol("other", strings.NewReader("mem_used,hostname=n1 value=1")); err == nil {
+		t.Fatal("expected error for cluster without embedded metric store")
+	}
+
+	job := &schema.Job{}
+	job.Cluster = "testcluster"
+	job.State = schema.JobStateCompleted
+	job.StartTime = time.Unix(1700000000, 0)
+	job.Duration = 120
+	job.Resources = []*schema.Resource{{Hostname: "n1", HWThreads: []int{0, 1}}}
+
+	jd, err := ems.LoadData(job, []string{"flops_any", "mem_used"}, []schema.MetricScope{schema.MetricScopeNode}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flops := jd["flops_any"][schema.MetricScopeNode]
+	if flops == nil || len(jd["flops_any"]) != 1 || flops.Unit.Prefix != "G" {
+		t.Fatalf("expected aggregated node data, got %v", jd["flops_any"])
+	}
+	if data := flops.Series[0].Data; len(data) != 3 || data[0] != 3 || data[1] != 7 {
+		t.Fatalf("unexpected data %v", data)
+	}
+	if data := jd["mem_used"][schema.MetricScopeNode].Series[0].Data; data[0] != 10 || !data[1].IsNaN() || data[2] != 20 {
+		t.Fatalf("unexpected data %v", data)
+	}
+
+	job.Resources[0].HWThreads = []int{1}
+	jd, err = ems.LoadData(job, []string{"flops_any"}, []schema.MetricScope{schema.MetricScopeHWThread}, context.Background())
+	if hwthreads := jd["flops_any"][schema.MetricScopeHWThread]; err != nil || len(hwthreads.Series) != 1 || *hwthreads.Series[0].Id != "1" {
+		t.Fatalf("unexpected hwthread data %v, %v", jd, err)
+	}
+
+	stats, err := ems.LoadStats(job, []string{"mem_used"}, context.Background())
+	if err != nil || stats["mem_used"]["n1"].Max != 20 {
+		t.Fatalf("unexpected stats %v, %v", stats, err)
+	}
+
+	nodeData, err := ems.LoadNodeData("testcluster", []string{"mem_used"}, nil, nil, job.StartTime, job.StartTime, context.Background())
+	if err != nil || len(nodeData["n1"]["mem_used"]) != 1 {
+		t.Fatalf("unexpected node data %v, %v", nodeData, err)
+	}
+}
+
+func TestEmbeddedCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	setupEmbedded(t, dir)
+	if err := WriteLineProtocol("testcluster", strings.NewReader(embeddedTestData)); err != nil {
+		t.Fatal(err)
+	}
+	Shutdown()
+
+	ems := setupEmbedded(t, dir)
+	series := ems.series("mem_used", schema.MetricScopeNode, "n1", nil, time.Unix(1700000000, 0), time.Unix(1700000120, 0))
+	if len(series) != 1 || series[0].Data[0] != 10 || series[0].Data[2] != 20 {
+		t.Fatalf("unexpected restored series %v", series)
+	}
+	if scopes := ems.scopes("flops_any"); len(scopes) != 1 || scopes[0] != schema.MetricScopeHWThread {
+		t.Fatalf("unexpected restored scopes %v", scopes)
+	}
+}
+
+func TestEmbeddedInvalidSamples(t *testing.T) {
+	if _, ok := decodeLine("mem_used,hostname=n1 value=1 -1700000000"); ok {
+		t.Fatal("expected negative timestamp to be invalid")
+	}
+
+	dir := t.TempDir()
+	checkpoint := `[
+		{"metric": "mem_used", "scope": "node", "host": "n1", "timestep": 60, "last": 1, "data": [1, 2, 3, 4]},
+		{"metric": "mem_used", "scope": "node", "host": "n2", "timestep": 60, "last": 5, "data": []}
+	]`
+	if err := os.WriteFile(filepath.Join(dir, "testcluster.json"), []byte(checkpoint), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	ems := setupEmbedded(t, dir)
+	series := ems.series("mem_used", schema.MetricScopeNode, "n1", nil, time.Unix(0, 0), time.Unix(60, 0))
+	if len(series) != 1 || series[0].Data[0] != 1 || series[0].Data[1] != 2 {
+		t.Fatalf("unexpected restored series %v", series)
+	}
+	if series := ems.series("mem_used", schema.MetricScopeNode, "n2", nil, time.Unix(0, 0), time.Unix(60, 0)); len(series) != 0 {
+		t.Fatalf("unexpected restored series %v", series)
+	}
+
+	err := WriteLineProtocol("testcluster", strings.NewReader("mem_used,hostname=n1 value=5 -60\nmem_used,hostname=n1 value=5 120\n"))
+	if err == nil || !strings.Contains(err.Error(), "1 invalid lines") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
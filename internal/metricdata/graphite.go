@@ -0,0 +1,367 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/archive"
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+type GraphiteDataRepositoryConfig struct {
+	Url      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	// Appended to the hostnames of the cluster to get the node names used
+	// in the metric paths (for example "_example_com").
+	Suffix string `json:"suffix,omitempty"`
+	// Index of the node of the metric paths holding the node name.
+	HostnameNode int `json:"hostname-node"`
+	// Target expression per metric, see GraphiteArgs for the arguments.
+	Templates map[string]string `json:"path-templates"`
+}
+
+type GraphiteDataRepository struct {
+	client       http.Client
+	renderUrl    string
+	username     string
+	password     string
+	suffix       string
+	hostnameNode int
+	templates    map[string]*template.Template
+}
+
+type GraphiteArgs struct {
+	Cluster string
+	// Glob matching the node names, for example "{node1,node2}"
+	Nodes string
+}
+
+// A single series of a response of the render API
+type graphiteSeries struct {
+	Target     string            `json:"target"`
+	Datapoints [][2]*json.Number `json:"datapoints"`
+}
+
+func (gdb *GraphiteDataRepository) Init(rawConfig json.RawMessage) error {
+	var config GraphiteDataRepositoryConfig
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		log.Warn("Error while unmarshaling raw json config")
+		return err
+	}
+	if config.Url == "" {
+		return errors.New("METRICDATA/GRAPHITE > no url configured")
+	}
+
+	// support basic authentication
+	if config.Username != "" {
+		gdb.password = os.Getenv("GRAPHITE_PASSWORD")
+		if gdb.password == "" {
+			return errors.New("METRICDATA/GRAPHITE > Graphite username provided, but GRAPHITE_PASSWORD not set")
+		}
+	}
+
+	gdb.client = http.Client{
+		Timeout: 10 * time.Second,
+	}
+	gdb.renderUrl = strings.TrimSuffix(config.Url, "/") + "/render"
+	gdb.username = config.Username
+	gdb.suffix = config.Suffix
+	gdb.hostnameNode = config.HostnameNode
+	gdb.templates = make(map[string]*template.Template)
+	for metric, templ := range config.Templates {
+		t, err := template.New(metric).Parse(templ)
+		if err != nil {
+			log.Warnf("Failed to parse Graphite path template %s for metric %s", templ, metric)
+			continue
+		}
+		gdb.templates[metric] = t
+		log.Debugf("Added Graphite path template for %s: %s", metric, templ)
+	}
+
+	return nil
+}
+
+// Build the render target for metric and nodes (all nodes if empty). The
+// target is wrapped into aliasByNode() so that the returned series are
+// named after the node.
+func (gdb *GraphiteDataRepository) FormatTarget(
+	metric string,
+	nodes []string,
+	cluster string) (string, error) {
+
+	templ, ok := gdb.templates[metric]
+	if !ok {
+		return "", fmt.Errorf("METRICDATA/GRAPHITE > No path template for metric %s configured", metric)
+	}
+
+	args := GraphiteArgs{Cluster: cluster, Nodes: "*"}
+	if len(nodes) == 1 {
+		args.Nodes = nodes[0] + gdb.suffix
+	} else if len(nodes) > 1 {
+		names := make([]string, len(nodes))
+		for i, node := range nodes {
+			names[i] = node + gdb.suffix
+		}
+		args.Nodes = "{" + strings.Join(names, ",") + "}"
+	}
+
+	buf := &bytes.Buffer{}
+	if err := templ.Execute(buf, args); err != nil {
+		return "", fmt.Errorf("METRICDATA/GRAPHITE > Error compiling template %s: %w", metric, err)
+	}
+
+	target := fmt.Sprintf("aliasByNode(%s, %d)", buf.String(), gdb.hostnameNode)
+	log.Debugf("Graphite target: %s", target)
+	return target, nil
+}
+
+func (gdb *GraphiteDataRepository) render(
+	ctx context.Context,
+	target string,
+	from, to time.Time) ([]graphiteSeries, error) {
+
+	params := url.Values{}
+	params.Set("target", target)
+	params.Set("from", strconv.FormatInt(from.Unix(), 10))
+	params.Set("until", strconv.FormatInt(to.Unix(), 10))
+	params.Set("format", "json")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gdb.renderUrl+"?"+params.Encode(), nil)
+	if err != nil {
+		log.Warn("Error while building request")
+		return nil, err
+	}
+	if gdb.username != "" {
+		req.SetBasicAuth(gdb.username, gdb.password)
+	}
+
+	res, err := gdb.client.Do(req)
+	if err != nil {
+		log.Error("Error while performing request")
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("'%s': HTTP Status: %s", gdb.renderUrl, res.Status)
+	}
+
+	var series []graphiteSeries
+	if err := json.NewDecoder(bufio.NewReader(res.Body)).Decode(&series); err != nil {
+		log.Warn("Error while decoding result body")
+		return nil, err
+	}
+
+	return series, nil
+}
+
+// Convert a series of the render API to a schema.Series with one value per
+// timestep. Datapoints within the same timestep are averaged.
+func (gdb *GraphiteDataRepository) toSeries(
+	from time.Time,
+	step int64,
+	steps int64,
+	s graphiteSeries) schema.Series {
+
+	sums := make([]float64, steps+1)
+	counts := make([]int, steps+1)
+	for _, dp := range s.Datapoints {
+		if dp[0] == nil || dp[1] == nil {
+			continue
+		}
+		value, err := dp[0].Float64()
+		if err != nil {
+			continue
+		}
+		ts, err := dp[1].Int64()
+		if err != nil {
+			continue
+		}
+
+		idx := (ts - from.Unix()) / step
+		if idx < 0 || idx > steps {
+			continue
+		}
+		sums[idx] += value
+		counts[idx]++
+	}
+
+	values := make([]schema.Float, steps+1)
+	for i := range values {
+		if counts[i] == 0 {
+			values[i] = schema.NaN
+		} else {
+			values[i] = schema.Float(sums[i] / float64(counts[i]))
+		}
+	}
+
+	min, max, mean := MinMaxMean(values)
+	return schema.Series{
+		Hostname: strings.TrimSuffix(s.Target, gdb.suffix),
+		Data:     values,
+		Statistics: schema.MetricStatistics{
+			Avg: mean,
+			Min: min,
+			Max: max,
+		},
+	}
+}
+
+// Query metric for nodes and return one series per node.
+func (gdb *GraphiteDataRepository) loadMetric(
+	ctx context.Context,
+	cluster, metric string,
+	nodes []string,
+	from, to time.Time) (*schema.MetricConfig, []schema.Series, error) {
+
+	metricConfig := archive.GetMetricConfig(cluster, metric)
+	if metricConfig == nil {
+		log.Warnf("Metric %s for cluster %s not configured", metric, cluster)
+		return nil, nil, errors.New("Graphite config error")
+	}
+
+	target, err := gdb.FormatTarget(metric, nodes, cluster)
+	if err != nil {
+		log.Warn("Error while formatting graphite target")
+		return nil, nil, err
+	}
+
+	result, err := gdb.render(ctx, target, from, to)
+	if err != nil {
+		log.Errorf("Graphite query error: %v\nTarget: %s", err, target)
+		return nil, nil, errors.New("Graphite query error")
+	}
+
+	step := int64(metricConfig.Timestep)
+	steps := int64(to.Sub(from).Seconds()) / step
+	series := make([]schema.Series, 0, len(result))
+	for _, s := range result {
+		series = append(series, gdb.toSeries(from, step, steps, s))
+	}
+	// sort by hostname to get uniform coloring
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].Hostname < series[j].Hostname
+	})
+
+	return metricConfig, series, nil
+}
+
+func (gdb *GraphiteDataRepository) LoadData(
+	job *schema.Job,
+	metrics []string,
+	scopes []schema.MetricScope,
+	ctx context.Context) (schema.JobData, error) {
+
+	if len(scopes) > 0 && !contains(scopes, schema.MetricScopeNode) {
+		logOnce.Do(func() {
+			log.Infof("Scopes %v requested, but Graphite only supports 'node' scope.", scopes)
+		})
+	}
+
+	nodes := make([]string, len(job.Resources))
+	for i, resource := range job.Resources {
+		nodes[i] = resource.Hostname
+	}
+	from := job.StartTime
+	to := job.StartTime.Add(time.Duration(job.Duration) * time.Second)
+
+	jobData := make(schema.JobData)
+	for _, metric := range metrics {
+		metricConfig, series, err := gdb.loadMetric(ctx, job.Cluster, metric, nodes, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		// only add metric if at least one host returned data
+		if len(series) == 0 {
+			continue
+		}
+		jobData[metric] = map[schema.MetricScope]*schema.JobMetric{
+			schema.MetricScopeNode: {
+				Unit:     metricConfig.Unit,
+				Timestep: metricConfig.Timestep,
+				Series:   series,
+			},
+		}
+	}
+
+	return jobData, nil
+}
+
+func (gdb *GraphiteDataRepository) LoadStats(
+	job *schema.Job,
+	metrics []string,
+	ctx context.Context) (map[string]map[string]schema.MetricStatistics, error) {
+
+	data, err := gdb.LoadData(job, metrics, []schema.MetricScope{schema.MetricScopeNode}, ctx)
+	if err != nil {
+		log.Warn("Error while loading job for stats")
+		return nil, err
+	}
+
+	stats := make(map[string]map[string]schema.MetricStatistics, len(data))
+	for metric, metricData := range data {
+		stats[metric] = make(map[string]schema.MetricStatistics)
+		for _, series := range metricData[schema.MetricScopeNode].Series {
+			stats[metric][series.Hostname] = series.Statistics
+		}
+	}
+
+	return stats, nil
+}
+
+func (gdb *GraphiteDataRepository) LoadStatsBatch(
+	jobs []*schema.Job,
+	metrics []string,
+	ctx context.Context) ([]map[string]map[string]schema.MetricStatistics, error) {
+
+	return loadStatsPerJob(gdb, jobs, metrics, ctx)
+}
+
+func (gdb *GraphiteDataRepository) LoadNodeData(
+	cluster string,
+	metrics, nodes []string,
+	scopes []schema.MetricScope,
+	from, to time.Time,
+	ctx context.Context) (map[string]map[string][]*schema.JobMetric, error) {
+
+	data := make(map[string]map[string][]*schema.JobMetric)
+	for _, metric := range metrics {
+		metricConfig, series, err := gdb.loadMetric(ctx, cluster, metric, nodes, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range series {
+			hostdata, ok := data[s.Hostname]
+			if !ok {
+				hostdata = make(map[string][]*schema.JobMetric)
+				data[s.Hostname] = hostdata
+			}
+			hostdata[metric] = append(hostdata[metric], &schema.JobMetric{
+				Unit:     metricConfig.Unit,
+				Timestep: metricConfig.Timestep,
+				Series:   []schema.Series{s},
+			})
+		}
+	}
+
+	return data, nil
+}
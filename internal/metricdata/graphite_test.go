@@ -0,0 +1,99 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+func setupGraphite(t *testing.T) (*GraphiteDataRepository, *[]string) {
+	setupTestCluster(t, &schema.MetricConfig{Name: "flops_any", Unit: schema.Unit{Base: "F/s"}, Timestep: 60})
+
+	srv, targets := newRecordingServer(t, func(w http.ResponseWriter, r *http.Request, record func(string)) {
+		if r.URL.Path != "/render" || r.URL.Query().Get("format") != "json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		record(r.URL.Query().Get("target"))
+		from := r.URL.Query().Get("from")
+		fmt.Fprintf(w, `[
+			{"target": "n1", "datapoints": [[1, %[1]s], [3, %[1]s], [null, %[1]s]]},
+			{"target": "n2", "datapoints": [[null, %[1]s], [4, %[1]s]]}
+		]`, from)
+	})
+
+	var gdb GraphiteDataRepository
+	if err := gdb.Init(json.RawMessage(fmt.Sprintf(`{
+		"url": "%s",
+		"hostname-node": 2,
+		"path-templates": {"flops_any": "{{.Cluster}}.nodes.{{.Nodes}}.flops"}
+	}`, srv.URL))); err != nil {
+		t.Fatal(err)
+	}
+
+	return &gdb, targets
+}
+
+func TestGraphiteLoadData(t *testing.T) {
+	gdb, targets := setupGraphite(t)
+
+	job := &schema.Job{}
+	job.Cluster = "testcluster"
+	job.StartTime = time.Unix(1670000000, 0)
+	job.Duration = 120
+	job.Resources = []*schema.Resource{{Hostname: "n1"}, {Hostname: "n2"}}
+
+	data, err := gdb.LoadData(job, []string{"flops_any"}, []schema.MetricScope{schema.MetricScopeNode}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*targets)[0] != "aliasByNode(testcluster.nodes.{n1,n2}.flops, 2)" {
+		t.Fatalf("unexpected target %s", (*targets)[0])
+	}
+
+	jm := data["flops_any"][schema.MetricScopeNode]
+	if jm == nil || jm.Timestep != 60 || len(jm.Series) != 2 {
+		t.Fatal("unexpected job data")
+	}
+	if s := jm.Series[0]; s.Hostname != "n1" || len(s.Data) != 3 || s.Data[0] != 2 || !s.Data[1].IsNaN() {
+		t.Fatalf("unexpected series %+v", s)
+	}
+
+	stats, err := gdb.LoadStats(job, []string{"flops_any"}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := stats["flops_any"]["n2"]; s.Avg != 4 || s.Min != 4 || s.Max != 4 {
+		t.Fatalf("unexpected statistics %+v", s)
+	}
+
+	if _, err := gdb.LoadData(job, []string{"mem_bw"}, nil, context.Background()); err == nil {
+		t.Fatal("expected error for metric without template")
+	}
+}
+
+func TestGraphiteLoadNodeData(t *testing.T) {
+	gdb, targets := setupGraphite(t)
+
+	from := time.Unix(1670000000, 0)
+	data, err := gdb.LoadNodeData("testcluster", []string{"flops_any"}, nil, nil,
+		from, from.Add(time.Hour), context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*targets)[0] != "aliasByNode(testcluster.nodes.*.flops, 2)" {
+		t.Fatalf("unexpected target %s", (*targets)[0])
+	}
+	if len(data) != 2 || len(data["n1"]["flops_any"]) != 1 || len(data["n1"]["flops_any"][0].Series[0].Data) != 61 {
+		t.Fatal("unexpected node data")
+	}
+}
@@ -0,0 +1,424 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/archive"
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+type InfluxDBv1DataRepositoryConfig struct {
+	Url      string `json:"url"`
+	Database string `json:"database"`
+	Username string `json:"username,omitempty"`
+	SkipTls  bool   `json:"skiptls"`
+}
+
+type InfluxDBv1DataRepository struct {
+	client   http.Client
+	queryUrl string
+	database string
+	username string
+	password string
+}
+
+// Response of the /query endpoint, one result per statement
+type influxQLResponse struct {
+	Results []struct {
+		StatementId int              `json:"statement_id"`
+		Series      []influxQLSeries `json:"series"`
+		Error       string           `json:"error"`
+	} `json:"results"`
+	Error string `json:"error"`
+}
+
+type influxQLSeries struct {
+	Name    string            `json:"name"`
+	Tags    map[string]string `json:"tags"`
+	Columns []string          `json:"columns"`
+	Values  [][]*json.Number  `json:"values"`
+}
+
+func (idb *InfluxDBv1DataRepository) Init(rawConfig json.RawMessage) error {
+	var config InfluxDBv1DataRepositoryConfig
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		log.Warn("Error while unmarshaling raw json config")
+		return err
+	}
+	if config.Database == "" {
+		return errors.New("METRICDATA/INFLUXV1 > no database configured")
+	}
+
+	// support basic authentication
+	if config.Username != "" {
+		idb.password = os.Getenv("INFLUXDB_PASSWORD")
+		if idb.password == "" {
+			return errors.New("METRICDATA/INFLUXV1 > InfluxDB username provided, but INFLUXDB_PASSWORD not set")
+		}
+	}
+
+	idb.client = http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: config.SkipTls},
+		},
+	}
+	idb.queryUrl = strings.TrimSuffix(config.Url, "/") + "/query"
+	idb.database = config.Database
+	idb.username = config.Username
+
+	return nil
+}
+
+// Quote an identifier (measurement or tag key) for InfluxQL.
+func influxQLIdent(s string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`) + `"`
+}
+
+// Quote a string literal (tag value) for InfluxQL.
+func influxQLString(s string) string {
+	return `'` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `'`, `\'`) + `'`
+}
+
+// Build the WHERE clause selecting the time range and hosts (all hosts if
+// nil).
+func influxQLWhere(hosts []string, from, to time.Time) string {
+	cond := fmt.Sprintf("time >= %ds AND time <= %ds", from.Unix(), to.Unix())
+	if hosts == nil {
+		return cond
+	}
+
+	hostsConds := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		hostsConds = append(hostsConds, fmt.Sprintf(`"hostname" = %s`, influxQLString(h)))
+	}
+	return fmt.Sprintf("(%s) AND %s", strings.Join(hostsConds, " OR "), cond)
+}
+
+// Statement averaging metric into windows of timestep seconds per host. The
+// windows are aligned to from.
+func (idb *InfluxDBv1DataRepository) seriesStatement(
+	metric string,
+	timestep int,
+	hosts []string,
+	from, to time.Time) string {
+
+	return fmt.Sprintf(`SELECT mean("value") FROM %s WHERE %s GROUP BY time(%ds, %ds), "hostname" fill(null)`,
+		influxQLIdent(metric), influxQLWhere(hosts, from, to), timestep, from.Unix()%int64(timestep))
+}
+
+func (idb *InfluxDBv1DataRepository) statsStatement(
+	metric string,
+	hosts []string,
+	from, to time.Time) string {
+
+	return fmt.Sprintf(`SELECT mean("value"), min("value"), max("value") FROM %s WHERE %s GROUP BY "hostname"`,
+		influxQLIdent(metric), influxQLWhere(hosts, from, to))
+}
+
+// Run all statements in a single request. The result contains the series of
+// every statement in the same order.
+func (idb *InfluxDBv1DataRepository) query(
+	ctx context.Context,
+	statements []string) ([][]influxQLSeries, error) {
+
+	params := url.Values{}
+	params.Set("db", idb.database)
+	params.Set("q", strings.Join(statements, "; "))
+	params.Set("epoch", "s")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, idb.queryUrl, strings.NewReader(params.Encode()))
+	if err != nil {
+		log.Warn("Error while building request")
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idb.username != "" {
+		req.SetBasicAuth(idb.username, idb.password)
+	}
+
+	res, err := idb.client.Do(req)
+	if err != nil {
+		log.Error("Error while performing request")
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var resBody influxQLResponse
+	if err := json.NewDecoder(bufio.NewReader(res.Body)).Decode(&resBody); err != nil && res.StatusCode == http.StatusOK {
+		log.Warn("Error while decoding result body")
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("'%s': HTTP Status: %s %s", idb.queryUrl, res.Status, resBody.Error)
+	}
+	if resBody.Error != "" {
+		return nil, fmt.Errorf("METRICDATA/INFLUXV1 > %s", resBody.Error)
+	}
+
+	result := make([][]influxQLSeries, len(statements))
+	for _, r := range resBody.Results {
+		if r.Error != "" {
+			return nil, fmt.Errorf("METRICDATA/INFLUXV1 > statement %d: %s", r.StatementId, r.Error)
+		}
+		if r.StatementId < 0 || r.StatementId >= len(result) {
+			return nil, fmt.Errorf("METRICDATA/INFLUXV1 > unexpected statement id %d", r.StatementId)
+		}
+		result[r.StatementId] = r.Series
+	}
+
+	return result, nil
+}
+
+func influxQLFloat(n *json.Number) schema.Float {
+	if n == nil {
+		return schema.NaN
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return schema.NaN
+	}
+	return schema.Float(f)
+}
+
+// Convert the series of a seriesStatement to one series per host with
+// steps+1 values starting at from.
+func influxQLToSeries(
+	result []influxQLSeries,
+	from time.Time,
+	step int64,
+	steps int64) []schema.Series {
+
+	series := make([]schema.Series, 0, len(result))
+	for _, s := range result {
+		data := make([]schema.Float, steps+1)
+		for i := range data {
+			data[i] = schema.NaN
+		}
+		for _, v := range s.Values {
+			if len(v) < 2 || v[0] == nil {
+				continue
+			}
+			ts, err := v[0].Int64()
+			if err != nil {
+				continue
+			}
+			idx := (ts - from.Unix()) / step
+			if idx >= 0 && idx <= steps {
+				data[idx] = influxQLFloat(v[1])
+			}
+		}
+
+		min, max, mean := MinMaxMean(data)
+		series = append(series, schema.Series{
+			Hostname: s.Tags["hostname"],
+			Data:     data,
+			Statistics: schema.MetricStatistics{
+				Avg: mean,
+				Min: min,
+				Max: max,
+			},
+		})
+	}
+	// sort by hostname to get uniform coloring
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].Hostname < series[j].Hostname
+	})
+
+	return series
+}
+
+// Load the node scope series of metrics for hosts (all hosts if nil).
+func (idb *InfluxDBv1DataRepository) loadSeries(
+	ctx context.Context,
+	cluster string,
+	metrics, hosts []string,
+	from, to time.Time) (map[string]*schema.JobMetric, error) {
+
+	configs := make([]*schema.MetricConfig, len(metrics))
+	statements := make([]string, len(metrics))
+	for i, metric := range metrics {
+		mc := archive.GetMetricConfig(cluster, metric)
+		if mc == nil {
+			log.Warnf("Metric %s for cluster %s not configured", metric, cluster)
+			return nil, errors.New("InfluxDB config error")
+		}
+		configs[i] = mc
+		statements[i] = idb.seriesStatement(metric, mc.Timestep, hosts, from, to)
+	}
+	if len(statements) == 0 {
+		return map[string]*schema.JobMetric{}, nil
+	}
+
+	result, err := idb.query(ctx, statements)
+	if err != nil {
+		log.Errorf("InfluxDB query error: %v\nQuery: %s", err, strings.Join(statements, "; "))
+		return nil, errors.New("InfluxDB query error")
+	}
+
+	data := make(map[string]*schema.JobMetric, len(metrics))
+	for i, metric := range metrics {
+		step := int64(configs[i].Timestep)
+		steps := int64(to.Sub(from).Seconds()) / step
+		series := influxQLToSeries(result[i], from, step, steps)
+
+		// only add metric if at least one host returned data
+		if len(series) == 0 {
+			continue
+		}
+		data[metric] = &schema.JobMetric{
+			Unit:     configs[i].Unit,
+			Timestep: configs[i].Timestep,
+			Series:   series,
+		}
+	}
+
+	return data, nil
+}
+
+func (idb *InfluxDBv1DataRepository) LoadData(
+	job *schema.Job,
+	metrics []string,
+	scopes []schema.MetricScope,
+	ctx context.Context) (schema.JobData, error) {
+
+	for _, scope := range scopes {
+		if scope != schema.MetricScopeNode {
+			logOnce.Do(func() {
+				log.Infof("Scope '%s' requested, but not yet supported: Will return 'node' scope only.", scope)
+			})
+		}
+	}
+
+	hosts := make([]string, len(job.Resources))
+	for i, resource := range job.Resources {
+		hosts[i] = resource.Hostname
+	}
+	from := job.StartTime
+	to := job.StartTime.Add(time.Duration(job.Duration) * time.Second)
+
+	data, err := idb.loadSeries(ctx, job.Cluster, metrics, hosts, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	jobData := make(schema.JobData, len(data))
+	for metric, jm := range data {
+		jobData[metric] = map[schema.MetricScope]*schema.JobMetric{
+			schema.MetricScopeNode: jm,
+		}
+	}
+
+	return jobData, nil
+}
+
+func (idb *InfluxDBv1DataRepository) LoadStats(
+	job *schema.Job,
+	metrics []string,
+	ctx context.Context) (map[string]map[string]schema.MetricStatistics, error) {
+
+	stats := map[string]map[string]schema.MetricStatistics{}
+	if len(metrics) == 0 {
+		return stats, nil
+	}
+
+	hosts := make([]string, len(job.Resources))
+	for i, resource := range job.Resources {
+		hosts[i] = resource.Hostname
+	}
+	from := job.StartTime
+	to := job.StartTime.Add(time.Duration(job.Duration) * time.Second)
+
+	statements := make([]string, len(metrics))
+	for i, metric := range metrics {
+		statements[i] = idb.statsStatement(metric, hosts, from, to)
+	}
+
+	result, err := idb.query(ctx, statements)
+	if err != nil {
+		log.Errorf("InfluxDB query error: %v\nQuery: %s", err, strings.Join(statements, "; "))
+		return nil, errors.New("InfluxDB query error")
+	}
+
+	for i, metric := range metrics {
+		nodes := map[string]schema.MetricStatistics{}
+		for _, s := range result[i] {
+			// columns: time, mean, min, max
+			if len(s.Values) == 0 || len(s.Values[0]) < 4 {
+				continue
+			}
+			v := s.Values[0]
+			nodes[s.Tags["hostname"]] = schema.MetricStatistics{
+				Avg: float64(influxQLFloat(v[1])),
+				Min: float64(influxQLFloat(v[2])),
+				Max: float64(influxQLFloat(v[3])),
+			}
+		}
+		stats[metric] = nodes
+	}
+
+	return stats, nil
+}
+
+func (idb *InfluxDBv1DataRepository) LoadStatsBatch(
+	jobs []*schema.Job,
+	metrics []string,
+	ctx context.Context) ([]map[string]map[string]schema.MetricStatistics, error) {
+
+	return loadStatsPerJob(idb, jobs, metrics, ctx)
+}
+
+func (idb *InfluxDBv1DataRepository) LoadNodeData(
+	cluster string,
+	metrics, nodes []string,
+	scopes []schema.MetricScope,
+	from, to time.Time,
+	ctx context.Context) (map[string]map[string][]*schema.JobMetric, error) {
+
+	for _, scope := range scopes {
+		if scope != schema.MetricScopeNode {
+			logOnce.Do(func() {
+				log.Infof("Note: Scope '%s' requested, but not yet supported: Will return 'node' scope only.", scope)
+			})
+		}
+	}
+
+	metricData, err := idb.loadSeries(ctx, cluster, metrics, nodes, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]map[string][]*schema.JobMetric)
+	for metric, jm := range metricData {
+		for _, s := range jm.Series {
+			hostdata, ok := data[s.Hostname]
+			if !ok {
+				hostdata = make(map[string][]*schema.JobMetric)
+				data[s.Hostname] = hostdata
+			}
+			hostdata[metric] = append(hostdata[metric], &schema.JobMetric{
+				Unit:     jm.Unit,
+				Timestep: jm.Timestep,
+				Series:   []schema.Series{s},
+			})
+		}
+	}
+
+	return data, nil
+}
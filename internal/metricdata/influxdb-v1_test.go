@@ -0,0 +1,118 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+func setupInfluxDBv1(t *testing.T) (*InfluxDBv1DataRepository, *[]string) {
+	setupTestCluster(t,
+		&schema.MetricConfig{Name: "flops_any", Unit: schema.Unit{Base: "F/s"}, Timestep: 60},
+		&schema.MetricConfig{Name: "mem_bw", Unit: schema.Unit{Base: "B/s"}, Timestep: 60})
+
+	srv, queries := newRecordingServer(t, func(w http.ResponseWriter, r *http.Request, record func(string)) {
+		if r.URL.Path != "/query" || r.FormValue("db") != "cc" || r.FormValue("epoch") != "s" {
+			http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
+			return
+		}
+
+		q := r.FormValue("q")
+		record(q)
+		if strings.Contains(q, "min(") {
+			fmt.Fprint(w, `{"results": [{"statement_id": 0, "series": [
+				{"name": "flops_any", "tags": {"hostname": "n1"}, "columns": ["time", "mean", "min", "max"], "values": [[0, 2, 1, 3]]}
+			]}]}`)
+			return
+		}
+		// only the first statement returns data
+		results := make([]string, 0)
+		for i := range strings.Split(q, "; ") {
+			results = append(results, fmt.Sprintf(`{"statement_id": %d}`, i))
+		}
+		results[0] = `{"statement_id": 0, "series": [
+				{"name": "flops_any", "tags": {"hostname": "n2"}, "columns": ["time", "mean"], "values": [[1670000000, 4], [1670000060, null]]},
+				{"name": "flops_any", "tags": {"hostname": "n1"}, "columns": ["time", "mean"], "values": [[1670000000, 1], [1670000120, 3]]}
+			]}`
+		fmt.Fprintf(w, `{"results": [%s]}`, strings.Join(results, ","))
+	})
+
+	var idb InfluxDBv1DataRepository
+	if err := idb.Init(json.RawMessage(fmt.Sprintf(`{"url": "%s", "database": "cc"}`, srv.URL))); err != nil {
+		t.Fatal(err)
+	}
+
+	return &idb, queries
+}
+
+func TestInfluxDBv1LoadData(t *testing.T) {
+	idb, queries := setupInfluxDBv1(t)
+
+	job := &schema.Job{}
+	job.Cluster = "testcluster"
+	job.StartTime = time.Unix(1670000000, 0)
+	job.Duration = 120
+	job.Resources = []*schema.Resource{{Hostname: "n1"}, {Hostname: "n'2"}}
+
+	data, err := idb.LoadData(job, []string{"flops_any", "mem_bw"}, []schema.MetricScope{schema.MetricScopeNode}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `SELECT mean("value") FROM "flops_any" WHERE ("hostname" = 'n1' OR "hostname" = 'n\'2') ` +
+		`AND time >= 1670000000s AND time <= 1670000120s GROUP BY time(60s, 20s), "hostname" fill(null); ` +
+		`SELECT mean("value") FROM "mem_bw"`
+	if !strings.HasPrefix((*queries)[0], expected) {
+		t.Fatalf("unexpected query %s", (*queries)[0])
+	}
+
+	if _, ok := data["mem_bw"]; ok || len(data) != 1 {
+		t.Fatal("expected no data for mem_bw")
+	}
+	jm := data["flops_any"][schema.MetricScopeNode]
+	if jm == nil || len(jm.Series) != 2 || jm.Series[0].Hostname != "n1" {
+		t.Fatal("unexpected job data")
+	}
+	if s := jm.Series[0]; len(s.Data) != 3 || s.Data[0] != 1 || !s.Data[1].IsNaN() || s.Data[2] != 3 || s.Statistics.Avg != 2 {
+		t.Fatalf("unexpected series %+v", s)
+	}
+
+	stats, err := idb.LoadStats(job, []string{"flops_any"}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := stats["flops_any"]["n1"]; s.Avg != 2 || s.Min != 1 || s.Max != 3 {
+		t.Fatalf("unexpected statistics %+v", s)
+	}
+}
+
+func TestInfluxDBv1LoadNodeData(t *testing.T) {
+	idb, queries := setupInfluxDBv1(t)
+
+	from := time.Unix(1670000000, 0)
+	data, err := idb.LoadNodeData("testcluster", []string{"flops_any"}, nil, nil,
+		from, from.Add(time.Hour), context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains((*queries)[0], "hostname\" =") {
+		t.Fatalf("unexpected host condition in %s", (*queries)[0])
+	}
+	if len(data) != 2 || len(data["n2"]["flops_any"]) != 1 || len(data["n2"]["flops_any"][0].Series[0].Data) != 61 {
+		t.Fatal("unexpected node data")
+	}
+
+	if _, err := idb.LoadNodeData("testcluster", []string{"unknown"}, nil, nil,
+		from, from.Add(time.Hour), context.Background()); err == nil {
+		t.Fatal("expected error for unknown metric")
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+// Metric data held in memory by a repository, see ReplayDataRepository and
+// EmbeddedMetricStore.
+type localSource interface {
+	// Scopes at which metric is available, nil for unknown metrics.
+	scopes(metric string) []schema.MetricScope
+
+	// Series of metric at scope for host in the time range [from, to], only
+	// the ones with an id in ids if ids is not nil.
+	series(metric string, scope schema.MetricScope, host string, ids []string, from, to time.Time) []schema.Series
+
+	timestep(metric string, scope schema.MetricScope) int
+	unit(metric string) schema.Unit
+}
+
+// Returns the available scope used for the requested scope: The requested
+// scope itself or the finest available scope coarser than it. If only finer
+// scopes are available, the coarsest of them is returned for aggregation.
+func selectScope(available []schema.MetricScope, requested schema.MetricScope) (schema.MetricScope, bool) {
+	var coarser, finer schema.MetricScope = schema.MetricScopeInvalid, schema.MetricScopeInvalid
+	for _, scope := range available {
+		switch {
+		case scope == requested:
+			return scope, true
+		case requested.LT(scope):
+			if coarser == schema.MetricScopeInvalid || scope.LT(coarser) {
+				coarser = scope
+			}
+		default:
+			finer = finer.Max(scope)
+		}
+	}
+
+	if coarser != schema.MetricScopeInvalid {
+		return coarser, true
+	}
+	return finer, finer != schema.MetricScopeInvalid
+}
+
+// Load metrics for the resources from src. Metrics only available at finer
+// scopes are aggregated if the node scope is requested. Errors are returned
+// as messages for the repository to wrap.
+func loadLocal(
+	src localSource,
+	resources []*schema.Resource,
+	metrics []string,
+	scopes []schema.MetricScope,
+	from, to time.Time) (schema.JobData, []string) {
+
+	jobData := make(schema.JobData)
+	var errs []string
+	for _, metric := range metrics {
+		available := src.scopes(metric)
+		if available == nil {
+			errs = append(errs, fmt.Sprintf("no data for metric '%s'", metric))
+			continue
+		}
+
+		aggregate := false
+		for _, requested := range scopes {
+			scope, ok := selectScope(available, requested)
+			if !ok {
+				continue
+			}
+			if scope.LT(requested) {
+				if requested != schema.MetricScopeNode {
+					continue
+				}
+				aggregate = true
+			}
+			if _, ok := jobData[metric][scope]; ok {
+				continue
+			}
+
+			jm := &schema.JobMetric{
+				Unit:     src.unit(metric),
+				Timestep: src.timestep(metric, scope),
+				Series:   make([]schema.Series, 0, len(resources)),
+			}
+			for _, resource := range resources {
+				var ids []string
+				switch {
+				case scope == schema.MetricScopeHWThread && resource.HWThreads != nil:
+					ids = intToStringSlice(resource.HWThreads)
+				case scope == schema.MetricScopeAccelerator && resource.Accelerators != nil:
+					ids = resource.Accelerators
+				}
+
+				jm.Series = append(jm.Series, src.series(metric, scope, resource.Hostname, ids, from, to)...)
+			}
+			if len(jm.Series) == 0 {
+				continue
+			}
+
+			if _, ok := jobData[metric]; !ok {
+				jobData[metric] = make(map[schema.MetricScope]*schema.JobMetric)
+			}
+			jobData[metric][scope] = jm
+		}
+
+		if aggregate {
+			jobData.AddNodeScope(metric)
+			for scope := range jobData[metric] {
+				if !contains(scopes, scope) {
+					delete(jobData[metric], scope)
+				}
+			}
+		}
+	}
+
+	return jobData, errs
+}
+
+// Node scope statistics per metric and host.
+func localStats(jobData schema.JobData) map[string]map[string]schema.MetricStatistics {
+	stats := make(map[string]map[string]schema.MetricStatistics, len(jobData))
+	for metric, scopes := range jobData {
+		jm, ok := scopes[schema.MetricScopeNode]
+		if !ok {
+			continue
+		}
+
+		stats[metric] = make(map[string]schema.MetricStatistics, len(jm.Series))
+		for _, series := range jm.Series {
+			stats[metric][series.Hostname] = series.Statistics
+		}
+	}
+	return stats
+}
+
+// Split jobData into one JobMetric per series for LoadNodeData.
+func localNodeData(jobData schema.JobData) map[string]map[string][]*schema.JobMetric {
+	data := make(map[string]map[string][]*schema.JobMetric)
+	for metric, scopes := range jobData {
+		for _, jm := range scopes {
+			for _, series := range jm.Series {
+				hostdata, ok := data[series.Hostname]
+				if !ok {
+					hostdata = make(map[string][]*schema.JobMetric)
+					data[series.Hostname] = hostdata
+				}
+
+				hostdata[metric] = append(hostdata[metric], &schema.JobMetric{
+					Unit:     jm.Unit,
+					Timestep: jm.Timestep,
+					Series:   []schema.Series{series},
+				})
+			}
+		}
+	}
+	return data
+}
+
+// Series with statistics for data.
+func newSeries(host string, id *string, data []schema.Float) schema.Series {
+	min, max, avg := MinMaxMean(data)
+	if math.IsNaN(avg) {
+		min, max = avg, avg
+	}
+	return schema.Series{
+		Hostname:   host,
+		Id:         id,
+		Statistics: schema.MetricStatistics{Avg: avg, Min: min, Max: max},
+		Data:       data,
+	}
+}
+
+// A sample in InfluxDB line protocol as sent by cc-metric-collector.
+type linePoint struct {
+	metric, cluster, host, id string
+	scope                     schema.MetricScope
+	value                     float64
+	time                      int64
+}
+
+// Decodes a line using the tags cluster, hostname, type and type-id and the
+// field value, e.g.:
+//
+//	flops_any,cluster=fritz,hostname=f0101,type=hwthread,type-id=0 value=1.5 1700000000
+//
+// The timestamp may be in seconds, milli-, micro- or nanoseconds and defaults
+// to now, negative timestamps are invalid. The scope defaults to node.
+func decodeLine(line string) (linePoint, bool) {
+	parts := strings.Fields(line)
+	if len(parts) != 2 && len(parts) != 3 {
+		return linePoint{}, false
+	}
+
+	tags := strings.Split(parts[0], ",")
+	p := linePoint{metric: tags[0], scope: schema.MetricScopeNode}
+	for _, tag := range tags[1:] {
+		k, v, _ := strings.Cut(tag, "=")
+		switch k {
+		case "cluster":
+			p.cluster = v
+		case "hostname":
+			p.host = v
+		case "type":
+			p.scope = schema.MetricScope(v)
+		case "type-id":
+			p.id = v
+		}
+	}
+
+	ok := false
+	for _, field := range strings.Split(parts[1], ",") {
+		if k, v, _ := strings.Cut(field, "="); k == "value" {
+			x, err := strconv.ParseFloat(strings.TrimSuffix(v, "i"), 64)
+			p.value, ok = x, err == nil
+		}
+	}
+
+	p.time = time.Now().Unix()
+	if len(parts) == 3 {
+		t, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil || t < 0 {
+			return p, false
+		}
+		for t > 1e11 {
+			t /= 1000
+		}
+		p.time = t
+	}
+
+	return p, ok && p.metric != "" && p.host != "" && p.scope.Valid()
+}
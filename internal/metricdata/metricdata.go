@@ -5,13 +5,18 @@
 package metricdata
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
+	"os"
+	"sort"
 	"time"
 
 	"github.com/ClusterCockpit/cc-backend/internal/config"
+	"github.com/ClusterCockpit/cc-backend/internal/util"
 	"github.com/ClusterCockpit/cc-backend/pkg/archive"
 	"github.com/ClusterCockpit/cc-backend/pkg/log"
 	"github.com/ClusterCockpit/cc-backend/pkg/lrucache"
@@ -31,6 +36,35 @@ type MetricDataRepository interface {
 
 	// Return a map of hosts to a map of metrics at the requested scopes for that node.
 	LoadNodeData(cluster string, metrics, nodes []string, scopes []schema.MetricScope, from, to time.Time, ctx context.Context) (map[string]map[string][]*schema.JobMetric, error)
+
+	// Return the statistics of LoadStats for each of the jobs (all of the
+	// cluster of the repository) in the order of jobs. Repositories without
+	// a way to query many jobs at once call LoadStats for each job.
+	LoadStatsBatch(jobs []*schema.Job, metrics []string, ctx context.Context) ([]map[string]map[string]schema.MetricStatistics, error)
+}
+
+// Implemented by repositories whose LoadStatsBatch queries the statistics of
+// many jobs at once instead of calling LoadStats for each job.
+type nativeStatsBatchLoader interface {
+	nativeStatsBatch()
+}
+
+// Load the statistics of jobs by calling LoadStats of repo for each job, the
+// LoadStatsBatch of repositories without a batch query.
+func loadStatsPerJob(
+	repo MetricDataRepository,
+	jobs []*schema.Job,
+	metrics []string,
+	ctx context.Context) ([]map[string]map[string]schema.MetricStatistics, error) {
+
+	stats := make([]map[string]map[string]schema.MetricStatistics, len(jobs))
+	for i, job := range jobs {
+		var err error
+		if stats[i], err = repo.LoadStats(job, metrics, ctx); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
 }
 
 var metricDataRepos map[string]MetricDataRepository = map[string]MetricDataRepository{}
@@ -39,32 +73,30 @@ var useArchive bool
 
 func Init(disableArchive bool) error {
 	useArchive = !disableArchive
+	guardedReposMu.Lock()
+	guardedRepos = nil
+	guardedReposMu.Unlock()
+	embeddedStoresMu.Lock()
+	embeddedStores = map[string]*EmbeddedMetricStore{}
+	embeddedStoresMu.Unlock()
+	derivedMetrics = map[string]map[string]*derivedMetric{}
+
 	for _, cluster := range config.Keys.Clusters {
-		if cluster.MetricDataRepository != nil {
-			var kind struct {
-				Kind string `json:"kind"`
-			}
-			if err := json.Unmarshal(cluster.MetricDataRepository, &kind); err != nil {
-				log.Warn("Error while unmarshaling raw json MetricDataRepository")
-				return err
-			}
+		if err := initDerivedMetrics(cluster.Name, cluster.DerivedMetrics); err != nil {
+			log.Errorf("Error initializing derived metrics for cluster %v", cluster.Name)
+			return err
+		}
 
+		if cluster.MetricDataRepository != nil {
 			var mdr MetricDataRepository
-			switch kind.Kind {
-			case "cc-metric-store":
-				mdr = &CCMetricStore{}
-			case "influxdb":
-				mdr = &InfluxDBv2DataRepository{}
-			case "prometheus":
-				mdr = &PrometheusDataRepository{}
-			case "test":
-				mdr = &TestMetricDataRepository{}
-			default:
-				return fmt.Errorf("METRICDATA/METRICDATA > Unknown MetricDataRepository %v for cluster %v", kind.Kind, cluster.Name)
+			var err error
+			if raw := bytes.TrimSpace(cluster.MetricDataRepository); len(raw) > 0 && raw[0] == '[' {
+				mdr, err = newChainedDataRepository(cluster.Name, raw)
+			} else {
+				mdr, _, err = newMetricDataRepository(cluster.Name, raw)
 			}
-
-			if err := mdr.Init(cluster.MetricDataRepository); err != nil {
-				log.Errorf("Error initializing MetricDataRepository %v for cluster %v", kind.Kind, cluster.Name)
+			if err != nil {
+				log.Errorf("Error initializing MetricDataRepository for cluster %v", cluster.Name)
 				return err
 			}
 			metricDataRepos[cluster.Name] = mdr
@@ -73,21 +105,83 @@ func Init(disableArchive bool) error {
 	return nil
 }
 
+// Create and initialize the repository of the kind given in rawConfig for
+// cluster. The repository is wrapped by a circuit breaker.
+func newMetricDataRepository(cluster string, rawConfig json.RawMessage) (MetricDataRepository, string, error) {
+	var kind struct {
+		Kind string `json:"kind"`
+	}
+	if err := json.Unmarshal(rawConfig, &kind); err != nil {
+		log.Warn("Error while unmarshaling raw json MetricDataRepository")
+		return nil, "", err
+	}
+
+	var mdr MetricDataRepository
+	switch kind.Kind {
+	case "cc-metric-store":
+		mdr = &CCMetricStore{}
+	case "influxdb":
+		mdr = &InfluxDBv2DataRepository{}
+	case "influxdb-v1":
+		mdr = &InfluxDBv1DataRepository{}
+	case "graphite":
+		mdr = &GraphiteDataRepository{}
+	case "timescaledb":
+		mdr = &TimescaleDBDataRepository{}
+	case "prometheus":
+		mdr = &PrometheusDataRepository{}
+	case "embedded":
+		mdr = &EmbeddedMetricStore{cluster: cluster}
+	case "replay":
+		mdr = &ReplayDataRepository{cluster: cluster}
+	case "test":
+		mdr = &TestMetricDataRepository{}
+	default:
+		return nil, kind.Kind, fmt.Errorf("METRICDATA/METRICDATA > Unknown MetricDataRepository %v", kind.Kind)
+	}
+
+	if err := mdr.Init(rawConfig); err != nil {
+		log.Errorf("Error initializing MetricDataRepository %v", kind.Kind)
+		return nil, kind.Kind, err
+	}
+
+	guarded, err := newGuardedRepository(cluster, kind.Kind, mdr, rawConfig)
+	if err != nil {
+		return nil, kind.Kind, err
+	}
+	return guarded, kind.Kind, nil
+}
+
 var cache *lrucache.Cache = lrucache.New(128 * 1024 * 1024)
 
-// Fetches the metric data for a job.
+// Fetches the metric data for a job. If maxPoints is greater than zero, every
+// series is reduced to at most maxPoints samples. For archived jobs the
+// coarsest downsampled resolution still providing at least maxPoints samples
+// per series is loaded for that.
 func LoadData(job *schema.Job,
 	metrics []string,
 	scopes []schema.MetricScope,
+	maxPoints int,
 	ctx context.Context,
 ) (schema.JobData, error) {
-	data := cache.Get(cacheKey(job, metrics, scopes), func() (_ interface{}, ttl time.Duration, size int) {
+	fromRepo := job.State == schema.JobStateRunning ||
+		job.MonitoringStatus == schema.MonitoringStatusRunningOrArchiving ||
+		!useArchive
+
+	var factors []int
+	if !fromRepo {
+		factors = pickResolutions(job, metrics, maxPoints)
+	}
+	resolution := 1
+	if len(factors) > 0 {
+		resolution = factors[0]
+	}
+
+	data := cache.Get(cacheKey(job, metrics, scopes, resolution), func() (_ interface{}, ttl time.Duration, size int) {
 		var jd schema.JobData
 		var err error
 
-		if job.State == schema.JobStateRunning ||
-			job.MonitoringStatus == schema.MonitoringStatusRunningOrArchiving ||
-			!useArchive {
+		if fromRepo {
 
 			repo, ok := metricDataRepos[job.Cluster]
 
@@ -106,7 +200,11 @@ func LoadData(job *schema.Job,
 				}
 			}
 
-			jd, err = repo.LoadData(job, metrics, scopes, ctx)
+			repoMetrics, derived := expandDerivedMetrics(job.Cluster, metrics)
+			jd, err = repo.LoadData(job, repoMetrics, scopes, ctx)
+			if derived {
+				addDerivedJobData(job.Cluster, jd, metrics)
+			}
 			if err != nil {
 				if len(jd) != 0 {
 					log.Errorf("partial error: %s", err.Error())
@@ -118,12 +216,23 @@ func LoadData(job *schema.Job,
 			}
 			size = jd.Size()
 		} else {
-			jd, err = archive.GetHandle().LoadJobData(job)
+			jd, err = loadArchivedData(job, factors)
 			if err != nil {
 				log.Error("Error while loading job data from archive")
 				return err, 0, 0
 			}
 
+			// Derived metrics not archived with the job. The archive
+			// might cache jd, so it is copied first.
+			if len(derivedMetrics[job.Cluster]) != 0 {
+				res := make(schema.JobData, len(jd))
+				for metric, scopes := range jd {
+					res[metric] = scopes
+				}
+				jd = res
+				addDerivedJobData(job.Cluster, jd, nil)
+			}
+
 			// Avoid sending unrequested data to the client:
 			if metrics != nil || scopes != nil {
 				if metrics == nil {
@@ -172,7 +281,60 @@ func LoadData(job *schema.Job,
 		return nil, err
 	}
 
-	return data.(schema.JobData), nil
+	jd := data.(schema.JobData)
+	if maxPoints > 0 {
+		// The cached data is not modified
+		jd = jd.DownsampleTo(maxPoints)
+	}
+
+	return jd, nil
+}
+
+// Returns the stored downsampling factors (coarsest first) for which the
+// archived data of job still has at least maxPoints samples per series.
+func pickResolutions(job *schema.Job, metrics []string, maxPoints int) []int {
+	cluster := archive.GetCluster(job.Cluster)
+	if maxPoints <= 0 || cluster == nil {
+		return nil
+	}
+
+	timestep := 0
+	for _, mc := range cluster.MetricConfig {
+		if metrics != nil && !util.Contains(metrics, mc.Name) {
+			continue
+		}
+		if timestep == 0 || mc.Timestep < timestep {
+			timestep = mc.Timestep
+		}
+	}
+	if timestep <= 0 {
+		return nil
+	}
+
+	points := int(job.Duration) / timestep
+	factors := make([]int, 0, len(archive.Resolutions))
+	for i := len(archive.Resolutions) - 1; i >= 0; i-- {
+		if factor := archive.Resolutions[i]; points/factor >= maxPoints {
+			factors = append(factors, factor)
+		}
+	}
+	return factors
+}
+
+// Load the archived job data at the first of the downsampled resolutions
+// factors that is available, falling back to the full resolution.
+func loadArchivedData(job *schema.Job, factors []int) (schema.JobData, error) {
+	for _, factor := range factors {
+		jd, err := archive.GetHandle().LoadJobDataResolution(job, factor)
+		if err == nil {
+			return jd, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warnf("Error while loading resolution %dx of job %d: %s", factor, job.JobID, err.Error())
+		}
+	}
+
+	return archive.GetHandle().LoadJobData(job)
 }
 
 // Used for the jobsFootprint GraphQL-Query. TODO: Rename/Generalize.
@@ -182,38 +344,130 @@ func LoadAverages(
 	data [][]schema.Float,
 	ctx context.Context,
 ) error {
-	if job.State != schema.JobStateRunning && useArchive {
-		return archive.LoadAveragesFromArchive(job, metrics, data) // #166 change also here?
+	return LoadAveragesBatch([]*schema.Job{job}, metrics, data, ctx)
+}
+
+// Like LoadAverages for each of the jobs, the averages are appended in the
+// order of jobs. The statistics of the jobs not loaded from the archive are
+// requested in one batch per cluster, see LoadStatsBatch.
+func LoadAveragesBatch(
+	jobs []*schema.Job,
+	metrics []string,
+	data [][]schema.Float,
+	ctx context.Context,
+) error {
+	fromArchive := func(job *schema.Job) bool {
+		return job.State != schema.JobStateRunning && useArchive
 	}
 
-	repo, ok := metricDataRepos[job.Cluster]
-	if !ok {
-		return fmt.Errorf("METRICDATA/METRICDATA > no metric data repository configured for '%s'", job.Cluster)
+	clusters := make(map[string][]*schema.Job)
+	for _, job := range jobs {
+		if !fromArchive(job) {
+			clusters[job.Cluster] = append(clusters[job.Cluster], job)
+		}
 	}
 
-	stats, err := repo.LoadStats(job, metrics, ctx) // #166 how to handle stats for acc normalizazion?
-	if err != nil {
-		log.Errorf("Error while loading statistics for job %v (User %v, Project %v)", job.JobID, job.User, job.Project)
-		return err
+	stats := make(map[*schema.Job]map[string]map[string]schema.MetricStatistics, len(jobs))
+	for cluster, jobs := range clusters {
+		clusterStats, err := loadJobStatistics(cluster, jobs, metrics, ctx)
+		if err != nil {
+			return err
+		}
+		for i, job := range jobs {
+			stats[job] = clusterStats[i]
+		}
 	}
 
-	for i, m := range metrics {
-		nodes, ok := stats[m]
-		if !ok {
-			data[i] = append(data[i], schema.NaN)
+	for _, job := range jobs {
+		if fromArchive(job) {
+			if err := archive.LoadAveragesFromArchive(job, metrics, data); err != nil { // #166 change also here?
+				return err
+			}
 			continue
 		}
 
-		sum := 0.0
-		for _, node := range nodes {
-			sum += node.Avg
+		for i, m := range metrics {
+			nodes, ok := stats[job][m]
+			if !ok {
+				data[i] = append(data[i], schema.NaN)
+				continue
+			}
+
+			sum := 0.0
+			for _, node := range nodes {
+				sum += node.Avg
+			}
+			data[i] = append(data[i], schema.Float(sum))
 		}
-		data[i] = append(data[i], schema.Float(sum))
 	}
 
 	return nil
 }
 
+// Load the node statistics of metrics for jobs of cluster from its
+// repository, including derived metrics.
+func loadJobStatistics(
+	cluster string,
+	jobs []*schema.Job,
+	metrics []string,
+	ctx context.Context,
+) ([]map[string]map[string]schema.MetricStatistics, error) {
+	repo, ok := metricDataRepos[cluster]
+	if !ok {
+		return nil, fmt.Errorf("METRICDATA/METRICDATA > no metric data repository configured for '%s'", cluster)
+	}
+
+	native, derived := make([]string, 0, len(metrics)), make([]string, 0)
+	for _, m := range metrics {
+		if _, ok := derivedMetrics[cluster][m]; ok {
+			derived = append(derived, m)
+		} else {
+			native = append(native, m)
+		}
+	}
+
+	stats := make([]map[string]map[string]schema.MetricStatistics, len(jobs))
+	if len(native) != 0 {
+		var err error
+		stats, err = repo.LoadStatsBatch(jobs, native, ctx) // #166 how to handle stats for acc normalizazion?
+		if err != nil {
+			if len(jobs) == 1 {
+				log.Errorf("Error while loading statistics for job %v (User %v, Project %v)", jobs[0].JobID, jobs[0].User, jobs[0].Project)
+			} else {
+				log.Errorf("Error while loading statistics for %d jobs of cluster %s", len(jobs), cluster)
+			}
+			return nil, err
+		}
+	}
+	for i := range stats {
+		if stats[i] == nil {
+			stats[i] = make(map[string]map[string]schema.MetricStatistics)
+		}
+	}
+
+	// Statistics of derived metrics can not be computed from the statistics
+	// of their dependencies
+	if len(derived) != 0 {
+		for i, job := range jobs {
+			jd, err := LoadData(job, derived, []schema.MetricScope{schema.MetricScopeNode}, 0, ctx)
+			if err != nil {
+				log.Errorf("Error while loading derived metrics for job %v (User %v, Project %v)", job.JobID, job.User, job.Project)
+				return nil, err
+			}
+			for _, m := range derived {
+				if jm, ok := jd[m][schema.MetricScopeNode]; ok {
+					stats[i][m] = make(map[string]schema.MetricStatistics, len(jm.Series))
+					for _, series := range jm.Series {
+						stats[i][m][series.Hostname] = series.Statistics
+					}
+				}
+			}
+		}
+	}
+
+	return stats, nil
+}
+
 // Used for the node/system view. Returns a map of nodes to a map of metrics.
 func LoadNodeData(
 	cluster string,
@@ -233,7 +487,11 @@ func LoadNodeData(
 		}
 	}
 
-	data, err := repo.LoadNodeData(cluster, metrics, nodes, scopes, from, to, ctx)
+	repoMetrics, derived := expandDerivedMetrics(cluster, metrics)
+	data, err := repo.LoadNodeData(cluster, repoMetrics, nodes, scopes, from, to, ctx)
+	if derived {
+		addDerivedNodeData(cluster, data, metrics)
+	}
 	if err != nil {
 		if len(data) != 0 {
 			log.Warnf("partial error: %s", err.Error())
@@ -254,17 +512,19 @@ func cacheKey(
 	job *schema.Job,
 	metrics []string,
 	scopes []schema.MetricScope,
+	resolution int,
 ) string {
 	// Duration and StartTime do not need to be in the cache key as StartTime is less unique than
 	// job.ID and the TTL of the cache entry makes sure it does not stay there forever.
-	return fmt.Sprintf("%d(%s):[%v],[%v]",
-		job.ID, job.State, metrics, scopes)
+	return fmt.Sprintf("%d(%s):[%v],[%v]/%dx",
+		job.ID, job.State, metrics, scopes, resolution)
 }
 
 // For /monitoring/job/<job> and some other places, flops_any and mem_bw need
 // to be available at the scope 'node'. If a job has a lot of nodes,
-// statisticsSeries should be available so that a min/mean/max Graph can be
-// used instead of a lot of single lines.
+// statisticsSeries (including the configured percentiles) should be
+// available so that a min/mean/max Graph can be used instead of a lot of
+// single lines.
 func prepareJobData(
 	job *schema.Job,
 	jobData schema.JobData,
@@ -278,6 +538,7 @@ func prepareJobData(
 			}
 
 			jm.AddStatisticsSeries()
+			jm.AddPercentiles(config.Keys.StatisticsPercentiles)
 		}
 	}
 
@@ -296,22 +557,27 @@ func prepareJobData(
 
 // Writes a running job to the job-archive
 func ArchiveJob(job *schema.Job, ctx context.Context) (*schema.JobMeta, error) {
-	allMetrics := make([]string, 0)
-	metricConfigs := archive.GetCluster(job.Cluster).MetricConfig
-	for _, mc := range metricConfigs {
-		allMetrics = append(allMetrics, mc.Name)
+	// Metrics archived at the same scopes are loaded together
+	groups := make(map[string][]string)
+	groupScopes := make(map[string][]schema.MetricScope)
+	for metric, scopes := range archive.GetArchiveScopes(job) {
+		key := fmt.Sprint(scopes)
+		groups[key] = append(groups[key], metric)
+		groupScopes[key] = scopes
 	}
 
-	// TODO: Talk about this! What resolutions to store data at...
-	scopes := []schema.MetricScope{schema.MetricScopeNode}
-	if job.NumNodes <= 8 {
-		scopes = append(scopes, schema.MetricScopeCore)
-	}
+	jobData := make(schema.JobData)
+	for key, metrics := range groups {
+		sort.Strings(metrics)
+		data, err := LoadData(job, metrics, groupScopes[key], 0, ctx)
+		if err != nil {
+			log.Error("Error wile loading job data for archiving")
+			return nil, err
+		}
 
-	jobData, err := LoadData(job, allMetrics, scopes, ctx)
-	if err != nil {
-		log.Error("Error wile loading job data for archiving")
-		return nil, err
+		for metric, scopes := range data {
+			jobData[metric] = scopes
+		}
 	}
 
 	jobMeta := &schema.JobMeta{
@@ -324,8 +590,14 @@ func ArchiveJob(job *schema.Job, ctx context.Context) (*schema.JobMeta, error) {
 		avg, min, max := 0.0, math.MaxFloat32, -math.MaxFloat32
 		nodeData, ok := data["node"]
 		if !ok {
-			// TODO/FIXME: Calc average for non-node metrics as well!
-			continue
+			aggregation := ""
+			if mc := archive.GetMetricConfig(job.Cluster, metric); mc != nil {
+				aggregation = mc.Aggregation
+			}
+
+			if nodeData = aggregateNodeStatistics(data, aggregation); nodeData == nil {
+				continue
+			}
 		}
 
 		for _, series := range nodeData.Series {
@@ -352,5 +624,116 @@ func ArchiveJob(job *schema.Job, ctx context.Context) (*schema.JobMeta, error) {
 		return jobMeta, nil
 	}
 
-	return jobMeta, archive.GetHandle().ImportJob(jobMeta, &jobData)
+	if err := archive.GetHandle().ImportJob(jobMeta, &jobData); err != nil {
+		return jobMeta, err
+	}
+
+	storeResolutions(jobMeta, jobData)
+	return jobMeta, nil
+}
+
+// Compute per node statistics for a metric not available at node scope
+// from the finest scope available. The series of every host are combined
+// by summing up (aggregation "sum") or averaging the values of every
+// timestep. Only the statistics of the returned
+// metric are set.
+func aggregateNodeStatistics(
+	data map[schema.MetricScope]*schema.JobMetric,
+	aggregation string,
+) *schema.JobMetric {
+	var jm *schema.JobMetric
+	var finest schema.MetricScope = schema.MetricScopeNode
+	for scope, m := range data {
+		if jm == nil || scope.LT(finest) {
+			jm, finest = m, scope
+		}
+	}
+	if jm == nil {
+		return nil
+	}
+
+	hosts := make(map[string][]schema.Series)
+	for _, series := range jm.Series {
+		hosts[series.Hostname] = append(hosts[series.Hostname], series)
+	}
+
+	nodeJm := &schema.JobMetric{
+		Unit:     jm.Unit,
+		Timestep: jm.Timestep,
+		Series:   make([]schema.Series, 0, len(hosts)),
+	}
+	for hostname, series := range hosts {
+		n := 0
+		for _, s := range series {
+			if len(s.Data) > n {
+				n = len(s.Data)
+			}
+		}
+
+		sum, cnt := 0.0, 0
+		min, max := math.MaxFloat64, -math.MaxFloat64
+		for i := 0; i < n; i++ {
+			x, k := 0.0, 0
+			for _, s := range series {
+				if i < len(s.Data) && !s.Data[i].IsNaN() {
+					x += float64(s.Data[i])
+					k++
+				}
+			}
+			if k == 0 {
+				continue
+			}
+			if aggregation != "sum" {
+				x /= float64(k)
+			}
+
+			sum += x
+			cnt++
+			min = math.Min(min, x)
+			max = math.Max(max, x)
+		}
+		if cnt == 0 {
+			continue
+		}
+
+		nodeJm.Series = append(nodeJm.Series, schema.Series{
+			Hostname:   hostname,
+			Statistics: schema.MetricStatistics{Avg: sum / float64(cnt), Min: min, Max: max},
+		})
+	}
+
+	if len(nodeJm.Series) == 0 {
+		return nil
+	}
+	return nodeJm
+}
+
+// Downsampled copies of the job data are only stored if they keep at
+// least this many samples of the longest series.
+const minResolutionPoints int = 60
+
+// Store the downsampled resolutions of an archived job. Failures are only
+// logged as the full resolution data is already archived.
+func storeResolutions(jobMeta *schema.JobMeta, jobData schema.JobData) {
+	n := 0
+	for _, scopes := range jobData {
+		for _, jm := range scopes {
+			for _, series := range jm.Series {
+				if len(series.Data) > n {
+					n = len(series.Data)
+				}
+			}
+		}
+	}
+
+	for _, factor := range archive.Resolutions {
+		if n/factor < minResolutionPoints {
+			break
+		}
+
+		reduced := jobData.Downsample(factor)
+		if err := archive.GetHandle().StoreJobDataResolution(jobMeta, factor, &reduced); err != nil {
+			log.Errorf("Error while archiving resolution %dx of job %d: %s", factor, jobMeta.JobID, err.Error())
+		}
+	}
 }
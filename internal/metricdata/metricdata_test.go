@@ -0,0 +1,94 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/archive"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+// Replaces the clusters of the archive by a cluster "testcluster" with
+// metrics for the duration of the test.
+func setupTestCluster(t *testing.T, metrics ...*schema.MetricConfig) *schema.Cluster {
+	cluster := &schema.Cluster{Name: "testcluster", MetricConfig: metrics}
+	clusters := archive.Clusters
+	archive.Clusters = []*schema.Cluster{cluster}
+	t.Cleanup(func() { archive.Clusters = clusters })
+	return cluster
+}
+
+// Starts a server answering requests with handle, which passes a value
+// describing each valid request to record before responding.
+func newRecordingServer[T any](
+	t *testing.T,
+	handle func(w http.ResponseWriter, r *http.Request, record func(T))) (*httptest.Server, *[]T) {
+
+	recorded := make([]T, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handle(w, r, func(v T) { recorded = append(recorded, v) })
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &recorded
+}
+
+func TestAggregateNodeStatistics(t *testing.T) {
+	id0, id1 := "0", "1"
+	data := map[schema.MetricScope]*schema.JobMetric{
+		schema.MetricScopeAccelerator: {
+			Timestep: 60,
+			Series: []schema.Series{
+				{Hostname: "n1", Id: &id0, Data: []schema.Float{1, 2, 3}},
+				{Hostname: "n1", Id: &id1, Data: []schema.Float{3, schema.NaN, 5}},
+			},
+		},
+	}
+
+	jm := aggregateNodeStatistics(data, "sum")
+	if jm == nil || len(jm.Series) != 1 {
+		t.Fatal("expected one node series")
+	}
+	if stats := jm.Series[0].Statistics; stats.Min != 2 || stats.Max != 8 || stats.Avg != 14.0/3 {
+		t.Fatalf("unexpected sum statistics %+v", stats)
+	}
+
+	jm = aggregateNodeStatistics(data, "avg")
+	if stats := jm.Series[0].Statistics; stats.Min != 2 || stats.Max != 4 || stats.Avg != 8.0/3 {
+		t.Fatalf("unexpected avg statistics %+v", stats)
+	}
+
+	if aggregateNodeStatistics(map[schema.MetricScope]*schema.JobMetric{}, "sum") != nil {
+		t.Fatal("expected no statistics without data")
+	}
+}
+
+func TestLoadAveragesBatch(t *testing.T) {
+	repo := &fakeRepository{metrics: []string{"flops_any"}}
+	metricDataRepos["testcluster"] = repo
+	prevUseArchive := useArchive
+	useArchive = false
+	t.Cleanup(func() {
+		delete(metricDataRepos, "testcluster")
+		useArchive = prevUseArchive
+	})
+
+	jobs := []*schema.Job{{}, {}}
+	for _, job := range jobs {
+		job.Cluster = "testcluster"
+		job.State = schema.JobStateRunning
+	}
+
+	data := [][]schema.Float{{}, {}}
+	if err := LoadAveragesBatch(jobs, []string{"flops_any", "mem_bw"}, data, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.calls) != 2 || len(data[0]) != 2 || data[0][1] != 1 || len(data[1]) != 2 || !data[1][0].IsNaN() {
+		t.Fatalf("unexpected averages %v", data)
+	}
+}
@@ -15,11 +15,13 @@ import (
 	"os"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"text/template"
 	"time"
 
+	"github.com/ClusterCockpit/cc-backend/internal/util"
 	"github.com/ClusterCockpit/cc-backend/pkg/archive"
 	"github.com/ClusterCockpit/cc-backend/pkg/log"
 	"github.com/ClusterCockpit/cc-backend/pkg/schema"
@@ -347,30 +349,138 @@ func (pdb *PrometheusDataRepository) LoadData(
 	return jobData, nil
 }
 
-// TODO change implementation to precomputed/cached stats
 func (pdb *PrometheusDataRepository) LoadStats(
 	job *schema.Job,
 	metrics []string,
 	ctx context.Context) (map[string]map[string]schema.MetricStatistics, error) {
 
-	// map of metrics of nodes of stats
-	stats := map[string]map[string]schema.MetricStatistics{}
-
-	data, err := pdb.LoadData(job, metrics, []schema.MetricScope{schema.MetricScopeNode}, ctx)
+	stats, err := pdb.LoadStatsBatch([]*schema.Job{job}, metrics, ctx)
 	if err != nil {
 		log.Warn("Error while loading job for stats")
 		return nil, err
 	}
-	for metric, metricData := range data {
-		stats[metric] = make(map[string]schema.MetricStatistics)
-		for _, series := range metricData[schema.MetricScopeNode].Series {
-			stats[metric][series.Hostname] = series.Statistics
+	return stats[0], nil
+}
+
+// Number of jobs combined into one PromQL query by LoadStatsBatch
+const promStatsBatchSize = 32
+
+// Label of the index of the job in the queries of LoadStatsBatch
+const promJobLabel = "cc_job_index"
+
+// Computes the statistics in Prometheus using avg_over_time, min_over_time
+// and max_over_time instead of loading the series. The subqueries of up to
+// promStatsBatchSize jobs are combined into one instant query per
+// aggregation using "or", distinguished by a label with the index of the job.
+// The query is evaluated at the end of the last job, the subqueries of the
+// other jobs are shifted using offset.
+func (pdb *PrometheusDataRepository) LoadStatsBatch(
+	jobs []*schema.Job,
+	metrics []string,
+	ctx context.Context) ([]map[string]map[string]schema.MetricStatistics, error) {
+
+	// slice of jobs of map of metrics of nodes of stats
+	stats := make([]map[string]map[string]schema.MetricStatistics, len(jobs))
+	for i := range stats {
+		stats[i] = map[string]map[string]schema.MetricStatistics{}
+	}
+
+	for start := 0; start < len(jobs); start += promStatsBatchSize {
+		batch := jobs[start:util.Min(start+promStatsBatchSize, len(jobs))]
+		for _, metric := range metrics {
+			for _, aggregation := range []string{"avg", "min", "max"} {
+				query, evalTime, err := pdb.statsQuery(batch, metric, aggregation)
+				if err != nil {
+					return nil, err
+				}
+
+				result, warnings, err := pdb.queryClient.Query(ctx, query, evalTime)
+				if err != nil {
+					log.Errorf("Prometheus query error in LoadStatsBatch: %v\nQuery: %s", err, query)
+					return nil, errors.New("Prometheus query error")
+				}
+				if len(warnings) > 0 {
+					log.Warnf("Warnings: %v\n", warnings)
+				}
+
+				for _, sample := range result.(promm.Vector) {
+					i, err := strconv.Atoi(string(sample.Metric[promJobLabel]))
+					if err != nil || i < 0 || i >= len(batch) {
+						continue
+					}
+					jobStats := stats[start+i]
+					if _, ok := jobStats[metric]; !ok {
+						jobStats[metric] = make(map[string]schema.MetricStatistics)
+					}
+
+					hostname := strings.TrimSuffix(string(sample.Metric["exported_instance"]), pdb.suffix)
+					nodeStats := jobStats[metric][hostname]
+					switch aggregation {
+					case "avg":
+						nodeStats.Avg = float64(sample.Value)
+					case "min":
+						nodeStats.Min = float64(sample.Value)
+					case "max":
+						nodeStats.Max = float64(sample.Value)
+					}
+					jobStats[metric][hostname] = nodeStats
+				}
+			}
 		}
 	}
 
 	return stats, nil
 }
 
+func (pdb *PrometheusDataRepository) nativeStatsBatch() {}
+
+// Build the query for LoadStatsBatch of metric for jobs using the
+// aggregation (avg, min or max) over time, and the time to evaluate it at.
+func (pdb *PrometheusDataRepository) statsQuery(
+	jobs []*schema.Job,
+	metric string,
+	aggregation string) (string, time.Time, error) {
+
+	evalTime := time.Time{}
+	for _, job := range jobs {
+		if to := job.StartTime.Add(time.Duration(job.Duration) * time.Second); to.After(evalTime) {
+			evalTime = to
+		}
+	}
+
+	queries := make([]string, 0, len(jobs))
+	for i, job := range jobs {
+		metricConfig := archive.GetMetricConfig(job.Cluster, metric)
+		if metricConfig == nil {
+			log.Warnf("Error in LoadStatsBatch: Metric %s for cluster %s not configured", metric, job.Cluster)
+			return "", evalTime, errors.New("Prometheus config error")
+		}
+
+		nodes := make([]string, len(job.Resources))
+		for i, resource := range job.Resources {
+			nodes[i] = resource.Hostname
+		}
+		query, err := pdb.FormatQuery(metric, schema.MetricScopeNode, nodes, job.Cluster)
+		if err != nil {
+			log.Warn("Error while formatting prometheus query")
+			return "", evalTime, err
+		}
+
+		step := util.Max(metricConfig.Timestep, 1)
+		duration := util.Max(int(job.Duration), step)
+		to := job.StartTime.Add(time.Duration(job.Duration) * time.Second)
+		offset := ""
+		if d := int(evalTime.Sub(to).Seconds()); d > 0 {
+			offset = fmt.Sprintf(" offset %ds", d)
+		}
+
+		queries = append(queries, fmt.Sprintf(`label_replace(%s_over_time((%s)[%ds:%ds]%s), "%s", "%d", "", "")`,
+			aggregation, query, duration, step, offset, promJobLabel, i))
+	}
+
+	return strings.Join(queries, " or "), evalTime, nil
+}
+
 func (pdb *PrometheusDataRepository) LoadNodeData(
 	cluster string,
 	metrics, nodes []string,
@@ -0,0 +1,87 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+func setupPrometheus(t *testing.T) (*PrometheusDataRepository, *[]string) {
+	setupTestCluster(t, &schema.MetricConfig{Name: "flops_any", Unit: schema.Unit{Base: "F/s"}, Timestep: 60})
+
+	srv, queries := newRecordingServer(t, func(w http.ResponseWriter, r *http.Request, record func(string)) {
+		if r.URL.Path != "/api/v1/query" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query := r.FormValue("query")
+		record(query)
+
+		// The value is the index of the job plus 1, 2 or 3 for avg, min or max
+		value := map[string]int{"avg": 1, "min": 2, "max": 3}[query[len("label_replace("):strings.Index(query, "_over_time")]]
+		result := make([]string, 0)
+		for i := 0; strings.Contains(query, fmt.Sprintf(`"cc_job_index", "%d"`, i)); i++ {
+			result = append(result, fmt.Sprintf(`{"metric": {"exported_instance": "n%d", "cc_job_index": "%d"}, "value": [1700000000, "%d"]}`,
+				i, i, i+value))
+		}
+		fmt.Fprintf(w, `{"status": "success", "data": {"resultType": "vector", "result": [%s]}}`, strings.Join(result, ","))
+	})
+
+	pdb := &PrometheusDataRepository{}
+	config, _ := json.Marshal(map[string]interface{}{
+		"url":             srv.URL,
+		"query-templates": map[string]string{"flops_any": `flops_any{exported_instance=~"{{.Nodes}}"}`},
+	})
+	if err := pdb.Init(config); err != nil {
+		t.Fatal(err)
+	}
+	return pdb, queries
+}
+
+func TestPrometheusLoadStatsBatch(t *testing.T) {
+	pdb, queries := setupPrometheus(t)
+
+	jobs := make([]*schema.Job, 0, promStatsBatchSize+1)
+	for i := 0; i < promStatsBatchSize+1; i++ {
+		job := &schema.Job{}
+		job.Cluster = "testcluster"
+		job.StartTime = time.Unix(1700000000-int64(i)*60, 0)
+		job.Duration = 600
+		job.Resources = []*schema.Resource{{Hostname: fmt.Sprintf("n%d", i%promStatsBatchSize)}}
+		jobs = append(jobs, job)
+	}
+
+	stats, err := pdb.LoadStatsBatch(jobs, []string{"flops_any"}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*queries) != 6 {
+		t.Fatalf("expected 3 queries per batch, got %v", *queries)
+	}
+	if !strings.HasPrefix((*queries)[0], `label_replace(avg_over_time((flops_any{exported_instance=~"(n0)"})[600s:60s]), "cc_job_index", "0", "", "") or `) ||
+		!strings.Contains((*queries)[0], `(flops_any{exported_instance=~"(n1)"})[600s:60s] offset 60s)`) {
+		t.Fatalf("unexpected query %s", (*queries)[0])
+	}
+
+	if s := stats[1]["flops_any"]["n1"]; s.Avg != 2 || s.Min != 3 || s.Max != 4 {
+		t.Fatalf("unexpected statistics %+v", s)
+	}
+	if s := stats[promStatsBatchSize]["flops_any"]["n0"]; s.Avg != 1 || s.Min != 2 || s.Max != 3 {
+		t.Fatalf("unexpected statistics of last batch %+v", s)
+	}
+
+	single, err := pdb.LoadStats(jobs[2], []string{"flops_any"}, context.Background())
+	if err != nil || single["flops_any"]["n0"].Avg != 1 {
+		t.Fatalf("unexpected statistics %v, %v", single, err)
+	}
+}
@@ -0,0 +1,420 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/internal/util"
+	"github.com/ClusterCockpit/cc-backend/pkg/archive"
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+type ReplayDataRepositoryConfig struct {
+	// Directory with recorded metric data: Job data files of the
+	// job-archive (*.json) and InfluxDB line protocol files (*.lp) as
+	// written by cc-metric-collector.
+	Path string `json:"path"`
+}
+
+type replaySeries struct {
+	id       *string
+	timestep int
+	data     []schema.Float
+}
+
+// Serves recorded metric data for offline testing and demos. The recordings
+// are replayed in a loop: The value at time t is the sample with index
+// (t / timestep) modulo the length of the recording, so data is available
+// for any time range, also for running jobs. Hosts not in the recordings are
+// mapped to recorded hosts.
+type ReplayDataRepository struct {
+	cluster string
+
+	// Map of metrics to scopes to hosts to series
+	data  map[string]map[schema.MetricScope]map[string][]*replaySeries
+	units map[string]schema.Unit
+	hosts []string
+}
+
+func (rdr *ReplayDataRepository) Init(rawConfig json.RawMessage) error {
+	var config ReplayDataRepositoryConfig
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		log.Warn("Error while unmarshaling raw json config")
+		return err
+	}
+	if config.Path == "" {
+		return errors.New("METRICDATA/REPLAY > no path configured")
+	}
+
+	rdr.data = make(map[string]map[schema.MetricScope]map[string][]*replaySeries)
+	rdr.units = make(map[string]schema.Unit)
+	err := filepath.WalkDir(config.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		switch filepath.Ext(path) {
+		case ".json":
+			return rdr.loadJobData(path)
+		case ".lp":
+			return rdr.loadLineProtocol(path)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error while loading recorded metric data from %s", config.Path)
+		return err
+	}
+
+	hosts := make(map[string]bool)
+	for _, scopes := range rdr.data {
+		for _, series := range scopes {
+			for host := range series {
+				hosts[host] = true
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return fmt.Errorf("METRICDATA/REPLAY > no recorded metric data found in %s", config.Path)
+	}
+
+	rdr.hosts = make([]string, 0, len(hosts))
+	for host := range hosts {
+		rdr.hosts = append(rdr.hosts, host)
+	}
+	sort.Strings(rdr.hosts)
+
+	log.Infof("Replaying metric data of %d metrics and %d hosts from %s", len(rdr.data), len(rdr.hosts), config.Path)
+	return nil
+}
+
+// Adds a series unless one with the same metric, scope, host and id exists.
+func (rdr *ReplayDataRepository) add(metric string, scope schema.MetricScope, host string, series *replaySeries) {
+	if series.timestep <= 0 || len(series.data) == 0 {
+		return
+	}
+
+	scopes, ok := rdr.data[metric]
+	if !ok {
+		scopes = make(map[schema.MetricScope]map[string][]*replaySeries)
+		rdr.data[metric] = scopes
+	}
+	hosts, ok := scopes[scope]
+	if !ok {
+		hosts = make(map[string][]*replaySeries)
+		scopes[scope] = hosts
+	}
+
+	for _, s := range hosts[host] {
+		if (s.id == nil && series.id == nil) || (s.id != nil && series.id != nil && *s.id == *series.id) {
+			return
+		}
+	}
+	hosts[host] = append(hosts[host], series)
+}
+
+// Load a data.json file of the job-archive.
+func (rdr *ReplayDataRepository) loadJobData(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Warnf("Error while opening %s", path)
+		return err
+	}
+	defer f.Close()
+
+	var jobData schema.JobData
+	if err := json.NewDecoder(bufio.NewReader(f)).Decode(&jobData); err != nil {
+		// Other JSON files like meta.json are skipped
+		log.Debugf("Skipping %s: %s", path, err.Error())
+		return nil
+	}
+
+	for metric, scopes := range jobData {
+		for scope, jm := range scopes {
+			if _, ok := rdr.units[metric]; !ok {
+				rdr.units[metric] = jm.Unit
+			}
+			for _, series := range jm.Series {
+				rdr.add(metric, scope, series.Hostname, &replaySeries{
+					id:       series.Id,
+					timestep: jm.Timestep,
+					data:     series.Data,
+				})
+			}
+		}
+	}
+	return nil
+}
+
+type replaySample struct {
+	time  int64
+	value float64
+}
+
+// Load a file in InfluxDB line protocol, see decodeLine.
+func (rdr *ReplayDataRepository) loadLineProtocol(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Warnf("Error while opening %s", path)
+		return err
+	}
+	defer f.Close()
+
+	type seriesKey struct {
+		metric, host, id string
+		scope            schema.MetricScope
+	}
+	samples := make(map[seriesKey][]replaySample)
+
+	scanner := bufio.NewScanner(f)
+	skipped := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		p, ok := decodeLine(line)
+		if !ok {
+			skipped++
+			continue
+		}
+
+		key := seriesKey{metric: p.metric, host: p.host, id: p.id, scope: p.scope}
+		samples[key] = append(samples[key], replaySample{time: p.time, value: p.value})
+	}
+	if err := scanner.Err(); err != nil {
+		log.Warnf("Error while reading %s", path)
+		return err
+	}
+	if skipped > 0 {
+		log.Warnf("Skipped %d invalid lines in %s", skipped, path)
+	}
+
+	for key, s := range samples {
+		timestep := 60
+		if mc := archive.GetMetricConfig(rdr.cluster, key.metric); mc != nil && mc.Timestep > 0 {
+			timestep = mc.Timestep
+		}
+
+		series, dropped := samplesToSeries(s, int64(timestep))
+		if dropped > 0 {
+			log.Warnf("Dropped %d samples of metric %s on host %s in %s exceeding %d values",
+				dropped, key.metric, key.host, path, maxReplaySeriesLength)
+		}
+		if key.scope != schema.MetricScopeNode {
+			id := key.id
+			series.id = &id
+		}
+		rdr.add(key.metric, key.scope, key.host, series)
+	}
+	return nil
+}
+
+// Maximum number of values of a series loaded from line protocol or
+// replayed at once. A single sample far off the others or a query from the
+// epoch would otherwise allocate a huge series.
+const maxReplaySeriesLength = 100000
+
+// Samples are placed at the timestep of the metric, missing samples are NaN.
+// Samples after the first maxReplaySeriesLength values are dropped, their
+// number is returned.
+func samplesToSeries(samples []replaySample, timestep int64) (*replaySeries, int) {
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].time < samples[j].time
+	})
+
+	start := samples[0].time
+	n := (samples[len(samples)-1].time-start)/timestep + 1
+	if n > maxReplaySeriesLength {
+		n = maxReplaySeriesLength
+	}
+
+	data := make([]schema.Float, n)
+	for i := range data {
+		data[i] = schema.NaN
+	}
+	dropped := 0
+	for _, s := range samples {
+		i := (s.time - start) / timestep
+		if i >= n {
+			dropped++
+			continue
+		}
+		data[i] = schema.Float(s.value)
+	}
+
+	return &replaySeries{timestep: int(timestep), data: data}, dropped
+}
+
+// Returns the recorded host used for host.
+func (rdr *ReplayDataRepository) recordedHost(host string) string {
+	i := sort.SearchStrings(rdr.hosts, host)
+	if i < len(rdr.hosts) && rdr.hosts[i] == host {
+		return host
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(host))
+	return rdr.hosts[h.Sum32()%uint32(len(rdr.hosts))]
+}
+
+// Replay series for the time range [from, to]. At most the last
+// maxReplaySeriesLength values of longer time ranges are replayed.
+func (rs *replaySeries) replay(host string, from, to time.Time) schema.Series {
+	n := (to.Unix()-from.Unix())/int64(rs.timestep) + 1
+	if n < 1 {
+		n = 1
+	}
+	start := from.Unix()
+	if n > maxReplaySeriesLength {
+		start += (n - maxReplaySeriesLength) * int64(rs.timestep)
+		n = maxReplaySeriesLength
+	}
+
+	data := make([]schema.Float, n)
+	offset := int(start / int64(rs.timestep))
+	for i := range data {
+		j := (offset + i) % len(rs.data)
+		if j < 0 {
+			j += len(rs.data)
+		}
+		data[i] = rs.data[j]
+	}
+	return newSeries(host, rs.id, data)
+}
+
+func (rdr *ReplayDataRepository) scopes(metric string) []schema.MetricScope {
+	recorded, ok := rdr.data[metric]
+	if !ok {
+		return nil
+	}
+
+	scopes := make([]schema.MetricScope, 0, len(recorded))
+	for scope := range recorded {
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
+
+// Replays the recordings of the recorded host used for host. If none of the
+// recorded ids is in ids, all are used.
+func (rdr *ReplayDataRepository) series(
+	metric string,
+	scope schema.MetricScope,
+	host string,
+	ids []string,
+	from, to time.Time) []schema.Series {
+
+	recorded := rdr.data[metric][scope][rdr.recordedHost(host)]
+	res := make([]schema.Series, 0, len(recorded))
+	for _, rs := range recorded {
+		if ids != nil && rs.id != nil && !util.Contains(ids, *rs.id) {
+			continue
+		}
+		res = append(res, rs.replay(host, from, to))
+	}
+
+	if len(res) == 0 && ids != nil {
+		// The recorded ids do not match the resources of the job
+		return rdr.series(metric, scope, host, nil, from, to)
+	}
+	return res
+}
+
+func (rdr *ReplayDataRepository) timestep(metric string, scope schema.MetricScope) int {
+	for _, series := range rdr.data[metric][scope] {
+		return series[0].timestep
+	}
+	return 0
+}
+
+// Unit from the metric config of the cluster, or from the recordings.
+func (rdr *ReplayDataRepository) unit(metric string) schema.Unit {
+	if mc := archive.GetMetricConfig(rdr.cluster, metric); mc != nil {
+		return mc.Unit
+	}
+	return rdr.units[metric]
+}
+
+func (rdr *ReplayDataRepository) load(
+	resources []*schema.Resource,
+	metrics []string,
+	scopes []schema.MetricScope,
+	from, to time.Time) (schema.JobData, error) {
+
+	jobData, errs := loadLocal(rdr, resources, metrics, scopes, from, to)
+	if len(errs) != 0 {
+		return jobData, fmt.Errorf("METRICDATA/REPLAY > Errors: %s", strings.Join(errs, ", "))
+	}
+	return jobData, nil
+}
+
+func (rdr *ReplayDataRepository) LoadData(
+	job *schema.Job,
+	metrics []string,
+	scopes []schema.MetricScope,
+	ctx context.Context) (schema.JobData, error) {
+
+	to := job.StartTime.Add(time.Duration(job.Duration) * time.Second)
+	if job.State == schema.JobStateRunning {
+		to = time.Now()
+	}
+
+	return rdr.load(job.Resources, metrics, scopes, job.StartTime, to)
+}
+
+func (rdr *ReplayDataRepository) LoadStats(
+	job *schema.Job,
+	metrics []string,
+	ctx context.Context) (map[string]map[string]schema.MetricStatistics, error) {
+
+	jobData, err := rdr.LoadData(job, metrics, []schema.MetricScope{schema.MetricScopeNode}, ctx)
+	return localStats(jobData), err
+}
+
+func (rdr *ReplayDataRepository) LoadStatsBatch(
+	jobs []*schema.Job,
+	metrics []string,
+	ctx context.Context) ([]map[string]map[string]schema.MetricStatistics, error) {
+
+	return loadStatsPerJob(rdr, jobs, metrics, ctx)
+}
+
+func (rdr *ReplayDataRepository) LoadNodeData(
+	cluster string,
+	metrics, nodes []string,
+	scopes []schema.MetricScope,
+	from, to time.Time,
+	ctx context.Context) (map[string]map[string][]*schema.JobMetric, error) {
+
+	if nodes == nil {
+		nodes = rdr.hosts
+	}
+	if len(scopes) == 0 {
+		scopes = []schema.MetricScope{schema.MetricScopeNode}
+	}
+
+	resources := make([]*schema.Resource, 0, len(nodes))
+	for _, node := range nodes {
+		resources = append(resources, &schema.Resource{Hostname: node})
+	}
+
+	jobData, err := rdr.load(resources, metrics, scopes, from, to)
+	return localNodeData(jobData), err
+}
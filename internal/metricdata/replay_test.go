@@ -0,0 +1,147 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+func setupReplay(t *testing.T) *ReplayDataRepository {
+	dir := t.TempDir()
+	jobData := `{"mem_used": {"node": {"unit": {"base": "B", "prefix": "G"}, "timestep": 60, "series": [
+		{"hostname": "r1", "statistics": {"min": 1, "avg": 2, "max": 3}, "data": [1, 2, 3]}
+	]}}}`
+	lp := "# recorded with cc-metric-collector\n" +
+		"flops_any,cluster=testcluster,hostname=r1,type=hwthread,type-id=0 value=1 1700000000000000000\n" +
+		"flops_any,cluster=testcluster,hostname=r1,type=hwthread,type-id=1 value=2 1700000000000000000\n" +
+		"flops_any,cluster=testcluster,hostname=r1,type=hwthread,type-id=0 value=3 1700000060000000000\n" +
+		"flops_any,cluster=testcluster,hostname=r1,type=hwthread,type-id=1 value=4 1700000060000000000\n" +
+		"invalid line\n"
+	if err := os.MkdirAll(filepath.Join(dir, "job"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "job", "data.json"), []byte(jobData), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "job", "meta.json"), []byte(`{"jobId": 1}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "metrics.lp"), []byte(lp), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	rdr := &ReplayDataRepository{cluster: "testcluster"}
+	config, _ := json.Marshal(ReplayDataRepositoryConfig{Path: dir})
+	if err := rdr.Init(config); err != nil {
+		t.Fatal(err)
+	}
+	return rdr
+}
+
+func TestReplayLoadData(t *testing.T) {
+	rdr := setupReplay(t)
+	if len(rdr.hosts) != 1 || len(rdr.data) != 2 {
+		t.Fatalf("unexpected recordings %v", rdr.data)
+	}
+
+	job := &schema.Job{}
+	job.Cluster = "testcluster"
+	job.State = schema.JobStateCompleted
+	job.StartTime = time.Unix(1700000040, 0)
+	job.Duration = 240
+	job.Resources = []*schema.Resource{{Hostname: "r1", HWThreads: []int{1}}, {Hostname: "other"}}
+
+	jd, err := rdr.LoadData(job, []string{"mem_used", "flops_any"}, []schema.MetricScope{schema.MetricScopeNode, schema.MetricScopeHWThread}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replayed in a loop starting at index 1700000040 / 60 % 3 = 1
+	mem := jd["mem_used"][schema.MetricScopeNode]
+	if mem == nil || len(mem.Series) != 2 || mem.Unit.Prefix != "G" || mem.Series[1].Hostname != "other" {
+		t.Fatalf("unexpected mem_used %+v", mem)
+	}
+	if data := mem.Series[0].Data; len(data) != 5 || data[0] != 3 || data[1] != 1 || data[4] != 1 {
+		t.Fatalf("unexpected data %v", data)
+	}
+
+	hwthreads := jd["flops_any"][schema.MetricScopeHWThread]
+	if hwthreads == nil || len(hwthreads.Series) != 3 || *hwthreads.Series[0].Id != "1" {
+		t.Fatalf("unexpected hwthread data %+v", hwthreads)
+	}
+	node := jd["flops_any"][schema.MetricScopeNode]
+	if node == nil || len(node.Series) != 2 {
+		t.Fatalf("expected aggregated node data, got %+v", jd["flops_any"])
+	}
+	for _, series := range node.Series {
+		// Only hwthread 1 is used by the job on r1, other is mapped to r1
+		if (series.Hostname == "r1" && series.Data[0] != 2) || (series.Hostname == "other" && series.Data[0] != 3) {
+			t.Errorf("unexpected node data %v for %s", series.Data, series.Hostname)
+		}
+	}
+
+	if _, err := rdr.LoadData(job, []string{"unknown"}, []schema.MetricScope{schema.MetricScopeNode}, context.Background()); err == nil {
+		t.Fatal("expected error for unknown metric")
+	}
+
+	stats, err := rdr.LoadStats(job, []string{"mem_used"}, context.Background())
+	if err != nil || stats["mem_used"]["r1"].Max != 3 {
+		t.Fatalf("unexpected stats %v, %v", stats, err)
+	}
+}
+
+func TestReplayLoadNodeData(t *testing.T) {
+	rdr := setupReplay(t)
+
+	data, err := rdr.LoadNodeData("testcluster", []string{"flops_any"}, nil, nil,
+		time.Unix(1700000000, 0), time.Unix(1700000060, 0), context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || len(data["r1"]["flops_any"]) != 1 || data["r1"]["flops_any"][0].Series[0].Data[0] != 7 {
+		t.Fatalf("unexpected node data %v", data)
+	}
+}
+
+func TestReplaySamplesToSeries(t *testing.T) {
+	samples := []replaySample{{time: 1120, value: 3}, {time: 1000, value: 1}, {time: 1010, value: 2}}
+	series, dropped := samplesToSeries(samples, 60)
+	if dropped != 0 || series.timestep != 60 || len(series.data) != 3 {
+		t.Fatalf("unexpected series %+v", series)
+	}
+	if series.data[0] != 2 || !series.data[1].IsNaN() || series.data[2] != 3 {
+		t.Fatalf("unexpected data %v", series.data)
+	}
+
+	samples = []replaySample{{time: 0, value: 1}, {time: 60 * maxReplaySeriesLength, value: 2}}
+	series, dropped = samplesToSeries(samples, 60)
+	if dropped != 1 || len(series.data) != maxReplaySeriesLength || series.data[0] != 1 {
+		t.Fatalf("expected series to be capped, got %d values and %d dropped", len(series.data), dropped)
+	}
+}
+
+func TestReplayLongTimeRange(t *testing.T) {
+	rs := &replaySeries{timestep: 60, data: []schema.Float{1, 2, 3}}
+
+	series := rs.replay("r1", time.Unix(0, 0), time.Unix(1700000040, 0))
+	if len(series.Data) != maxReplaySeriesLength {
+		t.Fatalf("unexpected length %d", len(series.Data))
+	}
+	// The last value is the one at 1700000040, index 1700000040 / 60 % 3 = 2
+	if series.Data[len(series.Data)-1] != 3 {
+		t.Fatalf("unexpected last value %v", series.Data[len(series.Data)-1])
+	}
+
+	if series := rs.replay("r1", time.Unix(-120, 0), time.Unix(0, 0)); len(series.Data) != 3 || series.Data[0] != 2 {
+		t.Fatalf("unexpected data %v", series.Data)
+	}
+}
@@ -0,0 +1,433 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/archive"
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Names of the columns of the hypertable holding the metric data. The type
+// column contains "node" or "hwthread", the type-id column the id of the
+// hardware thread (ignored for node rows).
+type TimescaleDBColumns struct {
+	Host   string `json:"host"`
+	Type   string `json:"type"`
+	TypeId string `json:"type-id"`
+	Metric string `json:"metric"`
+	Value  string `json:"value"`
+	Time   string `json:"time"`
+}
+
+type TimescaleDBDataRepositoryConfig struct {
+	// Connection string passed to the database driver
+	Url string `json:"url"`
+	// Name of a registered database/sql driver, defaults to "postgres"
+	Driver  string             `json:"driver,omitempty"`
+	Table   string             `json:"table"`
+	Columns TimescaleDBColumns `json:"columns"`
+}
+
+type TimescaleDBDataRepository struct {
+	db      *sql.DB
+	table   string
+	columns TimescaleDBColumns
+}
+
+// A single value of a time_bucket() query
+type timescaleRow struct {
+	host   string
+	typ    string
+	typeId string
+	bucket int64
+	value  sql.NullFloat64
+}
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+func (tdb *TimescaleDBDataRepository) Init(rawConfig json.RawMessage) error {
+	config := TimescaleDBDataRepositoryConfig{
+		Driver: "postgres",
+		Table:  "metrics",
+		Columns: TimescaleDBColumns{
+			Host:   "host",
+			Type:   "type",
+			TypeId: "type_id",
+			Metric: "metric",
+			Value:  "value",
+			Time:   "time",
+		},
+	}
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		log.Warn("Error while unmarshaling raw json config")
+		return err
+	}
+
+	// The names are part of the queries and cannot be passed as arguments
+	for _, name := range []string{config.Table, config.Columns.Host, config.Columns.Type,
+		config.Columns.TypeId, config.Columns.Metric, config.Columns.Value, config.Columns.Time} {
+		if !identifierRegexp.MatchString(name) {
+			return fmt.Errorf("METRICDATA/TIMESCALEDB > invalid table or column name '%s'", name)
+		}
+	}
+
+	db, err := sql.Open(config.Driver, config.Url)
+	if err != nil {
+		log.Errorf("Error while opening TimescaleDB database with driver '%s'", config.Driver)
+		return err
+	}
+	db.SetMaxOpenConns(4)
+	db.SetConnMaxIdleTime(time.Hour)
+
+	tdb.db = db
+	tdb.table = config.Table
+	tdb.columns = config.Columns
+	return nil
+}
+
+// Build the query resampling metric to buckets of step seconds starting at
+// from. The query returns host, type, type id, bucket start (unix time) and
+// the average of the bucket.
+func (tdb *TimescaleDBDataRepository) seriesQuery(
+	metric string,
+	step int,
+	types []string,
+	hosts []string,
+	from, to time.Time) sq.SelectBuilder {
+
+	c := tdb.columns
+	bucket := fmt.Sprintf("CAST(EXTRACT(EPOCH FROM time_bucket(? * INTERVAL '1 second', %s, to_timestamp(?))) AS BIGINT)", c.Time)
+	query := sq.Select(c.Host, c.Type, c.TypeId).
+		Column(sq.Alias(sq.Expr(bucket, step, from.Unix()), "bucket")).
+		Column(fmt.Sprintf("AVG(%s)", c.Value)).
+		From(tdb.table).
+		Where(sq.Eq{c.Metric: metric, c.Type: types}).
+		Where(fmt.Sprintf("%s >= to_timestamp(?)", c.Time), from.Unix()).
+		Where(fmt.Sprintf("%s <= to_timestamp(?)", c.Time), to.Unix())
+	if hosts != nil {
+		query = query.Where(sq.Eq{c.Host: hosts})
+	}
+
+	return query.GroupBy(c.Host, c.Type, c.TypeId, "bucket").
+		OrderBy(c.Host, c.Type, c.TypeId, "bucket").
+		PlaceholderFormat(sq.Dollar)
+}
+
+func (tdb *TimescaleDBDataRepository) queryRows(
+	ctx context.Context,
+	query sq.SelectBuilder) ([]timescaleRow, error) {
+
+	rows, err := query.RunWith(tdb.db).QueryContext(ctx)
+	if err != nil {
+		qs, _, _ := query.ToSql()
+		log.Errorf("TimescaleDB query error: %v\nQuery: %s", err, qs)
+		return nil, errors.New("TimescaleDB query error")
+	}
+	defer rows.Close()
+
+	result := make([]timescaleRow, 0)
+	for rows.Next() {
+		var r timescaleRow
+		var typeId sql.NullString
+		if err := rows.Scan(&r.host, &r.typ, &typeId, &r.bucket, &r.value); err != nil {
+			log.Warn("Error while scanning rows")
+			return nil, err
+		}
+		r.typeId = typeId.String
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// Group the rows by host and type id and convert them to one series each,
+// with steps+1 values starting at from. Rows of hardware threads not in
+// hwthreads are skipped (all are kept if there is no entry for the host).
+func rowsToSeries(
+	rows []timescaleRow,
+	typ string,
+	from time.Time,
+	step int64,
+	steps int64,
+	hwthreads map[string][]int) []schema.Series {
+
+	series := make([]schema.Series, 0)
+	index := make(map[string]int)
+	for _, r := range rows {
+		if r.typ != typ {
+			continue
+		}
+		if typ == "hwthread" && !selectedHWThread(hwthreads, r.host, r.typeId) {
+			continue
+		}
+
+		key := r.host + "/" + r.typeId
+		i, ok := index[key]
+		if !ok {
+			s := schema.Series{
+				Hostname: r.host,
+				Data:     make([]schema.Float, steps+1),
+			}
+			if typ != "node" {
+				id := r.typeId
+				s.Id = &id
+			}
+			for j := range s.Data {
+				s.Data[j] = schema.NaN
+			}
+			i = len(series)
+			index[key] = i
+			series = append(series, s)
+		}
+
+		idx := (r.bucket - from.Unix()) / step
+		if idx >= 0 && idx <= steps && r.value.Valid {
+			series[i].Data[idx] = schema.Float(r.value.Float64)
+		}
+	}
+
+	for i := range series {
+		min, max, mean := MinMaxMean(series[i].Data)
+		series[i].Statistics = schema.MetricStatistics{Avg: mean, Min: min, Max: max}
+	}
+	sort.SliceStable(series, func(i, j int) bool {
+		return series[i].Hostname < series[j].Hostname
+	})
+
+	return series
+}
+
+func selectedHWThread(hwthreads map[string][]int, host, typeId string) bool {
+	ids, ok := hwthreads[host]
+	if !ok || ids == nil {
+		return true
+	}
+
+	id, err := strconv.Atoi(typeId)
+	if err != nil {
+		return false
+	}
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Map the requested scopes to the types stored in the table.
+func timescaleTypes(scopes []schema.MetricScope) []string {
+	types := make([]string, 0, 2)
+	for _, scope := range scopes {
+		switch scope {
+		case schema.MetricScopeNode:
+			types = append(types, "node")
+		case schema.MetricScopeHWThread:
+			types = append(types, "hwthread")
+		default:
+			logOnce.Do(func() {
+				log.Infof("Scope '%s' requested, but TimescaleDB only supports 'node' and 'hwthread' scope.", scope)
+			})
+		}
+	}
+	if len(types) == 0 {
+		types = append(types, "node")
+	}
+	return types
+}
+
+// Load metric for hosts (all hosts if nil) and return the resulting job
+// metric per scope.
+func (tdb *TimescaleDBDataRepository) loadMetric(
+	ctx context.Context,
+	cluster, metric string,
+	types []string,
+	hosts []string,
+	hwthreads map[string][]int,
+	from, to time.Time) (map[schema.MetricScope]*schema.JobMetric, error) {
+
+	mc := archive.GetMetricConfig(cluster, metric)
+	if mc == nil {
+		log.Warnf("Metric %s for cluster %s not configured", metric, cluster)
+		return nil, errors.New("TimescaleDB config error")
+	}
+
+	rows, err := tdb.queryRows(ctx, tdb.seriesQuery(metric, mc.Timestep, types, hosts, from, to))
+	if err != nil {
+		return nil, err
+	}
+
+	step := int64(mc.Timestep)
+	steps := int64(to.Sub(from).Seconds()) / step
+	data := make(map[schema.MetricScope]*schema.JobMetric)
+	for _, typ := range types {
+		series := rowsToSeries(rows, typ, from, step, steps, hwthreads)
+		if len(series) == 0 {
+			continue
+		}
+		data[schema.MetricScope(typ)] = &schema.JobMetric{
+			Unit:     mc.Unit,
+			Timestep: mc.Timestep,
+			Series:   series,
+		}
+	}
+
+	return data, nil
+}
+
+func (tdb *TimescaleDBDataRepository) LoadData(
+	job *schema.Job,
+	metrics []string,
+	scopes []schema.MetricScope,
+	ctx context.Context) (schema.JobData, error) {
+
+	types := timescaleTypes(scopes)
+	hosts := make([]string, len(job.Resources))
+	hwthreads := make(map[string][]int, len(job.Resources))
+	for i, resource := range job.Resources {
+		hosts[i] = resource.Hostname
+		hwthreads[resource.Hostname] = resource.HWThreads
+	}
+	from := job.StartTime
+	to := job.StartTime.Add(time.Duration(job.Duration) * time.Second)
+
+	jobData := make(schema.JobData)
+	for _, metric := range metrics {
+		data, err := tdb.loadMetric(ctx, job.Cluster, metric, types, hosts, hwthreads, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		// only add metric if at least one host returned data
+		if len(data) != 0 {
+			jobData[metric] = data
+		}
+	}
+
+	return jobData, nil
+}
+
+func (tdb *TimescaleDBDataRepository) LoadStats(
+	job *schema.Job,
+	metrics []string,
+	ctx context.Context) (map[string]map[string]schema.MetricStatistics, error) {
+
+	hosts := make([]string, len(job.Resources))
+	for i, resource := range job.Resources {
+		hosts[i] = resource.Hostname
+	}
+	from := job.StartTime
+	to := job.StartTime.Add(time.Duration(job.Duration) * time.Second)
+
+	c := tdb.columns
+	query := sq.Select(c.Metric, c.Host,
+		fmt.Sprintf("AVG(%s)", c.Value),
+		fmt.Sprintf("MIN(%s)", c.Value),
+		fmt.Sprintf("MAX(%s)", c.Value)).
+		From(tdb.table).
+		Where(sq.Eq{c.Metric: metrics, c.Type: "node", c.Host: hosts}).
+		Where(fmt.Sprintf("%s >= to_timestamp(?)", c.Time), from.Unix()).
+		Where(fmt.Sprintf("%s <= to_timestamp(?)", c.Time), to.Unix()).
+		GroupBy(c.Metric, c.Host).
+		PlaceholderFormat(sq.Dollar)
+
+	rows, err := query.RunWith(tdb.db).QueryContext(ctx)
+	if err != nil {
+		qs, _, _ := query.ToSql()
+		log.Errorf("TimescaleDB query error: %v\nQuery: %s", err, qs)
+		return nil, errors.New("TimescaleDB query error")
+	}
+	defer rows.Close()
+
+	stats := make(map[string]map[string]schema.MetricStatistics, len(metrics))
+	for rows.Next() {
+		var metric, host string
+		var avg, min, max sql.NullFloat64
+		if err := rows.Scan(&metric, &host, &avg, &min, &max); err != nil {
+			log.Warn("Error while scanning rows")
+			return nil, err
+		}
+		if !avg.Valid {
+			continue
+		}
+
+		if _, ok := stats[metric]; !ok {
+			stats[metric] = make(map[string]schema.MetricStatistics)
+		}
+		stats[metric][host] = schema.MetricStatistics{
+			Avg: avg.Float64,
+			Min: min.Float64,
+			Max: max.Float64,
+		}
+	}
+
+	return stats, rows.Err()
+}
+
+func (tdb *TimescaleDBDataRepository) LoadStatsBatch(
+	jobs []*schema.Job,
+	metrics []string,
+	ctx context.Context) ([]map[string]map[string]schema.MetricStatistics, error) {
+
+	return loadStatsPerJob(tdb, jobs, metrics, ctx)
+}
+
+func (tdb *TimescaleDBDataRepository) LoadNodeData(
+	cluster string,
+	metrics, nodes []string,
+	scopes []schema.MetricScope,
+	from, to time.Time,
+	ctx context.Context) (map[string]map[string][]*schema.JobMetric, error) {
+
+	types := timescaleTypes(scopes)
+	data := make(map[string]map[string][]*schema.JobMetric)
+	for _, metric := range metrics {
+		metricData, err := tdb.loadMetric(ctx, cluster, metric, types, nodes, nil, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, typ := range types {
+			jm, ok := metricData[schema.MetricScope(typ)]
+			if !ok {
+				continue
+			}
+
+			// split the job metric by host
+			byHost := make(map[string]*schema.JobMetric)
+			for _, s := range jm.Series {
+				hjm, ok := byHost[s.Hostname]
+				if !ok {
+					hjm = &schema.JobMetric{Unit: jm.Unit, Timestep: jm.Timestep}
+					byHost[s.Hostname] = hjm
+				}
+				hjm.Series = append(hjm.Series, s)
+			}
+
+			for host, hjm := range byHost {
+				hostdata, ok := data[host]
+				if !ok {
+					hostdata = make(map[string][]*schema.JobMetric)
+					data[host] = hostdata
+				}
+				hostdata[metric] = append(hostdata[metric], hjm)
+			}
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,146 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"regexp"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+	ccunits "github.com/ClusterCockpit/cc-units"
+)
+
+var prefixUnitSplitRegex = regexp.MustCompile(ccunits.PrefixUnitSplitRegexStr)
+
+// Returns a function converting values in unit to the first of targets (unit
+// strings as understood by cc-units, e.g. "GB/s") with the same measure, and
+// that target. ok is false if no target matches or unit already is the
+// matching target.
+func UnitConversion(unit schema.Unit, targets []string) (conv func(float64) float64, target schema.Unit, ok bool) {
+	if len(targets) == 0 {
+		return nil, unit, false
+	}
+
+	in := ccunits.NewUnit(unit.Prefix + unit.Base)
+	if !in.Valid() {
+		return nil, unit, false
+	}
+
+	for _, t := range targets {
+		out := ccunits.NewUnit(t)
+		if !out.Valid() {
+			continue
+		}
+
+		f, err := ccunits.GetUnitUnitFactor(in, out)
+		if err != nil {
+			continue
+		}
+		if in.GetMeasure() == out.GetMeasure() && in.GetPrefix() == out.GetPrefix() {
+			return nil, unit, false
+		}
+
+		// Keep the spelling of the requested unit
+		target = schema.Unit{Base: t}
+		if out.GetPrefix() != ccunits.Base {
+			if m := prefixUnitSplitRegex.FindStringSubmatch(t); m != nil {
+				target = schema.Unit{Prefix: m[1], Base: m[2]}
+			}
+		}
+
+		return func(x float64) float64 {
+			return f(x).(float64)
+		}, target, true
+	}
+
+	return nil, unit, false
+}
+
+func convertData(data []schema.Float, conv func(float64) float64) []schema.Float {
+	res := make([]schema.Float, len(data))
+	for i, x := range data {
+		if x.IsNaN() {
+			res[i] = schema.NaN
+		} else {
+			res[i] = schema.Float(conv(float64(x)))
+		}
+	}
+	return res
+}
+
+// Returns a copy of jm with the series data, statistics and statistics series
+// converted to the first matching unit of targets, see UnitConversion. If no
+// conversion applies, jm is returned.
+func ConvertJobMetric(jm *schema.JobMetric, targets []string) *schema.JobMetric {
+	conv, unit, ok := UnitConversion(jm.Unit, targets)
+	if !ok {
+		return jm
+	}
+
+	res := &schema.JobMetric{
+		Unit:     unit,
+		Timestep: jm.Timestep,
+		Series:   make([]schema.Series, 0, len(jm.Series)),
+	}
+	for _, series := range jm.Series {
+		res.Series = append(res.Series, schema.Series{
+			Hostname: series.Hostname,
+			Id:       series.Id,
+			Statistics: schema.MetricStatistics{
+				Avg: conv(series.Statistics.Avg),
+				Min: conv(series.Statistics.Min),
+				Max: conv(series.Statistics.Max),
+			},
+			Data: convertData(series.Data, conv),
+		})
+	}
+
+	if stats := jm.StatisticsSeries; stats != nil {
+		res.StatisticsSeries = &schema.StatsSeries{
+			Mean: convertData(stats.Mean, conv),
+			Min:  convertData(stats.Min, conv),
+			Max:  convertData(stats.Max, conv),
+		}
+		if stats.Percentiles != nil {
+			res.StatisticsSeries.Percentiles = make(map[int][]schema.Float, len(stats.Percentiles))
+			for p, data := range stats.Percentiles {
+				res.StatisticsSeries.Percentiles[p] = convertData(data, conv)
+			}
+		}
+	}
+
+	return res
+}
+
+// Returns a copy of mc with the unit and the thresholds, including those of
+// the subclusters, converted to the first matching unit of targets, see
+// UnitConversion. If no conversion applies, mc is returned.
+func ConvertMetricConfig(mc *schema.MetricConfig, targets []string) *schema.MetricConfig {
+	conv, unit, ok := UnitConversion(mc.Unit, targets)
+	if !ok {
+		return mc
+	}
+
+	res := *mc
+	res.Unit = unit
+	res.Peak = conv(mc.Peak)
+	res.Normal = conv(mc.Normal)
+	res.Caution = conv(mc.Caution)
+	res.Alert = conv(mc.Alert)
+	if mc.SubClusters != nil {
+		res.SubClusters = make([]*schema.SubClusterConfig, 0, len(mc.SubClusters))
+		for _, sc := range mc.SubClusters {
+			res.SubClusters = append(res.SubClusters, &schema.SubClusterConfig{
+				Name:    sc.Name,
+				Peak:    conv(sc.Peak),
+				Normal:  conv(sc.Normal),
+				Caution: conv(sc.Caution),
+				Alert:   conv(sc.Alert),
+				Remove:  sc.Remove,
+			})
+		}
+	}
+
+	return &res
+}
@@ -0,0 +1,85 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package metricdata
+
+import (
+	"testing"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+func TestUnitConversion(t *testing.T) {
+	targets := []string{"GF/s", "GB/s"}
+
+	conv, unit, ok := UnitConversion(schema.Unit{Prefix: "M", Base: "B/s"}, targets)
+	if !ok || unit.Prefix != "G" || unit.Base != "B/s" || conv(2000) != 2 {
+		t.Fatalf("unexpected conversion to %+v", unit)
+	}
+
+	for _, u := range []schema.Unit{{Prefix: "G", Base: "B/s"}, {Base: "IPC"}, {Prefix: "G", Base: "B"}} {
+		if _, _, ok := UnitConversion(u, targets); ok {
+			t.Errorf("unexpected conversion of %+v", u)
+		}
+	}
+}
+
+func TestConvertJobMetric(t *testing.T) {
+	jm := &schema.JobMetric{
+		Unit:     schema.Unit{Prefix: "G", Base: "F/s"},
+		Timestep: 60,
+		Series: []schema.Series{{
+			Hostname:   "n1",
+			Statistics: schema.MetricStatistics{Avg: 1500, Min: 1000, Max: 2000},
+			Data:       []schema.Float{1000, schema.NaN, 2000},
+		}},
+		StatisticsSeries: &schema.StatsSeries{
+			Mean:        []schema.Float{1000},
+			Min:         []schema.Float{1000},
+			Max:         []schema.Float{1000},
+			Percentiles: map[int][]schema.Float{50: {3000}},
+		},
+	}
+
+	if ConvertJobMetric(jm, []string{"GF/s"}) != jm || ConvertJobMetric(jm, nil) != jm {
+		t.Fatal("expected metric to be unchanged")
+	}
+
+	res := ConvertJobMetric(jm, []string{"TF/s"})
+	if res.Unit.Prefix != "T" || res.Timestep != 60 {
+		t.Fatalf("unexpected metric %+v", res)
+	}
+	if s := res.Series[0]; s.Data[0] != 1 || !s.Data[1].IsNaN() || s.Statistics.Avg != 1.5 {
+		t.Fatalf("unexpected series %+v", s)
+	}
+	if res.StatisticsSeries.Percentiles[50][0] != 3 || jm.Series[0].Data[0] != 1000 {
+		t.Fatal("unexpected statistics series or original metric modified")
+	}
+}
+
+func TestConvertMetricConfig(t *testing.T) {
+	mc := &schema.MetricConfig{
+		Name:        "mem_bw",
+		Unit:        schema.Unit{Prefix: "M", Base: "B/s"},
+		Timestep:    60,
+		Peak:        4000,
+		Normal:      2000,
+		Caution:     500,
+		Alert:       100,
+		SubClusters: []*schema.SubClusterConfig{{Name: "a", Peak: 8000, Remove: true}},
+	}
+
+	if ConvertMetricConfig(mc, []string{"MB/s"}) != mc || ConvertMetricConfig(mc, nil) != mc {
+		t.Fatal("expected metric config to be unchanged")
+	}
+
+	res := ConvertMetricConfig(mc, []string{"GB/s"})
+	if res.Unit.Prefix != "G" || res.Name != "mem_bw" || res.Timestep != 60 ||
+		res.Peak != 4 || res.Normal != 2 || res.Caution != 0.5 || res.Alert != 0.1 {
+		t.Fatalf("unexpected metric config %+v", res)
+	}
+	if sc := res.SubClusters[0]; sc.Name != "a" || sc.Peak != 8 || !sc.Remove || mc.SubClusters[0].Peak != 8000 {
+		t.Fatal("unexpected subcluster config or original config modified")
+	}
+}
@@ -39,6 +39,13 @@ func (tmdr *TestMetricDataRepository) LoadStats(
 	panic("TODO")
 }
 
+func (tmdr *TestMetricDataRepository) LoadStatsBatch(
+	jobs []*schema.Job,
+	metrics []string, ctx context.Context) ([]map[string]map[string]schema.MetricStatistics, error) {
+
+	panic("TODO")
+}
+
 func (tmdr *TestMetricDataRepository) LoadNodeData(
 	cluster string,
 	metrics, nodes []string,
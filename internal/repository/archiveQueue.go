@@ -0,0 +1,267 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/internal/config"
+	"github.com/ClusterCockpit/cc-backend/internal/metricdata"
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+	sq "github.com/Masterminds/squirrel"
+)
+
+const (
+	// Number of attempts before a job is marked as ArchivingFailed
+	archiveMaxAttempts int = 6
+	// Delay before the first retry, doubled for every further attempt
+	archiveRetryDelay    time.Duration = 30 * time.Second
+	archiveMaxRetryDelay time.Duration = time.Hour
+	// Interval for looking up queue entries due for a retry
+	archivePollInterval time.Duration = 30 * time.Second
+)
+
+// A job waiting to be archived. The queue is stored in the archive_queue
+// table so that pending jobs survive a restart.
+type archiveQueueEntry struct {
+	JobID    int64 `db:"job_id"`
+	Attempts int   `db:"attempts"`
+}
+
+// StartArchiving starts the dispatcher and the worker pool processing the
+// archive queue, including jobs queued before the last shutdown. Only the
+// first call has an effect.
+func (r *JobRepository) StartArchiving() {
+	r.archiveOnce.Do(func() {
+		workers := config.Keys.ArchiveWorkers
+		if workers < 1 {
+			workers = 1
+		}
+
+		r.archiveJobs = make(chan archiveQueueEntry, workers)
+		for i := 0; i < workers; i++ {
+			go r.archivingWorker()
+		}
+		go r.archivingDispatcher()
+	})
+}
+
+// Hands due queue entries to the workers. The queue is checked whenever a
+// job was triggered and periodically for retries.
+func (r *JobRepository) archivingDispatcher() {
+	ticker := time.NewTicker(archivePollInterval)
+	defer ticker.Stop()
+
+	for {
+		entries, err := r.dueArchiveEntries()
+		if err != nil {
+			log.Errorf("Error while reading archive queue: %s", err.Error())
+		}
+
+		for _, e := range entries {
+			r.archiveMu.Lock()
+			active := r.archiveActive[e.JobID]
+			r.archiveActive[e.JobID] = true
+			r.archiveMu.Unlock()
+
+			if !active {
+				r.archiveJobs <- e
+			}
+		}
+
+		select {
+		case <-r.archiveNotify:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *JobRepository) archivingWorker() {
+	for e := range r.archiveJobs {
+		r.archiveQueuedJob(e)
+
+		r.archiveMu.Lock()
+		delete(r.archiveActive, e.JobID)
+		if r.archiveWaiting[e.JobID] {
+			delete(r.archiveWaiting, e.JobID)
+			r.archivePending.Done()
+		}
+		r.archiveMu.Unlock()
+	}
+}
+
+func (r *JobRepository) dueArchiveEntries() ([]archiveQueueEntry, error) {
+	rows, err := sq.Select("job_id", "attempts").From("archive_queue").
+		Where("next_attempt <= ?", time.Now().Unix()).
+		OrderBy("next_attempt").
+		RunWith(r.stmtCache).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]archiveQueueEntry, 0)
+	for rows.Next() {
+		var e archiveQueueEntry
+		if err := rows.Scan(&e.JobID, &e.Attempts); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+func (r *JobRepository) enqueueArchiving(jobId int64) error {
+	_, err := sq.Replace("archive_queue").
+		Columns("job_id", "attempts", "next_attempt", "last_error").
+		Values(jobId, 0, time.Now().Unix(), nil).
+		RunWith(r.stmtCache).Exec()
+	return err
+}
+
+func (r *JobRepository) dequeueArchiving(jobId int64) error {
+	_, err := sq.Delete("archive_queue").Where("job_id = ?", jobId).
+		RunWith(r.stmtCache).Exec()
+	return err
+}
+
+func (r *JobRepository) notifyArchiving() {
+	select {
+	case r.archiveNotify <- struct{}{}:
+	default:
+	}
+}
+
+// Fetch the job data from the metric data repository, write it to the
+// job archive and update the database entry of the job.
+func (r *JobRepository) archiveJob(ctx context.Context, job *schema.Job) error {
+	// not using meta data, called to load JobMeta into Cache?
+	// will fail if job meta not in repository
+	if _, err := r.FetchMetadata(job); err != nil {
+		return err
+	}
+
+	// metricdata.ArchiveJob will fetch all the data from a MetricDataRepository and push into configured archive backend
+	jobMeta, err := metricdata.ArchiveJob(job, ctx)
+	if err != nil {
+		return err
+	}
+
+	// Update the jobs database entry one last time:
+	return r.MarkArchived(job.ID, schema.MonitoringStatusArchivingSuccessful, jobMeta.Statistics)
+}
+
+func (r *JobRepository) archiveQueuedJob(e archiveQueueEntry) {
+	start := time.Now()
+	job, err := r.FindById(e.JobID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warnf("archiving job (dbid: %d) skipped: job was removed", e.JobID)
+		} else {
+			log.Errorf("archiving job (dbid: %d) failed: %s", e.JobID, err.Error())
+		}
+		r.dequeueArchiving(e.JobID)
+		return
+	}
+
+	timeout := time.Duration(config.Keys.ArchiveTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err = r.archiveJob(ctx, job); err == nil {
+		if err := r.dequeueArchiving(job.ID); err != nil {
+			log.Errorf("Error while removing job (dbid: %d) from archive queue: %s", job.ID, err.Error())
+		}
+		log.Debugf("archiving job %d took %s", job.JobID, time.Since(start))
+		log.Printf("archiving job (dbid: %d) successful", job.ID)
+		return
+	}
+
+	attempts := e.Attempts + 1
+	if attempts >= archiveMaxAttempts {
+		log.Errorf("archiving job (dbid: %d) failed after %d attempts: %s", job.ID, attempts, err.Error())
+		r.UpdateMonitoringStatus(job.ID, schema.MonitoringStatusArchivingFailed)
+		r.dequeueArchiving(job.ID)
+		return
+	}
+
+	delay := archiveRetryDelay << (attempts - 1)
+	if delay > archiveMaxRetryDelay {
+		delay = archiveMaxRetryDelay
+	}
+	log.Warnf("archiving job (dbid: %d) failed, retry in %s: %s", job.ID, delay, err.Error())
+
+	if _, err := sq.Update("archive_queue").
+		Set("attempts", attempts).
+		Set("next_attempt", time.Now().Add(delay).Unix()).
+		Set("last_error", err.Error()).
+		Where("job_id = ?", job.ID).
+		RunWith(r.stmtCache).Exec(); err != nil {
+		log.Errorf("Error while updating archive queue: %s", err.Error())
+	}
+}
+
+// Trigger async archiving
+func (r *JobRepository) TriggerArchiving(job *schema.Job) {
+	r.StartArchiving()
+
+	r.archiveMu.Lock()
+	if !r.archiveWaiting[job.ID] {
+		r.archiveWaiting[job.ID] = true
+		r.archivePending.Add(1)
+	}
+	r.archiveMu.Unlock()
+
+	if err := r.enqueueArchiving(job.ID); err != nil {
+		log.Errorf("archiving job (dbid: %d) failed: %s", job.ID, err.Error())
+		r.UpdateMonitoringStatus(job.ID, schema.MonitoringStatusArchivingFailed)
+
+		r.archiveMu.Lock()
+		delete(r.archiveWaiting, job.ID)
+		r.archivePending.Done()
+		r.archiveMu.Unlock()
+		return
+	}
+
+	r.notifyArchiving()
+}
+
+// Queue all jobs whose archiving has failed again. Returns the number of
+// queued jobs. The jobs are processed by the workers of the server.
+func (r *JobRepository) RetryFailedArchiving() (int, error) {
+	jobs, err := r.FindJobsByMonitoringStatus(schema.MonitoringStatusArchivingFailed)
+	if err != nil {
+		log.Warn("Error while looking for jobs with failed archiving")
+		return 0, err
+	}
+
+	for _, job := range jobs {
+		if err := r.UpdateMonitoringStatus(job.ID, schema.MonitoringStatusRunningOrArchiving); err != nil {
+			log.Warnf("Error while resetting monitoring status of job (dbid: %d)", job.ID)
+			return 0, err
+		}
+		if err := r.enqueueArchiving(job.ID); err != nil {
+			log.Warnf("Error while queueing job (dbid: %d) for archiving", job.ID)
+			return 0, err
+		}
+	}
+
+	r.notifyArchiving()
+	return len(jobs), nil
+}
+
+// Wait for the workers to finish the current archiving attempt of all jobs
+// triggered by this process. Jobs waiting for a retry stay in the queue.
+func (r *JobRepository) WaitForArchiving() {
+	r.archivePending.Wait()
+}
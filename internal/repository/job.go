@@ -15,7 +15,6 @@ import (
 	"time"
 
 	"github.com/ClusterCockpit/cc-backend/internal/graph/model"
-	"github.com/ClusterCockpit/cc-backend/internal/metricdata"
 	"github.com/ClusterCockpit/cc-backend/pkg/archive"
 	"github.com/ClusterCockpit/cc-backend/pkg/log"
 	"github.com/ClusterCockpit/cc-backend/pkg/lrucache"
@@ -30,11 +29,17 @@ var (
 )
 
 type JobRepository struct {
-	DB             *sqlx.DB
-	stmtCache      *sq.StmtCache
-	cache          *lrucache.Cache
-	archiveChannel chan *schema.Job
-	driver         string
+	DB        *sqlx.DB
+	stmtCache *sq.StmtCache
+	cache     *lrucache.Cache
+	driver    string
+
+	archiveOnce    sync.Once
+	archiveNotify  chan struct{}
+	archiveJobs    chan archiveQueueEntry
+	archiveMu      sync.Mutex
+	archiveActive  map[int64]bool // Queue entries handed to a worker
+	archiveWaiting map[int64]bool // Jobs triggered by this process
 	archivePending sync.WaitGroup
 }
 
@@ -48,10 +53,10 @@ func GetJobRepository() *JobRepository {
 
 			stmtCache:      sq.NewStmtCache(db.DB),
 			cache:          lrucache.New(1024 * 1024),
-			archiveChannel: make(chan *schema.Job, 128),
+			archiveNotify:  make(chan struct{}, 1),
+			archiveActive:  make(map[int64]bool),
+			archiveWaiting: make(map[int64]bool),
 		}
-		// start archiving worker
-		go jobRepoInstance.archivingWorker()
 	})
 	return jobRepoInstance
 }
@@ -508,56 +513,6 @@ func (r *JobRepository) MarkArchived(
 	return nil
 }
 
-// Archiving worker thread
-func (r *JobRepository) archivingWorker() {
-	for {
-		select {
-		case job, ok := <-r.archiveChannel:
-			if !ok {
-				break
-			}
-			start := time.Now()
-			// not using meta data, called to load JobMeta into Cache?
-			// will fail if job meta not in repository
-			if _, err := r.FetchMetadata(job); err != nil {
-				log.Errorf("archiving job (dbid: %d) failed: %s", job.ID, err.Error())
-				r.UpdateMonitoringStatus(job.ID, schema.MonitoringStatusArchivingFailed)
-				continue
-			}
-
-			// metricdata.ArchiveJob will fetch all the data from a MetricDataRepository and push into configured archive backend
-			// TODO: Maybe use context with cancel/timeout here
-			jobMeta, err := metricdata.ArchiveJob(job, context.Background())
-			if err != nil {
-				log.Errorf("archiving job (dbid: %d) failed: %s", job.ID, err.Error())
-				r.UpdateMonitoringStatus(job.ID, schema.MonitoringStatusArchivingFailed)
-				continue
-			}
-
-			// Update the jobs database entry one last time:
-			if err := r.MarkArchived(job.ID, schema.MonitoringStatusArchivingSuccessful, jobMeta.Statistics); err != nil {
-				log.Errorf("archiving job (dbid: %d) failed: %s", job.ID, err.Error())
-				continue
-			}
-			log.Debugf("archiving job %d took %s", job.JobID, time.Since(start))
-			log.Printf("archiving job (dbid: %d) successful", job.ID)
-			r.archivePending.Done()
-		}
-	}
-}
-
-// Trigger async archiving
-func (r *JobRepository) TriggerArchiving(job *schema.Job) {
-	r.archivePending.Add(1)
-	r.archiveChannel <- job
-}
-
-// Wait for background thread to finish pending archiving operations
-func (r *JobRepository) WaitForArchiving() {
-	// close channel and wait for worker to process remaining jobs
-	r.archivePending.Wait()
-}
-
 func (r *JobRepository) FindUserOrProjectOrJobname(user *schema.User, searchterm string) (jobid string, username string, project string, jobname string) {
 	if _, err := strconv.Atoi(searchterm); err == nil { // Return empty on successful conversion: parent method will redirect for integer jobId
 		return searchterm, "", "", ""
@@ -778,6 +733,63 @@ func (r *JobRepository) FindJobsBetween(startTimeBegin int64, startTimeEnd int64
 	return jobs, nil
 }
 
+// FindAllFootprints returns all jobs with only the database id, the
+// identifying columns, duration, state and the statistics footprint set.
+func (r *JobRepository) FindAllFootprints() ([]*schema.Job, error) {
+	query := sq.Select("job.id", "job.job_id", "job.cluster", "job.start_time", "job.duration",
+		"job.job_state", "job.monitoring_status", "job.mem_used_max", "job.flops_any_avg",
+		"job.mem_bw_avg", "job.load_avg", "job.net_bw_avg", "job.file_bw_avg").From("job")
+
+	rows, err := query.RunWith(r.stmtCache).Query()
+	if err != nil {
+		log.Error("Error while running query")
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]*schema.Job, 0, 50)
+	for rows.Next() {
+		job := &schema.Job{}
+		if err := rows.Scan(&job.ID, &job.JobID, &job.Cluster, &job.StartTimeUnix, &job.Duration,
+			&job.State, &job.MonitoringStatus, &job.MemUsedMax, &job.FlopsAnyAvg,
+			&job.MemBwAvg, &job.LoadAvg, &job.NetBwAvg, &job.FileBwAvg); err != nil {
+			log.Warn("Error while scanning rows")
+			return nil, err
+		}
+		job.StartTime = time.Unix(job.StartTimeUnix, 0)
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// FindJobsByMonitoringStatus returns all jobs which are not running and
+// have the given monitoring status.
+func (r *JobRepository) FindJobsByMonitoringStatus(monitoringStatus int32) ([]*schema.Job, error) {
+	query := sq.Select(jobColumns...).From("job").
+		Where("job.monitoring_status = ?", monitoringStatus).
+		Where("job.job_state != ?", schema.JobStateRunning)
+
+	rows, err := query.RunWith(r.stmtCache).Query()
+	if err != nil {
+		log.Error("Error while running query")
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]*schema.Job, 0, 50)
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			log.Warn("Error while scanning rows")
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
 const NamedJobInsert string = `INSERT INTO job (
 	job_id, user, project, cluster, subcluster, ` + "`partition`" + `, array_job_id, num_nodes, num_hwthreads, num_acc,
 	exclusive, monitoring_status, smt, job_state, start_time, duration, walltime, resources, meta_data,
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -57,3 +58,23 @@ func TestGetTags(t *testing.T) {
 		t.Errorf("wrong tag count \ngot: %d \nwant: 0", counts["bandwidth"])
 	}
 }
+
+func TestFindJobsByMonitoringStatus(t *testing.T) {
+	r := setup(t)
+
+	jobs, err := r.FindJobsByMonitoringStatus(schema.MonitoringStatusArchivingSuccessful)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 6 {
+		t.Errorf("wrong job count \ngot: %d \nwant: 6", len(jobs))
+	}
+
+	jobs, err = r.FindJobsByMonitoringStatus(schema.MonitoringStatusArchivingFailed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 0 {
+		t.Errorf("wrong job count \ngot: %d \nwant: 0", len(jobs))
+	}
+}
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS archive_queue (
+    job_id       INTEGER PRIMARY KEY,
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    next_attempt BIGINT NOT NULL, -- Unix timestamp
+    last_error   TEXT,
+    FOREIGN KEY (job_id) REFERENCES job (id) ON DELETE CASCADE
+);
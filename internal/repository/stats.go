@@ -676,15 +676,17 @@ func (r *JobRepository) runningJobsMetricStatisticsHistogram(
 		avgs[i] = make([]schema.Float, 0, len(jobs))
 	}
 
+	monitored := make([]*schema.Job, 0, len(jobs))
 	for _, job := range jobs {
 		if job.MonitoringStatus == schema.MonitoringStatusDisabled || job.MonitoringStatus == schema.MonitoringStatusArchivingFailed {
 			continue
 		}
+		monitored = append(monitored, job)
+	}
 
-		if err := metricdata.LoadAverages(job, metrics, avgs, ctx); err != nil {
-			log.Errorf("Error while loading averages for histogram: %s", err)
-			return nil
-		}
+	if err := metricdata.LoadAveragesBatch(monitored, metrics, avgs, ctx); err != nil {
+		log.Errorf("Error while loading averages for histogram: %s", err)
+		return nil
 	}
 
 	// Iterate metrics to fill endresult
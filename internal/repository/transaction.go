@@ -7,6 +7,7 @@ package repository
 import (
 	"github.com/ClusterCockpit/cc-backend/pkg/log"
 	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+	sq "github.com/Masterminds/squirrel"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -79,6 +80,28 @@ func (r *JobRepository) TransactionAdd(t *Transaction, job schema.Job) (int64, e
 	return id, nil
 }
 
+// TransactionUpdate sets duration, state and the statistics footprint of
+// the job with database id jobId. The monitoring status is kept, it may
+// have been set by tools checking the archive.
+func (r *JobRepository) TransactionUpdate(t *Transaction, jobId int64, job schema.Job) error {
+	if _, err := sq.Update("job").
+		Set("duration", job.Duration).
+		Set("job_state", job.State).
+		Set("mem_used_max", job.MemUsedMax).
+		Set("flops_any_avg", job.FlopsAnyAvg).
+		Set("mem_bw_avg", job.MemBwAvg).
+		Set("load_avg", job.LoadAvg).
+		Set("net_bw_avg", job.NetBwAvg).
+		Set("file_bw_avg", job.FileBwAvg).
+		Where("job.id = ?", jobId).
+		RunWith(t.tx).Exec(); err != nil {
+		log.Errorf("Error while updating job %d: %v", jobId, err)
+		return err
+	}
+
+	return nil
+}
+
 func (r *JobRepository) TransactionAddTag(t *Transaction, tag *schema.Tag) (int64, error) {
 	res, err := t.tx.Exec(`INSERT INTO tag (tag_name, tag_type) VALUES (?, ?)`, tag.Name, tag.Type)
 	if err != nil {
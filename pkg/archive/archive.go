@@ -28,10 +28,20 @@ type ArchiveBackend interface {
 
 	LoadClusterCfg(name string) (*schema.Cluster, error)
 
+	StoreClusterCfg(name string, config *schema.Cluster) error
+
 	StoreJobMeta(jobMeta *schema.JobMeta) error
 
 	ImportJob(jobMeta *schema.JobMeta, jobData *schema.JobData) error
 
+	// Store a copy of the metric data of an already archived job that was
+	// downsampled by factor (see schema.JobData.Downsample).
+	StoreJobDataResolution(jobMeta *schema.JobMeta, factor int, jobData *schema.JobData) error
+
+	// Load the metric data downsampled by factor. Returns an error wrapping
+	// os.ErrNotExist if the job was archived without that resolution.
+	LoadJobDataResolution(job *schema.Job, factor int) (schema.JobData, error)
+
 	GetClusters() []string
 
 	CleanUp(jobs []*schema.Job)
@@ -52,6 +62,10 @@ type JobContainer struct {
 	Data *schema.JobData
 }
 
+// Downsampling factors of the reduced resolution copies of the metric data
+// stored alongside the full resolution on archiving, in ascending order.
+var Resolutions = []int{4, 16}
+
 var (
 	cache      *lrucache.Cache = lrucache.New(128 * 1024 * 1024)
 	ar         ArchiveBackend
@@ -61,32 +75,91 @@ var (
 func Init(rawConfig json.RawMessage, disableArchive bool) error {
 	useArchive = !disableArchive
 
+	var cfg struct {
+		Scopes []schema.ArchiveScopes `json:"scopes"`
+	}
+	if err := json.Unmarshal(rawConfig, &cfg); err != nil {
+		log.Warn("Error while unmarshaling raw config json")
+		return err
+	}
+	archiveScopes = cfg.Scopes
+
+	var err error
+	ar, err = InitBackend(rawConfig)
+	if err != nil {
+		return err
+	}
+
+	return initClusterConfig()
+}
+
+// InitBackend creates and initializes the archive backend described by
+// rawConfig. Unlike Init, the backend is not used as the global archive
+// handle, which allows to work with several archives at once.
+func InitBackend(rawConfig json.RawMessage) (ArchiveBackend, error) {
 	var cfg struct {
 		Kind string `json:"kind"`
 	}
 
 	if err := json.Unmarshal(rawConfig, &cfg); err != nil {
 		log.Warn("Error while unmarshaling raw config json")
-		return err
+		return nil, err
 	}
 
+	var backend ArchiveBackend
 	switch cfg.Kind {
 	case "file":
-		ar = &FsArchive{}
-		// case "s3":
-		// 	ar = &S3Archive{}
+		backend = &FsArchive{}
+	case "s3":
+		backend = &S3Archive{}
+	case "sqlite":
+		backend = &SqliteArchive{}
 	default:
-		return fmt.Errorf("ARCHIVE/ARCHIVE > unkown archive backend '%s''", cfg.Kind)
+		return nil, fmt.Errorf("ARCHIVE/ARCHIVE > unkown archive backend '%s''", cfg.Kind)
 	}
 
-	version, err := ar.Init(rawConfig)
+	version, err := backend.Init(rawConfig)
 	if err != nil {
 		log.Error("Error while initializing archiveBackend")
-		return err
+		return nil, err
 	}
 	log.Infof("Load archive version %d", version)
 
-	return initClusterConfig()
+	return backend, nil
+}
+
+// CreateBackend is like InitBackend, but first creates an empty archive of
+// the current version if rawConfig points to a location without an archive.
+// Cluster configurations can be added afterwards using StoreClusterCfg.
+func CreateBackend(rawConfig json.RawMessage) (ArchiveBackend, error) {
+	var cfg struct {
+		Kind string `json:"kind"`
+	}
+
+	if err := json.Unmarshal(rawConfig, &cfg); err != nil {
+		log.Warn("Error while unmarshaling raw config json")
+		return nil, err
+	}
+
+	// The SQLite backend creates a missing database on Init.
+	var err error
+	switch cfg.Kind {
+	case "file":
+		err = createFsArchive(rawConfig)
+	case "s3":
+		err = createS3Archive(rawConfig)
+	}
+	if err != nil {
+		log.Error("Error while creating archive")
+		return nil, err
+	}
+
+	return InitBackend(rawConfig)
+}
+
+// Name of the file or object holding the metric data downsampled by factor.
+func resolutionFile(factor int) string {
+	return fmt.Sprintf("data.%dx.json", factor)
 }
 
 func GetHandle() ArchiveBackend {
@@ -67,3 +67,79 @@ func TestCleanUp(t *testing.T) {
 // 		t.Error("Jobs still exist")
 // 	}
 // }
+
+func TestInitBackend(t *testing.T) {
+	a := setup(t)
+
+	dbPath := filepath.Join(t.TempDir(), "job-archive.db")
+	dst, err := archive.InitBackend(json.RawMessage(
+		fmt.Sprintf("{\"kind\": \"sqlite\", \"dbPath\": \"%s\"}", dbPath)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst == archive.GetHandle() {
+		t.Fatal("InitBackend replaced the global archive handle")
+	}
+
+	for job := range a.Iter(true) {
+		if err := dst.ImportJob(job.Meta, job.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !dst.Exists(jobs[0]) {
+		t.Error("Job does not exist in destination")
+	}
+
+	if _, err := archive.InitBackend(json.RawMessage("{\"kind\": \"foo\"}")); err == nil {
+		t.Error("expected error for unknown archive kind")
+	}
+}
+
+func TestCreateBackend(t *testing.T) {
+	a := setup(t)
+
+	dstPath := filepath.Join(t.TempDir(), "new-archive")
+	dstCfg := json.RawMessage(fmt.Sprintf("{\"kind\": \"file\", \"path\": \"%s\"}", dstPath))
+	if _, err := archive.InitBackend(dstCfg); err == nil {
+		t.Fatal("expected error for archive without version.txt")
+	}
+
+	dst, err := archive.CreateBackend(dstCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range a.GetClusters() {
+		cluster, err := a.LoadClusterCfg(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dst.StoreClusterCfg(name, cluster); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for job := range a.Iter(true) {
+		if err := dst.ImportJob(job.Meta, job.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Opening the created archive again must not depend on CreateBackend
+	dst, err = archive.InitBackend(dstCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clusters := dst.GetClusters(); len(clusters) != 1 || clusters[0] != "emmy" {
+		t.Fatalf("unexpected clusters %v", clusters)
+	}
+	if _, err := dst.LoadClusterCfg("emmy"); err != nil {
+		t.Fatal(err)
+	}
+	if !dst.Exists(jobs[0]) {
+		t.Error("Job does not exist in destination")
+	}
+
+	if _, err := archive.CreateBackend(json.RawMessage(
+		fmt.Sprintf("{\"kind\": \"file\", \"path\": \"%s\"}", filepath.Join(dstPath, "emmy")))); err == nil {
+		t.Error("expected error for non-empty directory without version.txt")
+	}
+}
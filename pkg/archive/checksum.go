@@ -0,0 +1,306 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/internal/util"
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+// Name of the per job file holding the content hashes of meta.json and
+// the metric data file. The format is compatible with `sha256sum -c`.
+const checksumFile = "checksum.sha256"
+
+// Verifier is implemented by archive backends that record checksums on
+// import and can check them. Only FsArchive does: S3Archive and
+// SqliteArchive store no checksums, so jobs migrated to them lose theirs
+// and those archives cannot be verified.
+type Verifier interface {
+	// Report all jobs with missing, corrupted or undecodable files.
+	Verify() <-chan JobIssue
+
+	// Move the job of issue out of the archive below target.
+	Quarantine(issue JobIssue, target string) error
+}
+
+var _ Verifier = (*FsArchive)(nil)
+
+// A job directory of the archive that failed verification.
+type JobIssue struct {
+	Path      string // Path of the job directory relative to the archive root
+	Cluster   string
+	JobID     int64
+	StartTime int64
+	Err       error
+}
+
+func fileChecksum(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Set the checksums of the files in sums in the checksum file of the job
+// directory dir, keeping the other lines. An empty checksum removes the
+// line of the file. The checksums have to be computed from the bytes
+// written, hashing the files on disk would certify corrupted files.
+func updateChecksums(dir string, sums map[string]string) error {
+	recorded, err := readChecksums(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		recorded = make(map[string]string)
+	} else if err != nil {
+		log.Errorf("Error while reading checksums of %s", dir)
+		return err
+	}
+
+	for name, sum := range sums {
+		if sum == "" {
+			delete(recorded, name)
+		} else {
+			recorded[name] = sum
+		}
+	}
+
+	names := make([]string, 0, len(recorded))
+	for name := range recorded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s  %s\n", recorded[name], name)
+	}
+
+	return os.WriteFile(filepath.Join(dir, checksumFile), []byte(b.String()), 0644)
+}
+
+// Create the file name in the job directory dir with the content written by
+// encode and record its checksum.
+func writeWithChecksum(dir, name string, encode func(w io.Writer) error) error {
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		log.Errorf("Error while creating filepath for %s", name)
+		return err
+	}
+
+	h := sha256.New()
+	if err := encode(io.MultiWriter(f, h)); err != nil {
+		f.Close()
+		log.Errorf("Error while encoding %s", name)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		log.Warnf("Error while closing %s file", name)
+		return err
+	}
+
+	return updateChecksums(dir, map[string]string{name: hex.EncodeToString(h.Sum(nil))})
+}
+
+// Compress data.json of the job directory dir to data.json.gz. data.json is
+// checked against its recorded checksum while reading, a corrupted file is
+// left uncompressed and an error is returned.
+func compressJobData(dir string) error {
+	fileIn, fileOut := filepath.Join(dir, "data.json"), filepath.Join(dir, "data.json.gz")
+	sums, err := readChecksums(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	in, err := os.Open(fileIn)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(fileOut)
+	if err != nil {
+		return err
+	}
+
+	hIn, hOut := sha256.New(), sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(out, hOut))
+	_, err = io.Copy(gz, io.TeeReader(in, hIn))
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if sum, ok := sums["data.json"]; err == nil && ok && sum != hex.EncodeToString(hIn.Sum(nil)) {
+		err = fmt.Errorf("checksum mismatch for data.json")
+	}
+	if err != nil {
+		os.Remove(fileOut)
+		return err
+	}
+
+	if err := os.Remove(fileIn); err != nil {
+		return err
+	}
+	return updateChecksums(dir, map[string]string{
+		"data.json":    "",
+		"data.json.gz": hex.EncodeToString(hOut.Sum(nil)),
+	})
+}
+
+// Returns the recorded checksums by file name or an error wrapping
+// os.ErrNotExist for jobs archived without checksums.
+func readChecksums(dir string) (map[string]string, error) {
+	f, err := os.Open(filepath.Join(dir, checksumFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		sum, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			return nil, fmt.Errorf("malformed line in %s: %q", checksumFile, scanner.Text())
+		}
+		sums[name] = sum
+	}
+
+	return sums, scanner.Err()
+}
+
+// Check the files of a single job directory. The metric data is decoded
+// directly, bypassing the cache used by DecodeJobData.
+func verifyJobDir(dir string) error {
+	sums, err := readChecksums(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for name, sum := range sums {
+		actual, err := fileChecksum(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if actual != sum {
+			return fmt.Errorf("checksum mismatch for %s", name)
+		}
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "meta.json"))
+	if err != nil {
+		return err
+	}
+	var meta schema.JobMeta
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return fmt.Errorf("decode meta.json: %w", err)
+	}
+
+	name := "data.json.gz"
+	if !util.CheckFileExists(filepath.Join(dir, name)) {
+		name = "data.json"
+	}
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if name == "data.json.gz" {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("decode %s: %w", name, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var data schema.JobData
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return fmt.Errorf("decode %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// Verify walks all job directories of the archive and reports jobs with
+// missing, truncated, corrupted or undecodable files. Jobs archived
+// without checksum file are only checked for decodability.
+func (fsa *FsArchive) Verify() <-chan JobIssue {
+	ch := make(chan JobIssue)
+	go func() {
+		defer close(ch)
+
+		for _, cluster := range fsa.clusters {
+			dirs := make(chan string, 64)
+			go func(cluster string) {
+				walkJobDirs(fsa.path, cluster, dirs)
+				close(dirs)
+			}(cluster)
+
+			for dir := range dirs {
+				if err := verifyJobDir(dir); err != nil {
+					jobID, startTime, _ := parseJobDir(dir)
+					rel, _ := filepath.Rel(fsa.path, dir)
+					ch <- JobIssue{
+						Path:      rel,
+						Cluster:   cluster,
+						JobID:     jobID,
+						StartTime: startTime,
+						Err:       err,
+					}
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// Quarantine moves the job directory of issue below the directory target,
+// keeping its path relative to the archive root.
+func (fsa *FsArchive) Quarantine(issue JobIssue, target string) error {
+	dst := filepath.Join(target, issue.Path)
+	if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+		log.Error("Error while creating quarantine directory")
+		return err
+	}
+
+	if err := os.Rename(filepath.Join(fsa.path, issue.Path), dst); err != nil {
+		log.Errorf("Error while moving %s to quarantine", issue.Path)
+		return err
+	}
+
+	job := &schema.Job{}
+	job.JobID = issue.JobID
+	job.Cluster = issue.Cluster
+	job.StartTime = time.Unix(issue.StartTime, 0)
+	if err := fsa.index.remove(job); err != nil {
+		log.Errorf("Error while removing %s from archive index", issue.Path)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,218 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/internal/util"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+func setupChecksum(t *testing.T) *FsArchive {
+	jobarchive := filepath.Join(t.TempDir(), "job-archive")
+	if err := util.CopyDir("./testdata/archive/", jobarchive); err != nil {
+		t.Fatal(err)
+	}
+
+	var fsa FsArchive
+	if _, err := fsa.Init(json.RawMessage(fmt.Sprintf("{\"path\":\"%s\"}", jobarchive))); err != nil {
+		t.Fatal(err)
+	}
+
+	return &fsa
+}
+
+func importChecksumJob(t *testing.T, fsa *FsArchive) *schema.Job {
+	jobIn := schema.Job{BaseJob: schema.JobDefaults}
+	jobIn.StartTime = time.Unix(1608923076, 0)
+	jobIn.JobID = 1403244
+	jobIn.Cluster = "emmy"
+
+	jobMeta, err := fsa.LoadJobMeta(&jobIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobData, err := fsa.LoadJobData(&jobIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobMeta.JobID = 42
+	if err := fsa.ImportJob(jobMeta, &jobData); err != nil {
+		t.Fatal(err)
+	}
+
+	return &schema.Job{BaseJob: jobMeta.BaseJob, StartTime: time.Unix(jobMeta.StartTime, 0)}
+}
+
+func collectIssues(fsa *FsArchive) []JobIssue {
+	issues := make([]JobIssue, 0)
+	for issue := range fsa.Verify() {
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+func TestVerifyClean(t *testing.T) {
+	fsa := setupChecksum(t)
+	job := importChecksumJob(t, fsa)
+
+	sums, err := readChecksums(getDirectory(job, fsa.path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sums["meta.json"]; !ok {
+		t.Fatal("missing checksum for meta.json")
+	}
+	if _, ok := sums["data.json"]; !ok {
+		t.Fatal("missing checksum for data.json")
+	}
+
+	if issues := collectIssues(fsa); len(issues) != 0 {
+		t.Fatalf("unexpected issues %v", issues)
+	}
+
+	fsa.Compress([]*schema.Job{job})
+	sums, err = readChecksums(getDirectory(job, fsa.path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sums["data.json.gz"]; !ok {
+		t.Fatal("missing checksum for data.json.gz after compression")
+	}
+	if issues := collectIssues(fsa); len(issues) != 0 {
+		t.Fatalf("unexpected issues after compression %v", issues)
+	}
+}
+
+func TestVerifyTruncated(t *testing.T) {
+	fsa := setupChecksum(t)
+	job := importChecksumJob(t, fsa)
+
+	if err := os.Truncate(getPath(job, fsa.path, "data.json"), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	issues := collectIssues(fsa)
+	if len(issues) != 1 {
+		t.Fatalf("expected 1 issue, got %d", len(issues))
+	}
+	if issues[0].JobID != 42 || issues[0].Cluster != "emmy" || issues[0].StartTime != 1608923076 {
+		t.Fatalf("unexpected issue %v", issues[0])
+	}
+
+	target := t.TempDir()
+	if err := fsa.Quarantine(issues[0], target); err != nil {
+		t.Fatal(err)
+	}
+	if fsa.Exists(job) {
+		t.Fatal("job still exists after quarantine")
+	}
+	if !util.CheckFileExists(filepath.Join(target, issues[0].Path, "meta.json")) {
+		t.Fatal("job missing in quarantine")
+	}
+}
+
+func TestVerifyCorruptedAfterUpdate(t *testing.T) {
+	fsa := setupChecksum(t)
+	job := importChecksumJob(t, fsa)
+
+	// Corrupt data.json without changing its size
+	filename := getPath(job, fsa.path, "data.json")
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)/2] ^= 0xff
+	if err := os.WriteFile(filename, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Updating the metadata and compressing must not certify the corrupted file
+	jobMeta, err := fsa.LoadJobMeta(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobMeta.MetaData = map[string]string{"note": "tagged"}
+	if err := fsa.StoreJobMeta(jobMeta); err != nil {
+		t.Fatal(err)
+	}
+	fsa.Compress([]*schema.Job{job})
+	if util.CheckFileExists(getPath(job, fsa.path, "data.json.gz")) || !util.CheckFileExists(filename) {
+		t.Fatal("expected corrupted data.json to be left uncompressed")
+	}
+
+	issues := collectIssues(fsa)
+	if len(issues) != 1 || issues[0].JobID != 42 || !strings.Contains(issues[0].Err.Error(), "data.json") {
+		t.Fatalf("unexpected issues %v", issues)
+	}
+}
+
+func TestVerifyUndecodable(t *testing.T) {
+	fsa := setupChecksum(t)
+
+	job := schema.Job{}
+	job.JobID = 1404397
+	job.Cluster = "emmy"
+	job.StartTime = time.Unix(1609300556, 0)
+	if err := os.WriteFile(getPath(&job, fsa.path, "meta.json"), []byte("{\"jobId\":"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	issues := collectIssues(fsa)
+	if len(issues) != 1 || issues[0].JobID != 1404397 {
+		t.Fatalf("unexpected issues %v", issues)
+	}
+}
+
+func TestStoreJobDataResolution(t *testing.T) {
+	fsa := setupChecksum(t)
+	job := importChecksumJob(t, fsa)
+
+	if _, err := fsa.LoadJobDataResolution(job, 4); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing resolution, got %v", err)
+	}
+
+	jobMeta, err := fsa.LoadJobMeta(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobData, err := fsa.LoadJobData(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reduced := jobData.Downsample(4)
+	if err := fsa.StoreJobDataResolution(jobMeta, 4, &reduced); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fsa.LoadJobDataResolution(job, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jm := data["mem_used"][schema.MetricScopeNode]
+	if jm.Timestep != 4*jobData["mem_used"][schema.MetricScopeNode].Timestep {
+		t.Fatalf("unexpected timestep %d", jm.Timestep)
+	}
+
+	sums, err := readChecksums(getDirectory(job, fsa.path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sums["data.4x.json"]; !ok {
+		t.Fatal("missing checksum for data.4x.json")
+	}
+	if issues := collectIssues(fsa); len(issues) != 0 {
+		t.Fatalf("unexpected issues %v", issues)
+	}
+}
@@ -11,12 +11,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
-	"path"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"text/tabwriter"
 	"time"
 
@@ -28,12 +30,17 @@ import (
 )
 
 type FsArchiveConfig struct {
-	Path string `json:"path"`
+	Path         string `json:"path"`
+	Workers      int    `json:"workers"`
+	UseIndex     bool   `json:"useIndex"`
+	RebuildIndex bool   `json:"rebuildIndex"`
 }
 
 type FsArchive struct {
 	path     string
+	workers  int
 	clusters []string
+	index    *fsIndex
 }
 
 type clusterInfo struct {
@@ -115,6 +122,38 @@ func loadJobData(filename string, isCompressed bool) (schema.JobData, error) {
 	}
 }
 
+// Write a version.txt to the archive directory unless there is one already.
+// Directories with other content are refused.
+func createFsArchive(rawConfig json.RawMessage) error {
+	var config FsArchiveConfig
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		log.Warnf("createFsArchive() > Unmarshal error: %#v", err)
+		return err
+	}
+	if config.Path == "" {
+		return fmt.Errorf("createFsArchive() : empty config.Path")
+	}
+
+	filename := filepath.Join(config.Path, "version.txt")
+	if util.CheckFileExists(filename) {
+		return nil
+	}
+
+	entries, err := os.ReadDir(config.Path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("ARCHIVE/FSBACKEND > %s is not empty but has no version.txt", config.Path)
+	}
+
+	if err := os.MkdirAll(config.Path, 0777); err != nil {
+		log.Error("Error while creating archive directory")
+		return err
+	}
+	return os.WriteFile(filename, []byte(fmt.Sprintf("%d\n", Version)), 0666)
+}
+
 func (fsa *FsArchive) Init(rawConfig json.RawMessage) (uint64, error) {
 
 	var config FsArchiveConfig
@@ -128,6 +167,10 @@ func (fsa *FsArchive) Init(rawConfig json.RawMessage) (uint64, error) {
 		return 0, err
 	}
 	fsa.path = config.Path
+	fsa.workers = config.Workers
+	if fsa.workers <= 0 {
+		fsa.workers = runtime.NumCPU()
+	}
 
 	b, err := os.ReadFile(filepath.Join(fsa.path, "version.txt"))
 	if err != nil {
@@ -158,6 +201,12 @@ func (fsa *FsArchive) Init(rawConfig json.RawMessage) (uint64, error) {
 		fsa.clusters = append(fsa.clusters, de.Name())
 	}
 
+	if config.UseIndex {
+		if fsa.index, err = loadIndex(fsa.path, fsa.clusters, config.RebuildIndex); err != nil {
+			return 0, err
+		}
+	}
+
 	return version, nil
 }
 
@@ -171,6 +220,11 @@ func (fsa *FsArchive) Info() {
 	ci := make(map[string]*clusterInfo)
 
 	for _, cluster := range clusters {
+		if cluster.IsDir() && fsa.index != nil {
+			ci[cluster.Name()] = fsa.index.info(cluster.Name())
+			continue
+		}
+
 		if !cluster.IsDir() {
 			continue
 		}
@@ -247,6 +301,19 @@ func (fsa *FsArchive) Clean(before int64, after int64) {
 		after = math.MaxInt64
 	}
 
+	if fsa.index != nil {
+		jobs := make([]*schema.Job, 0)
+		for _, cluster := range fsa.index.clusterNames() {
+			for _, e := range fsa.index.entries(cluster) {
+				if e.startTime < before || e.startTime > after {
+					jobs = append(jobs, e.job(cluster))
+				}
+			}
+		}
+		fsa.CleanUp(jobs)
+		return
+	}
+
 	clusters, err := os.ReadDir(fsa.path)
 	if err != nil {
 		log.Fatalf("Reading clusters failed: %s", err.Error())
@@ -310,7 +377,8 @@ func (fsa *FsArchive) Move(jobs []*schema.Job, path string) {
 		if err := os.MkdirAll(filepath.Clean(filepath.Join(target, "..")), 0777); err != nil {
 			log.Errorf("JobArchive Move MkDir error: %v", err)
 		}
-		if err := os.Rename(source, target); err != nil {
+		err := os.Rename(source, target)
+		if err != nil {
 			log.Errorf("JobArchive Move() error: %v", err)
 		}
 
@@ -320,6 +388,13 @@ func (fsa *FsArchive) Move(jobs []*schema.Job, path string) {
 				log.Errorf("JobArchive Move() error: %v", err)
 			}
 		}
+
+		// Update the index after the directories, see fsIndex.stale
+		if err == nil {
+			if err := fsa.index.remove(job); err != nil {
+				log.Errorf("JobArchive Move() index error: %v", err)
+			}
+		}
 	}
 }
 
@@ -327,7 +402,8 @@ func (fsa *FsArchive) CleanUp(jobs []*schema.Job) {
 	start := time.Now()
 	for _, job := range jobs {
 		dir := getDirectory(job, fsa.path)
-		if err := os.RemoveAll(dir); err != nil {
+		err := os.RemoveAll(dir)
+		if err != nil {
 			log.Errorf("JobArchive Cleanup() error: %v", err)
 		}
 
@@ -337,6 +413,13 @@ func (fsa *FsArchive) CleanUp(jobs []*schema.Job) {
 				log.Errorf("JobArchive Cleanup() error: %v", err)
 			}
 		}
+
+		// Update the index after the directories, see fsIndex.stale
+		if err == nil {
+			if err := fsa.index.remove(job); err != nil {
+				log.Errorf("JobArchive Cleanup() index error: %v", err)
+			}
+		}
 	}
 
 	log.Infof("Retention Service - Remove %d files in %s", len(jobs), time.Since(start))
@@ -347,9 +430,21 @@ func (fsa *FsArchive) Compress(jobs []*schema.Job) {
 	start := time.Now()
 
 	for _, job := range jobs {
+		if fsa.index != nil {
+			if e, ok := fsa.index.lookup(job); ok && e.compressed {
+				continue
+			}
+		}
+
 		fileIn := getPath(job, fsa.path, "data.json")
 		if util.CheckFileExists(fileIn) && util.GetFilesize(fileIn) > 2000 {
-			util.CompressFile(fileIn, getPath(job, fsa.path, "data.json.gz"))
+			if err := compressJobData(getDirectory(job, fsa.path)); err != nil {
+				log.Errorf("JobArchive Compress() error for %s: %v", fileIn, err)
+				continue
+			}
+			if err := fsa.index.update(job, getDirectory(job, fsa.path)); err != nil {
+				log.Errorf("JobArchive Compress() index error: %v", err)
+			}
 			cnt++
 		}
 	}
@@ -389,6 +484,15 @@ func (fsa *FsArchive) LoadJobData(job *schema.Job) (schema.JobData, error) {
 	return loadJobData(filename, isCompressed)
 }
 
+func (fsa *FsArchive) LoadJobDataResolution(job *schema.Job, factor int) (schema.JobData, error) {
+	filename := getPath(job, fsa.path, resolutionFile(factor))
+	if !util.CheckFileExists(filename) {
+		return nil, fmt.Errorf("ARCHIVE/FSBACKEND > no resolution %dx for job %d: %w", factor, job.JobID, os.ErrNotExist)
+	}
+
+	return loadJobData(filename, false)
+}
+
 func (fsa *FsArchive) LoadJobMeta(job *schema.Job) (*schema.JobMeta, error) {
 	filename := getPath(job, fsa.path, "meta.json")
 	return loadJobMeta(filename)
@@ -409,10 +513,110 @@ func (fsa *FsArchive) LoadClusterCfg(name string) (*schema.Cluster, error) {
 	return DecodeCluster(bytes.NewReader(b))
 }
 
-func (fsa *FsArchive) Iter(loadMetricData bool) <-chan JobContainer {
+func (fsa *FsArchive) StoreClusterCfg(name string, config *schema.Cluster) error {
+	dir := filepath.Join(fsa.path, name)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		log.Error("Error while creating cluster directory")
+		return err
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		log.Error("Error while encoding cluster config")
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cluster.json"), b, 0666); err != nil {
+		log.Error("Error while writing cluster.json file")
+		return err
+	}
+
+	if !util.Contains(fsa.clusters, name) {
+		fsa.clusters = append(fsa.clusters, name)
+	}
+	return nil
+}
+
+// Send the directories of all jobs of cluster found below root to dirs.
+func walkJobDirs(root string, cluster string, dirs chan<- string) {
+	lvl1Dirs, err := os.ReadDir(filepath.Join(root, cluster))
+	if err != nil {
+		log.Fatalf("Reading jobs failed @ lvl1 dirs: %s", err.Error())
+	}
+
+	for _, lvl1Dir := range lvl1Dirs {
+		if !lvl1Dir.IsDir() {
+			// Could be the cluster.json file
+			continue
+		}
+
+		walkLvl1Dir(filepath.Join(root, cluster, lvl1Dir.Name()), dirs)
+	}
+}
+
+// Send the directories of all jobs below the first level directory lvl1Dir
+// of a cluster to dirs.
+func walkLvl1Dir(lvl1Dir string, dirs chan<- string) {
+	lvl2Dirs, err := os.ReadDir(lvl1Dir)
+	if err != nil {
+		log.Fatalf("Reading jobs failed @ lvl2 dirs: %s", err.Error())
+	}
 
+	for _, lvl2Dir := range lvl2Dirs {
+		dirpath := filepath.Join(lvl1Dir, lvl2Dir.Name())
+		startTimeDirs, err := os.ReadDir(dirpath)
+		if err != nil {
+			log.Fatalf("Reading jobs failed @ starttime dirs: %s", err.Error())
+		}
+
+		for _, startTimeDir := range startTimeDirs {
+			if startTimeDir.IsDir() {
+				dirs <- filepath.Join(dirpath, startTimeDir.Name())
+			}
+		}
+	}
+}
+
+func loadJobContainer(dir string, loadMetricData bool) JobContainer {
+	job, err := loadJobMeta(filepath.Join(dir, "meta.json"))
+	if err != nil && !errors.Is(err, &jsonschema.ValidationError{}) {
+		log.Errorf("in %s: %s", dir, err.Error())
+	}
+
+	if !loadMetricData {
+		return JobContainer{Meta: job, Data: nil}
+	}
+
+	var isCompressed bool = true
+	filename := filepath.Join(dir, "data.json.gz")
+
+	if !util.CheckFileExists(filename) {
+		filename = filepath.Join(dir, "data.json")
+		isCompressed = false
+	}
+
+	data, err := loadJobData(filename, isCompressed)
+	if err != nil && !errors.Is(err, &jsonschema.ValidationError{}) {
+		log.Errorf("in %s: %s", dir, err.Error())
+	}
+	return JobContainer{Meta: job, Data: &data}
+}
+
+// Iter walks the first level directories of all clusters in parallel and
+// loads the jobs found using a pool of workers. Clusters with index are
+// listed from the index instead. The order of the jobs is only
+// deterministic for a single worker.
+func (fsa *FsArchive) Iter(loadMetricData bool) <-chan JobContainer {
 	ch := make(chan JobContainer)
+	lvl1Dirs := make(chan string, 64)
+	dirs := make(chan string, 64)
+	workers := util.Max(fsa.workers, 1)
+
+	var walkers sync.WaitGroup
+	walkers.Add(1)
 	go func() {
+		defer walkers.Done()
+		defer close(lvl1Dirs)
+
 		clustersDir, err := os.ReadDir(fsa.path)
 		if err != nil {
 			log.Fatalf("Reading clusters failed @ cluster dirs: %s", err.Error())
@@ -422,59 +626,56 @@ func (fsa *FsArchive) Iter(loadMetricData bool) <-chan JobContainer {
 			if !clusterDir.IsDir() {
 				continue
 			}
-			lvl1Dirs, err := os.ReadDir(filepath.Join(fsa.path, clusterDir.Name()))
-			if err != nil {
-				log.Fatalf("Reading jobs failed @ lvl1 dirs: %s", err.Error())
-			}
 
-			for _, lvl1Dir := range lvl1Dirs {
-				if !lvl1Dir.IsDir() {
-					// Could be the cluster.json file
-					continue
+			cluster := clusterDir.Name()
+			if fsa.index != nil {
+				for _, e := range fsa.index.entries(cluster) {
+					dirs <- getDirectory(e.job(cluster), fsa.path)
 				}
+				continue
+			}
 
-				lvl2Dirs, err := os.ReadDir(filepath.Join(fsa.path, clusterDir.Name(), lvl1Dir.Name()))
-				if err != nil {
-					log.Fatalf("Reading jobs failed @ lvl2 dirs: %s", err.Error())
+			entries, err := os.ReadDir(filepath.Join(fsa.path, cluster))
+			if err != nil {
+				log.Fatalf("Reading jobs failed @ lvl1 dirs: %s", err.Error())
+			}
+			for _, lvl1Dir := range entries {
+				// Skip files like cluster.json
+				if lvl1Dir.IsDir() {
+					lvl1Dirs <- filepath.Join(fsa.path, cluster, lvl1Dir.Name())
 				}
+			}
+		}
+	}()
 
-				for _, lvl2Dir := range lvl2Dirs {
-					dirpath := filepath.Join(fsa.path, clusterDir.Name(), lvl1Dir.Name(), lvl2Dir.Name())
-					startTimeDirs, err := os.ReadDir(dirpath)
-					if err != nil {
-						log.Fatalf("Reading jobs failed @ starttime dirs: %s", err.Error())
-					}
+	for i := 0; i < workers; i++ {
+		walkers.Add(1)
+		go func() {
+			defer walkers.Done()
+			for lvl1Dir := range lvl1Dirs {
+				walkLvl1Dir(lvl1Dir, dirs)
+			}
+		}()
+	}
 
-					for _, startTimeDir := range startTimeDirs {
-						if startTimeDir.IsDir() {
-							job, err := loadJobMeta(filepath.Join(dirpath, startTimeDir.Name(), "meta.json"))
-							if err != nil && !errors.Is(err, &jsonschema.ValidationError{}) {
-								log.Errorf("in %s: %s", filepath.Join(dirpath, startTimeDir.Name()), err.Error())
-							}
+	go func() {
+		walkers.Wait()
+		close(dirs)
+	}()
 
-							if loadMetricData {
-								var isCompressed bool = true
-								filename := filepath.Join(dirpath, startTimeDir.Name(), "data.json.gz")
-
-								if !util.CheckFileExists(filename) {
-									filename = filepath.Join(dirpath, startTimeDir.Name(), "data.json")
-									isCompressed = false
-								}
-
-								data, err := loadJobData(filename, isCompressed)
-								if err != nil && !errors.Is(err, &jsonschema.ValidationError{}) {
-									log.Errorf("in %s: %s", filepath.Join(dirpath, startTimeDir.Name()), err.Error())
-								}
-								ch <- JobContainer{Meta: job, Data: &data}
-								log.Errorf("in %s: %s", filepath.Join(dirpath, startTimeDir.Name()), err.Error())
-							} else {
-								ch <- JobContainer{Meta: job, Data: nil}
-							}
-						}
-					}
-				}
+	var loaders sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		loaders.Add(1)
+		go func() {
+			defer loaders.Done()
+			for dir := range dirs {
+				ch <- loadJobContainer(dir, loadMetricData)
 			}
-		}
+		}()
+	}
+
+	go func() {
+		loaders.Wait()
 		close(ch)
 	}()
 	return ch
@@ -487,17 +688,44 @@ func (fsa *FsArchive) StoreJobMeta(jobMeta *schema.JobMeta) error {
 		StartTime:     time.Unix(jobMeta.StartTime, 0),
 		StartTimeUnix: jobMeta.StartTime,
 	}
-	f, err := os.Create(getPath(&job, fsa.path, "meta.json"))
+	err := writeWithChecksum(getDirectory(&job, fsa.path), "meta.json", func(w io.Writer) error {
+		return EncodeJobMeta(w, jobMeta)
+	})
 	if err != nil {
-		log.Error("Error while creating filepath for meta.json")
+		log.Error("Error while writing meta.json file")
 		return err
 	}
-	if err := EncodeJobMeta(f, jobMeta); err != nil {
-		log.Error("Error while encoding job metadata to meta.json file")
+
+	if err := fsa.index.update(&job, getDirectory(&job, fsa.path)); err != nil {
+		log.Error("Error while updating archive index")
 		return err
 	}
-	if err := f.Close(); err != nil {
-		log.Warn("Error while closing meta.json file")
+
+	return nil
+}
+
+func (fsa *FsArchive) StoreJobDataResolution(
+	jobMeta *schema.JobMeta,
+	factor int,
+	jobData *schema.JobData) error {
+
+	job := schema.Job{
+		BaseJob:       jobMeta.BaseJob,
+		StartTime:     time.Unix(jobMeta.StartTime, 0),
+		StartTimeUnix: jobMeta.StartTime,
+	}
+	dir := getDirectory(&job, fsa.path)
+
+	err := writeWithChecksum(dir, resolutionFile(factor), func(w io.Writer) error {
+		return EncodeJobData(w, jobData)
+	})
+	if err != nil {
+		log.Errorf("Error while writing %s file", resolutionFile(factor))
+		return err
+	}
+
+	if err := fsa.index.update(&job, dir); err != nil {
+		log.Error("Error while updating archive index")
 		return err
 	}
 
@@ -523,17 +751,11 @@ func (fsa *FsArchive) ImportJob(
 		return err
 	}
 
-	f, err := os.Create(path.Join(dir, "meta.json"))
+	err := writeWithChecksum(dir, "meta.json", func(w io.Writer) error {
+		return EncodeJobMeta(w, jobMeta)
+	})
 	if err != nil {
-		log.Error("Error while creating filepath for meta.json")
-		return err
-	}
-	if err := EncodeJobMeta(f, jobMeta); err != nil {
-		log.Error("Error while encoding job metadata to meta.json file")
-		return err
-	}
-	if err := f.Close(); err != nil {
-		log.Warn("Error while closing meta.json file")
+		log.Error("Error while writing meta.json file")
 		return err
 	}
 
@@ -559,17 +781,17 @@ func (fsa *FsArchive) ImportJob(
 	// 	}
 	// }
 
-	f, err = os.Create(path.Join(dir, "data.json"))
+	err = writeWithChecksum(dir, "data.json", func(w io.Writer) error {
+		return EncodeJobData(w, jobData)
+	})
 	if err != nil {
-		log.Error("Error while creating filepath for data.json")
+		log.Error("Error while writing data.json file")
 		return err
 	}
-	if err := EncodeJobData(f, jobData); err != nil {
-		log.Error("Error while encoding job metricdata to data.json file")
+
+	if err := fsa.index.update(&job, dir); err != nil {
+		log.Error("Error while updating archive index")
 		return err
 	}
-	if err := f.Close(); err != nil {
-		log.Warn("Error while closing data.json file")
-	}
-	return err
+	return nil
 }
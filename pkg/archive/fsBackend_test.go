@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 
@@ -53,6 +54,9 @@ func TestInit(t *testing.T) {
 	if len(fsa.clusters) != 1 || fsa.clusters[0] != "emmy" {
 		t.Fail()
 	}
+	if fsa.workers != runtime.NumCPU() {
+		t.Fatalf("unexpected default workers %d", fsa.workers)
+	}
 }
 
 func TestLoadJobMetaInternal(t *testing.T) {
@@ -209,3 +213,34 @@ func TestIter(t *testing.T) {
 		}
 	}
 }
+
+func TestIterParallel(t *testing.T) {
+	// A second cluster with copies of the jobs of emmy
+	jobarchive := filepath.Join(t.TempDir(), "job-archive")
+	if err := util.CopyDir("./testdata/archive/", jobarchive); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.CopyDir(filepath.Join(jobarchive, "emmy"), filepath.Join(jobarchive, "copy")); err != nil {
+		t.Fatal(err)
+	}
+
+	var fsa FsArchive
+	_, err := fsa.Init(json.RawMessage(fmt.Sprintf("{\"path\":\"%s\", \"workers\": 4}", jobarchive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fsa.workers != 4 {
+		t.Fatalf("unexpected workers %d", fsa.workers)
+	}
+
+	seen := make(map[int64]int)
+	for job := range fsa.Iter(true) {
+		if job.Data == nil || len(*job.Data) == 0 {
+			t.Fail()
+		}
+		seen[job.Meta.JobID]++
+	}
+	if len(seen) != 2 || seen[1403244] != 2 || seen[1404397] != 2 {
+		t.Fatalf("unexpected jobs %v", seen)
+	}
+}
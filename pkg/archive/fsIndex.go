@@ -0,0 +1,421 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package archive
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/internal/util"
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+// Name of the per cluster index file of the file archive
+const indexFile = "index.txt"
+
+type indexKey struct {
+	jobID     int64
+	startTime int64
+}
+
+type indexEntry struct {
+	jobID      int64
+	startTime  int64
+	compressed bool
+	size       int64 // Size of all files of the job in bytes
+}
+
+// Persistent per cluster index of all jobs in a file archive. Every change
+// is appended as a line to <cluster>/index.txt, either
+// "+ <jobId> <startTime> <compressed> <size>" for an added or updated job
+// or "- <jobId> <startTime>" for a removed job. The log is replayed and compacted when the index is loaded. If the file
+// is missing or stale, the index of the cluster is rebuilt from the directory tree.
+//
+// Staleness is detected from modification times (see stale). A directory
+// modified in the same timestamp as the index file counts as newer, as on
+// filesystems with coarse timestamps (e.g. 1 s) the order cannot be told.
+// This may cause a needless rebuild. Changes within existing job
+// directories are not detected, use the rebuildIndex option after
+// modifying the archive by other means.
+type fsIndex struct {
+	mu       sync.Mutex
+	root     string
+	clusters map[string]map[indexKey]indexEntry
+}
+
+// Load the index of all clusters. If rebuild is set, the index files are
+// ignored and the index is rebuilt from the directory tree.
+func loadIndex(root string, clusters []string, rebuild bool) (*fsIndex, error) {
+	idx := &fsIndex{
+		root:     root,
+		clusters: make(map[string]map[indexKey]indexEntry),
+	}
+
+	for _, cluster := range clusters {
+		var err error
+		if rebuild {
+			err = idx.buildCluster(cluster)
+		} else {
+			err = idx.loadCluster(cluster)
+		}
+		if err != nil {
+			log.Errorf("Error while loading archive index of cluster %s", cluster)
+			return nil, err
+		}
+	}
+
+	return idx, nil
+}
+
+func (e indexEntry) job(cluster string) *schema.Job {
+	job := &schema.Job{}
+	job.JobID = e.jobID
+	job.Cluster = cluster
+	job.StartTime = time.Unix(e.startTime, 0)
+	job.StartTimeUnix = e.startTime
+	return job
+}
+
+func newIndexEntry(job *schema.Job, dir string) (indexEntry, error) {
+	e := indexEntry{jobID: job.JobID, startTime: job.StartTime.Unix()}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return e, err
+	}
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil {
+			return e, err
+		}
+		e.size += info.Size()
+		if file.Name() == "data.json.gz" {
+			e.compressed = true
+		}
+	}
+
+	return e, nil
+}
+
+// Get job id and start time from a job directory path of the archive.
+func parseJobDir(dir string) (jobID int64, startTime int64, err error) {
+	parts := strings.Split(filepath.ToSlash(dir), "/")
+	if len(parts) < 3 {
+		return 0, 0, fmt.Errorf("ARCHIVE/FSINDEX > invalid job directory %s", dir)
+	}
+
+	lvl1, err := strconv.ParseInt(parts[len(parts)-3], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	lvl2, err := strconv.ParseInt(parts[len(parts)-2], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	startTime, err = strconv.ParseInt(parts[len(parts)-1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return lvl1*1000 + lvl2, startTime, nil
+}
+
+// Reports whether jobs of cluster were added or removed after, or in the
+// same timestamp as, the index file was last written at modTime, e.g. by an
+// instance running without index.
+// Adding or removing a job directory updates the modification time of its
+// parent, so only the first two directory levels need to be checked.
+// Changes within existing job directories are not detected.
+func (idx *fsIndex) stale(cluster string, modTime time.Time) (bool, error) {
+	root := filepath.Join(idx.root, cluster)
+	lvl1Dirs, err := os.ReadDir(root)
+	if err != nil {
+		return false, err
+	}
+
+	for _, lvl1Dir := range lvl1Dirs {
+		if !lvl1Dir.IsDir() {
+			continue
+		}
+
+		info, err := lvl1Dir.Info()
+		if err != nil {
+			return false, err
+		}
+		if !info.ModTime().Before(modTime) {
+			return true, nil
+		}
+
+		lvl2Dirs, err := os.ReadDir(filepath.Join(root, lvl1Dir.Name()))
+		if err != nil {
+			return false, err
+		}
+		for _, lvl2Dir := range lvl2Dirs {
+			info, err := lvl2Dir.Info()
+			if err != nil {
+				return false, err
+			}
+			if lvl2Dir.IsDir() && !info.ModTime().Before(modTime) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func (idx *fsIndex) loadCluster(cluster string) error {
+	f, err := os.Open(filepath.Join(idx.root, cluster, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return idx.buildCluster(cluster)
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if stale, err := idx.stale(cluster, info.ModTime()); err != nil {
+		return err
+	} else if stale {
+		log.Warnf("Archive index of cluster %s is outdated", cluster)
+		return idx.buildCluster(cluster)
+	}
+
+	entries := make(map[indexKey]indexEntry)
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			log.Warnf("Skip malformed line %d in archive index of cluster %s", lines, cluster)
+			continue
+		}
+
+		var e indexEntry
+		e.jobID, err = strconv.ParseInt(fields[1], 10, 64)
+		if err == nil {
+			e.startTime, err = strconv.ParseInt(fields[2], 10, 64)
+		}
+		if err == nil && fields[0] == "+" {
+			if len(fields) != 5 {
+				err = fmt.Errorf("wrong number of fields")
+			} else {
+				e.compressed = fields[3] == "1"
+				e.size, err = strconv.ParseInt(fields[4], 10, 64)
+			}
+		}
+		if err != nil {
+			log.Warnf("Skip malformed line %d in archive index of cluster %s", lines, cluster)
+			continue
+		}
+
+		switch fields[0] {
+		case "+":
+			entries[indexKey{e.jobID, e.startTime}] = e
+		case "-":
+			delete(entries, indexKey{e.jobID, e.startTime})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	idx.clusters[cluster] = entries
+	if lines > len(entries) {
+		return idx.writeCluster(cluster)
+	}
+	return nil
+}
+
+func (idx *fsIndex) buildCluster(cluster string) error {
+	log.Infof("Building archive index of cluster %s", cluster)
+	entries := make(map[indexKey]indexEntry)
+
+	dirs := make(chan string, 64)
+	go func() {
+		walkJobDirs(idx.root, cluster, dirs)
+		close(dirs)
+	}()
+
+	for dir := range dirs {
+		jobID, startTime, err := parseJobDir(dir)
+		if err != nil {
+			log.Warnf("Skip job directory %s in archive index: %v", dir, err)
+			continue
+		}
+
+		job := &schema.Job{}
+		job.JobID = jobID
+		job.StartTime = time.Unix(startTime, 0)
+		e, err := newIndexEntry(job, dir)
+		if err != nil {
+			log.Warnf("Skip job directory %s in archive index: %v", dir, err)
+			continue
+		}
+		entries[indexKey{e.jobID, e.startTime}] = e
+	}
+
+	idx.clusters[cluster] = entries
+	return idx.writeCluster(cluster)
+}
+
+// Write the compacted index of cluster. The file is replaced atomically.
+func (idx *fsIndex) writeCluster(cluster string) error {
+	filename := filepath.Join(idx.root, cluster, indexFile)
+	f, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, e := range idx.sorted(cluster) {
+		w.WriteString(e.String())
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(filename+".tmp", filename)
+}
+
+func (e indexEntry) String() string {
+	compressed := 0
+	if e.compressed {
+		compressed = 1
+	}
+	return fmt.Sprintf("+ %d %d %d %d\n", e.jobID, e.startTime, compressed, e.size)
+}
+
+func (idx *fsIndex) appendLine(cluster string, line string) error {
+	dir := filepath.Join(idx.root, cluster)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, indexFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Add or update the entry of job from the files in its directory dir.
+// Does nothing if the index is disabled.
+func (idx *fsIndex) update(job *schema.Job, dir string) error {
+	if idx == nil {
+		return nil
+	}
+
+	e, err := newIndexEntry(job, dir)
+	if err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	entries, ok := idx.clusters[job.Cluster]
+	if !ok {
+		entries = make(map[indexKey]indexEntry)
+		idx.clusters[job.Cluster] = entries
+	}
+	entries[indexKey{e.jobID, e.startTime}] = e
+
+	return idx.appendLine(job.Cluster, e.String())
+}
+
+// Remove the entry of job. Does nothing if the index is disabled.
+func (idx *fsIndex) remove(job *schema.Job) error {
+	if idx == nil {
+		return nil
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	key := indexKey{job.JobID, job.StartTime.Unix()}
+	if _, ok := idx.clusters[job.Cluster][key]; !ok {
+		return nil
+	}
+	delete(idx.clusters[job.Cluster], key)
+
+	return idx.appendLine(job.Cluster, fmt.Sprintf("- %d %d\n", key.jobID, key.startTime))
+}
+
+func (idx *fsIndex) lookup(job *schema.Job) (indexEntry, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	e, ok := idx.clusters[job.Cluster][indexKey{job.JobID, job.StartTime.Unix()}]
+	return e, ok
+}
+
+// Returns the entries of cluster ordered by start time and job id.
+func (idx *fsIndex) entries(cluster string) []indexEntry {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	return idx.sorted(cluster)
+}
+
+func (idx *fsIndex) sorted(cluster string) []indexEntry {
+	entries := make([]indexEntry, 0, len(idx.clusters[cluster]))
+	for _, e := range idx.clusters[cluster] {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].startTime != entries[j].startTime {
+			return entries[i].startTime < entries[j].startTime
+		}
+		return entries[i].jobID < entries[j].jobID
+	})
+
+	return entries
+}
+
+func (idx *fsIndex) clusterNames() []string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	names := make([]string, 0, len(idx.clusters))
+	for name := range idx.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Compute the statistics shown by Info from the index.
+func (idx *fsIndex) info(cluster string) *clusterInfo {
+	ci := &clusterInfo{dateFirst: time.Now().Unix()}
+	for _, e := range idx.entries(cluster) {
+		ci.numJobs++
+		ci.dateFirst = util.Min(ci.dateFirst, e.startTime)
+		ci.dateLast = util.Max(ci.dateLast, e.startTime)
+		ci.diskSize += float64(e.size) * 1e-6
+	}
+	return ci
+}
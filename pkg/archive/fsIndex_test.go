@@ -0,0 +1,181 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/internal/util"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+func setupIndex(t *testing.T) *FsArchive {
+	jobarchive := filepath.Join(t.TempDir(), "job-archive")
+	if err := util.CopyDir("./testdata/archive/", jobarchive); err != nil {
+		t.Fatal(err)
+	}
+
+	return initIndex(t, jobarchive)
+}
+
+func initIndex(t *testing.T, path string) *FsArchive {
+	var fsa FsArchive
+	if _, err := fsa.Init(json.RawMessage(fmt.Sprintf("{\"path\":\"%s\", \"useIndex\": true}", path))); err != nil {
+		t.Fatal(err)
+	}
+	return &fsa
+}
+
+func indexLines(t *testing.T, fsa *FsArchive, cluster string) []string {
+	b, err := os.ReadFile(filepath.Join(fsa.path, cluster, indexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func TestIndexBuild(t *testing.T) {
+	fsa := setupIndex(t)
+
+	entries := fsa.index.entries("emmy")
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 index entries, got %d", len(entries))
+	}
+	if entries[0].jobID != 1403244 || entries[0].startTime != 1608923076 || !entries[0].compressed || entries[0].size == 0 {
+		t.Fatalf("unexpected entry %+v", entries[0])
+	}
+	if len(indexLines(t, fsa, "emmy")) != 2 {
+		t.Fatal("unexpected index file")
+	}
+
+	cnt := 0
+	for job := range fsa.Iter(false) {
+		if job.Meta.Cluster != "emmy" {
+			t.Fail()
+		}
+		cnt++
+	}
+	if cnt != 2 {
+		t.Fatalf("expected 2 jobs, got %d", cnt)
+	}
+}
+
+func TestIndexImportCompressCleanUp(t *testing.T) {
+	fsa := setupIndex(t)
+	job := importChecksumJob(t, fsa)
+
+	e, ok := fsa.index.lookup(job)
+	if !ok || e.compressed || e.size == 0 {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+
+	fsa.Compress([]*schema.Job{job})
+	if e, _ := fsa.index.lookup(job); !e.compressed {
+		t.Fatal("entry not compressed")
+	}
+
+	fsa.CleanUp([]*schema.Job{job})
+	if _, ok := fsa.index.lookup(job); ok {
+		t.Fatal("entry still exists after cleanup")
+	}
+	if len(indexLines(t, fsa, "emmy")) != 5 {
+		t.Fatal("expected appended index lines")
+	}
+
+	// Replaying the log compacts the index file
+	fsa = initIndex(t, fsa.path)
+	if len(fsa.index.entries("emmy")) != 2 || len(indexLines(t, fsa, "emmy")) != 2 {
+		t.Fatal("unexpected index after reload")
+	}
+}
+
+func TestIndexCleanAndMove(t *testing.T) {
+	fsa := setupIndex(t)
+	jobs := testJobs()
+
+	fsa.Move(jobs[1:], t.TempDir())
+	if _, ok := fsa.index.lookup(jobs[1]); ok {
+		t.Fatal("moved job still indexed")
+	}
+
+	fsa.Clean(1609000000, 0)
+	if fsa.Exists(jobs[0]) {
+		t.Fatal("job still exists after clean")
+	}
+	if len(fsa.index.entries("emmy")) != 0 {
+		t.Fatal("index not empty")
+	}
+
+	fsa = initIndex(t, fsa.path)
+	if len(fsa.index.entries("emmy")) != 0 {
+		t.Fatal("index not empty after reload")
+	}
+}
+
+func TestIndexStaleAndRebuild(t *testing.T) {
+	fsa := setupIndex(t)
+	filename := filepath.Join(fsa.path, "emmy", indexFile)
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	// Index is up to date, the (bogus) log is replayed
+	if err := os.WriteFile(filename, []byte("+ 1 2 0 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filename, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if fsa = initIndex(t, fsa.path); len(fsa.index.entries("emmy")) != 1 {
+		t.Fatalf("unexpected entries %v", fsa.index.entries("emmy"))
+	}
+
+	// A job directory added without index makes the index stale, bad job
+	// directories are skipped when rebuilding
+	if err := os.WriteFile(filename, []byte("+ 1 2 0 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filename, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(fsa.path, "emmy", "1403", "244", "invalid"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if fsa = initIndex(t, fsa.path); len(fsa.index.entries("emmy")) != 2 {
+		t.Fatalf("stale index not rebuilt: %v", fsa.index.entries("emmy"))
+	}
+
+	// A directory modified in the same second as the index counts as newer
+	lvl2Dir := filepath.Join(fsa.path, "emmy", "1403", "244")
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(lvl2Dir, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if stale, err := fsa.index.stale("emmy", info.ModTime()); err != nil || !stale {
+		t.Fatalf("expected index to be stale, got %v, %v", stale, err)
+	}
+
+	if err := os.WriteFile(filename, []byte("+ 1 2 0 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filename, future, future); err != nil {
+		t.Fatal(err)
+	}
+	var rebuilt FsArchive
+	if _, err := rebuilt.Init(json.RawMessage(fmt.Sprintf(
+		"{\"path\":\"%s\", \"useIndex\": true, \"rebuildIndex\": true}", fsa.path))); err != nil {
+		t.Fatal(err)
+	}
+	if len(rebuilt.index.entries("emmy")) != 2 {
+		t.Fatalf("index not rebuilt: %v", rebuilt.index.entries("emmy"))
+	}
+}
@@ -4,10 +4,590 @@
 // license that can be found in the LICENSE file.
 package archive
 
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/internal/config"
+	"github.com/ClusterCockpit/cc-backend/internal/util"
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
 type S3ArchiveConfig struct {
-	Path string `json:"filePath"`
+	Endpoint     string `json:"endpoint"`
+	Bucket       string `json:"bucket"`
+	Region       string `json:"region"`
+	AccessKey    string `json:"accessKey"`
+	SecretKey    string `json:"secretKey"`
+	UsePathStyle bool   `json:"usePathStyle"`
 }
 
+// S3Archive stores the job archive in an S3 bucket using the same key
+// layout as the directory tree of FsArchive
+// (<cluster>/<lvl1>/<lvl2>/<starttime>/meta.json).
 type S3Archive struct {
-	path string
+	client   *s3Client
+	clusters []string
+}
+
+func getS3Directory(job *schema.Job, prefix string) string {
+	lvl1, lvl2 := fmt.Sprintf("%d", job.JobID/1000), fmt.Sprintf("%03d", job.JobID%1000)
+
+	return path.Join(
+		prefix,
+		job.Cluster,
+		lvl1, lvl2,
+		strconv.FormatInt(job.StartTime.Unix(), 10))
+}
+
+func getS3Key(job *schema.Job, file string) string {
+	return path.Join(getS3Directory(job, ""), file)
+}
+
+// Split a key of the form <cluster>/<lvl1>/<lvl2>/<starttime>/<file>
+// into the job directory, the start time and the file name.
+func splitS3JobKey(key string) (dir string, startTime int64, file string, ok bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 5 {
+		return "", 0, "", false
+	}
+
+	startTime, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return "", 0, "", false
+	}
+
+	return path.Join(parts[:4]...), startTime, parts[4], true
+}
+
+// Put a version.txt into the bucket unless there is one already. Buckets
+// with other objects are refused.
+func createS3Archive(rawConfig json.RawMessage) error {
+	var config S3ArchiveConfig
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		log.Warnf("createS3Archive() > Unmarshal error: %#v", err)
+		return err
+	}
+	if config.AccessKey == "" {
+		config.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if config.SecretKey == "" {
+		config.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	client, err := newS3Client(&config)
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.headObject("version.txt"); err == nil {
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	empty := true
+	if err := client.listObjects("", "/", func(objects []s3Object, prefixes []string) error {
+		empty = empty && len(objects) == 0 && len(prefixes) == 0
+		return nil
+	}); err != nil {
+		return err
+	}
+	if !empty {
+		return fmt.Errorf("ARCHIVE/S3 > bucket %s is not empty but has no version.txt", config.Bucket)
+	}
+
+	return client.putObject("version.txt", []byte(fmt.Sprintf("%d\n", Version)))
+}
+
+func (s3a *S3Archive) Init(rawConfig json.RawMessage) (uint64, error) {
+	var config S3ArchiveConfig
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		log.Warnf("Init() > Unmarshal error: %#v", err)
+		return 0, err
+	}
+	if config.Endpoint == "" || config.Bucket == "" {
+		err := fmt.Errorf("Init() : empty config.Endpoint or config.Bucket")
+		log.Errorf("Init() > config error: %v", err)
+		return 0, err
+	}
+
+	// Allow credentials from the environment so they do not need to be
+	// stored in config.json.
+	if config.AccessKey == "" {
+		config.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if config.SecretKey == "" {
+		config.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	client, err := newS3Client(&config)
+	if err != nil {
+		log.Errorf("s3Backend Init() - %v", err)
+		return 0, err
+	}
+	s3a.client = client
+
+	b, err := s3a.client.getObject("version.txt")
+	if err != nil {
+		log.Warnf("s3Backend Init() - %v", err)
+		return 0, err
+	}
+
+	version, err := strconv.ParseUint(strings.TrimSuffix(string(b), "\n"), 10, 64)
+	if err != nil {
+		log.Errorf("s3Backend Init()- %v", err)
+		return 0, err
+	}
+
+	if version != Version {
+		return version, fmt.Errorf("unsupported version %d, need %d", version, Version)
+	}
+
+	// Only top-level prefixes with a cluster.json are clusters, others
+	// may for example be retention locations.
+	s3a.clusters = []string{}
+	if err := s3a.client.listObjects("", "/", func(_ []s3Object, prefixes []string) error {
+		for _, p := range prefixes {
+			if _, err := s3a.client.headObject(p + "cluster.json"); err != nil {
+				continue
+			}
+			s3a.clusters = append(s3a.clusters, strings.TrimSuffix(p, "/"))
+		}
+		return nil
+	}); err != nil {
+		log.Errorf("Init() > listObjects() error: %v", err)
+		return 0, err
+	}
+
+	return version, nil
+}
+
+func (s3a *S3Archive) Info() {
+	fmt.Printf("Job archive s3://%s at %s\n", s3a.client.bucket, s3a.client.endpoint)
+
+	ci := make(map[string]*clusterInfo)
+	for _, cluster := range s3a.clusters {
+		info := &clusterInfo{dateFirst: time.Now().Unix()}
+		ci[cluster] = info
+
+		if err := s3a.client.listObjects(cluster+"/", "", func(objects []s3Object, _ []string) error {
+			for _, obj := range objects {
+				_, startTime, file, ok := splitS3JobKey(obj.Key)
+				if !ok {
+					continue
+				}
+				if file == "meta.json" {
+					info.numJobs++
+					info.dateFirst = util.Min(info.dateFirst, startTime)
+					info.dateLast = util.Max(info.dateLast, startTime)
+				}
+				info.diskSize += float64(obj.Size) * 1e-6
+			}
+			return nil
+		}); err != nil {
+			log.Fatalf("Reading jobs failed: %s", err.Error())
+		}
+	}
+
+	cit := clusterInfo{dateFirst: time.Now().Unix()}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintln(w, "cluster\t#jobs\tfrom\tto\tdu (MB)")
+	for cluster, clusterInfo := range ci {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%.2f\n", cluster,
+			clusterInfo.numJobs,
+			time.Unix(clusterInfo.dateFirst, 0),
+			time.Unix(clusterInfo.dateLast, 0),
+			clusterInfo.diskSize)
+
+		cit.numJobs += clusterInfo.numJobs
+		cit.dateFirst = util.Min(cit.dateFirst, clusterInfo.dateFirst)
+		cit.dateLast = util.Max(cit.dateLast, clusterInfo.dateLast)
+		cit.diskSize += clusterInfo.diskSize
+	}
+
+	fmt.Fprintf(w, "TOTAL\t%d\t%s\t%s\t%.2f\n",
+		cit.numJobs, time.Unix(cit.dateFirst, 0), time.Unix(cit.dateLast, 0), cit.diskSize)
+	w.Flush()
+}
+
+func (s3a *S3Archive) Exists(job *schema.Job) bool {
+	_, err := s3a.client.headObject(getS3Key(job, "meta.json"))
+	return err == nil
+}
+
+// Remove all objects below the given job directory.
+func (s3a *S3Archive) removeJobDirectory(dir string) error {
+	keys := []string{}
+	if err := s3a.client.listObjects(dir+"/", "", func(objects []s3Object, _ []string) error {
+		for _, obj := range objects {
+			keys = append(keys, obj.Key)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := s3a.client.deleteObject(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s3a *S3Archive) Clean(before int64, after int64) {
+	if after == 0 {
+		after = math.MaxInt64
+	}
+
+	for _, cluster := range s3a.clusters {
+		if err := s3a.client.listObjects(cluster+"/", "", func(objects []s3Object, _ []string) error {
+			for _, obj := range objects {
+				_, startTime, _, ok := splitS3JobKey(obj.Key)
+				if !ok {
+					continue
+				}
+
+				if startTime < before || startTime > after {
+					if err := s3a.client.deleteObject(obj.Key); err != nil {
+						log.Errorf("JobArchive Clean() error: %v", err)
+					}
+				}
+			}
+			return nil
+		}); err != nil {
+			log.Fatalf("Reading jobs failed: %s", err.Error())
+		}
+	}
+}
+
+// Move the jobs below the key prefix location. As S3 has no rename
+// operation, every object is copied and the original is deleted afterwards.
+func (s3a *S3Archive) Move(jobs []*schema.Job, location string) {
+	for _, job := range jobs {
+		source := getS3Directory(job, "")
+		target := getS3Directory(job, strings.Trim(location, "/"))
+
+		keys := []string{}
+		if err := s3a.client.listObjects(source+"/", "", func(objects []s3Object, _ []string) error {
+			for _, obj := range objects {
+				keys = append(keys, obj.Key)
+			}
+			return nil
+		}); err != nil {
+			log.Errorf("JobArchive Move() error: %v", err)
+			continue
+		}
+
+		for _, key := range keys {
+			if err := s3a.client.copyObject(key, target+strings.TrimPrefix(key, source)); err != nil {
+				log.Errorf("JobArchive Move() error: %v", err)
+				continue
+			}
+			if err := s3a.client.deleteObject(key); err != nil {
+				log.Errorf("JobArchive Move() error: %v", err)
+			}
+		}
+	}
+}
+
+func (s3a *S3Archive) CleanUp(jobs []*schema.Job) {
+	start := time.Now()
+	for _, job := range jobs {
+		if err := s3a.removeJobDirectory(getS3Directory(job, "")); err != nil {
+			log.Errorf("JobArchive Cleanup() error: %v", err)
+		}
+	}
+
+	log.Infof("Retention Service - Remove %d files in %s", len(jobs), time.Since(start))
+}
+
+func (s3a *S3Archive) Compress(jobs []*schema.Job) {
+	var cnt int
+	start := time.Now()
+
+	for _, job := range jobs {
+		keyIn := getS3Key(job, "data.json")
+		size, err := s3a.client.headObject(keyIn)
+		if err != nil || size <= 2000 {
+			continue
+		}
+
+		b, err := s3a.client.getObject(keyIn)
+		if err != nil {
+			log.Errorf("JobArchive Compress() error: %v", err)
+			continue
+		}
+
+		compressed, err := compressBlob(b)
+		if err != nil {
+			log.Errorf("JobArchive Compress() error: %v", err)
+			continue
+		}
+
+		if err := s3a.client.putObject(getS3Key(job, "data.json.gz"), compressed); err != nil {
+			log.Errorf("JobArchive Compress() error: %v", err)
+			continue
+		}
+		if err := s3a.client.deleteObject(keyIn); err != nil {
+			log.Errorf("JobArchive Compress() error: %v", err)
+		}
+		cnt++
+	}
+
+	log.Infof("Compression Service - %d files took %s", cnt, time.Since(start))
+}
+
+func (s3a *S3Archive) CompressLast(starttime int64) int64 {
+	const key = "compress.txt"
+	b, err := s3a.client.getObject(key)
+	if err != nil {
+		log.Errorf("s3Backend Compress - %v", err)
+		if err := s3a.client.putObject(key, []byte(fmt.Sprintf("%d", starttime))); err != nil {
+			log.Errorf("s3Backend Compress - %v", err)
+		}
+		return starttime
+	}
+	last, err := strconv.ParseInt(strings.TrimSuffix(string(b), "\n"), 10, 64)
+	if err != nil {
+		log.Errorf("s3Backend Compress - %v", err)
+		return starttime
+	}
+
+	log.Infof("s3Backend Compress - start %d last %d", starttime, last)
+	if err := s3a.client.putObject(key, []byte(fmt.Sprintf("%d", starttime))); err != nil {
+		log.Errorf("s3Backend Compress - %v", err)
+	}
+	return last
+}
+
+func (s3a *S3Archive) loadJobData(dir string) (schema.JobData, error) {
+	key := path.Join(dir, "data.json.gz")
+	b, err := s3a.client.getObject(key)
+	isCompressed := true
+	if errors.Is(err, os.ErrNotExist) {
+		key = path.Join(dir, "data.json")
+		b, err = s3a.client.getObject(key)
+		isCompressed = false
+	}
+	if err != nil {
+		log.Errorf("s3Backend LoadJobData()- %v", err)
+		return nil, err
+	}
+
+	if isCompressed {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			log.Errorf(" %v", err)
+			return nil, err
+		}
+		defer r.Close()
+
+		if b, err = io.ReadAll(r); err != nil {
+			log.Errorf(" %v", err)
+			return nil, err
+		}
+	}
+
+	if config.Keys.Validate {
+		if err := schema.Validate(schema.Data, bytes.NewReader(b)); err != nil {
+			return schema.JobData{}, fmt.Errorf("validate job data: %v", err)
+		}
+	}
+
+	return DecodeJobData(bytes.NewReader(b), "s3://"+s3a.client.bucket+"/"+key)
+}
+
+func (s3a *S3Archive) LoadJobDataResolution(job *schema.Job, factor int) (schema.JobData, error) {
+	key := getS3Key(job, resolutionFile(factor))
+	b, err := s3a.client.getObject(key)
+	if err != nil {
+		return nil, fmt.Errorf("ARCHIVE/S3BACKEND > no resolution %dx for job %d: %w", factor, job.JobID, err)
+	}
+
+	if config.Keys.Validate {
+		if err := schema.Validate(schema.Data, bytes.NewReader(b)); err != nil {
+			return schema.JobData{}, fmt.Errorf("validate job data: %v", err)
+		}
+	}
+
+	return DecodeJobData(bytes.NewReader(b), "s3://"+s3a.client.bucket+"/"+key)
+}
+
+func (s3a *S3Archive) loadJobMeta(key string) (*schema.JobMeta, error) {
+	b, err := s3a.client.getObject(key)
+	if err != nil {
+		log.Errorf("loadJobMeta() > get object error: %v", err)
+		return &schema.JobMeta{}, err
+	}
+	if config.Keys.Validate {
+		if err := schema.Validate(schema.Meta, bytes.NewReader(b)); err != nil {
+			return &schema.JobMeta{}, fmt.Errorf("validate job meta: %v", err)
+		}
+	}
+
+	return DecodeJobMeta(bytes.NewReader(b))
+}
+
+func (s3a *S3Archive) LoadJobData(job *schema.Job) (schema.JobData, error) {
+	return s3a.loadJobData(getS3Directory(job, ""))
+}
+
+func (s3a *S3Archive) LoadJobMeta(job *schema.Job) (*schema.JobMeta, error) {
+	return s3a.loadJobMeta(getS3Key(job, "meta.json"))
+}
+
+func (s3a *S3Archive) LoadClusterCfg(name string) (*schema.Cluster, error) {
+	b, err := s3a.client.getObject(path.Join(name, "cluster.json"))
+	if err != nil {
+		log.Errorf("LoadClusterCfg() > get object error: %v", err)
+		return &schema.Cluster{}, err
+	}
+	if config.Keys.Validate {
+		if err := schema.Validate(schema.ClusterCfg, bytes.NewReader(b)); err != nil {
+			log.Warnf("Validate cluster config: %v\n", err)
+			return &schema.Cluster{}, fmt.Errorf("validate cluster config: %v", err)
+		}
+	}
+
+	return DecodeCluster(bytes.NewReader(b))
+}
+
+func (s3a *S3Archive) StoreClusterCfg(name string, config *schema.Cluster) error {
+	b, err := json.Marshal(config)
+	if err != nil {
+		log.Error("Error while encoding cluster config")
+		return err
+	}
+	if err := s3a.client.putObject(path.Join(name, "cluster.json"), b); err != nil {
+		log.Error("Error while storing cluster.json object")
+		return err
+	}
+
+	if !util.Contains(s3a.clusters, name) {
+		s3a.clusters = append(s3a.clusters, name)
+	}
+	return nil
+}
+
+func (s3a *S3Archive) Iter(loadMetricData bool) <-chan JobContainer {
+	ch := make(chan JobContainer)
+	go func() {
+		for _, cluster := range s3a.clusters {
+			if err := s3a.client.listObjects(cluster+"/", "", func(objects []s3Object, _ []string) error {
+				for _, obj := range objects {
+					dir, _, file, ok := splitS3JobKey(obj.Key)
+					if !ok || file != "meta.json" {
+						continue
+					}
+
+					job, err := s3a.loadJobMeta(obj.Key)
+					if err != nil {
+						log.Errorf("in %s: %s", dir, err.Error())
+					}
+
+					if loadMetricData {
+						data, err := s3a.loadJobData(dir)
+						if err != nil {
+							log.Errorf("in %s: %s", dir, err.Error())
+						}
+						ch <- JobContainer{Meta: job, Data: &data}
+					} else {
+						ch <- JobContainer{Meta: job, Data: nil}
+					}
+				}
+				return nil
+			}); err != nil {
+				log.Fatalf("Reading jobs failed: %s", err.Error())
+			}
+		}
+		close(ch)
+	}()
+	return ch
+}
+
+func (s3a *S3Archive) StoreJobMeta(jobMeta *schema.JobMeta) error {
+	job := schema.Job{
+		BaseJob:       jobMeta.BaseJob,
+		StartTime:     time.Unix(jobMeta.StartTime, 0),
+		StartTimeUnix: jobMeta.StartTime,
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeJobMeta(&buf, jobMeta); err != nil {
+		log.Error("Error while encoding job metadata to meta.json object")
+		return err
+	}
+	if err := s3a.client.putObject(getS3Key(&job, "meta.json"), buf.Bytes()); err != nil {
+		log.Error("Error while storing meta.json object")
+		return err
+	}
+
+	return nil
+}
+
+func (s3a *S3Archive) StoreJobDataResolution(
+	jobMeta *schema.JobMeta,
+	factor int,
+	jobData *schema.JobData) error {
+
+	job := schema.Job{
+		BaseJob:       jobMeta.BaseJob,
+		StartTime:     time.Unix(jobMeta.StartTime, 0),
+		StartTimeUnix: jobMeta.StartTime,
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeJobData(&buf, jobData); err != nil {
+		log.Errorf("Error while encoding job metricdata to %s object", resolutionFile(factor))
+		return err
+	}
+	if err := s3a.client.putObject(getS3Key(&job, resolutionFile(factor)), buf.Bytes()); err != nil {
+		log.Errorf("Error while storing %s object", resolutionFile(factor))
+		return err
+	}
+
+	return nil
+}
+
+func (s3a *S3Archive) GetClusters() []string {
+	return s3a.clusters
+}
+
+func (s3a *S3Archive) ImportJob(
+	jobMeta *schema.JobMeta,
+	jobData *schema.JobData) error {
+
+	job := schema.Job{
+		BaseJob:       jobMeta.BaseJob,
+		StartTime:     time.Unix(jobMeta.StartTime, 0),
+		StartTimeUnix: jobMeta.StartTime,
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeJobData(&buf, jobData); err != nil {
+		log.Error("Error while encoding job metricdata to data.json object")
+		return err
+	}
+	if err := s3a.client.putObject(getS3Key(&job, "data.json"), buf.Bytes()); err != nil {
+		log.Error("Error while storing data.json object")
+		return err
+	}
+
+	// meta.json is written last, as its existence marks a complete job
+	return s3a.StoreJobMeta(jobMeta)
 }
@@ -0,0 +1,372 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package archive
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+// In-memory stand-in for an S3 compatible object store supporting
+// path-style GET, PUT (incl. copy), HEAD, DELETE and ListObjectsV2.
+type fakeS3 struct {
+	mu       sync.Mutex
+	bucket   string
+	objects  map[string][]byte
+	pageSize int
+}
+
+func (f *fakeS3) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test/") {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+f.bucket), "/")
+	if key == "" && r.Method == http.MethodGet {
+		f.list(rw, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		b, ok := f.objects[key]
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.Header().Set("Content-Length", fmt.Sprint(len(b)))
+		rw.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			rw.Write(b)
+		}
+	case http.MethodPut:
+		if src := r.Header.Get("X-Amz-Copy-Source"); src != "" {
+			b, ok := f.objects[strings.TrimPrefix(src, "/"+f.bucket+"/")]
+			if !ok {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			f.objects[key] = b
+			rw.Write([]byte("<CopyObjectResult></CopyObjectResult>"))
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		f.objects[key] = b
+	case http.MethodDelete:
+		delete(f.objects, key)
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(rw http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	delimiter := r.URL.Query().Get("delimiter")
+	token := r.URL.Query().Get("continuation-token")
+
+	keys := make([]string, 0, len(f.objects))
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) && k >= token {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var res s3ListBucketResult
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if len(res.Contents)+len(res.CommonPrefixes) == f.pageSize {
+			res.IsTruncated = true
+			res.NextContinuationToken = k
+			break
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(k[len(prefix):], delimiter); i >= 0 {
+				p := k[:len(prefix)+i+1]
+				if !seen[p] {
+					seen[p] = true
+					res.CommonPrefixes = append(res.CommonPrefixes, struct {
+						Prefix string `xml:"Prefix"`
+					}{p})
+				}
+				continue
+			}
+		}
+		res.Contents = append(res.Contents, s3Object{Key: k, Size: int64(len(f.objects[k]))})
+	}
+
+	xml.NewEncoder(rw).Encode(&res)
+}
+
+func setupS3(t *testing.T) (*S3Archive, *fakeS3) {
+	fake := &fakeS3{bucket: "job-archive", objects: map[string][]byte{}, pageSize: 3}
+	root := "testdata/archive"
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel, _ := filepath.Rel(root, p)
+		fake.objects[filepath.ToSlash(rel)] = b
+		return nil
+	})
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	var s3a S3Archive
+	cfg := fmt.Sprintf(`{"kind": "s3", "endpoint": "%s", "bucket": "job-archive",
+		"accessKey": "test", "secretKey": "secret", "usePathStyle": true}`, srv.URL)
+	version, err := s3a.Init(json.RawMessage(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Fatalf("unexpected version %d", version)
+	}
+
+	return &s3a, fake
+}
+
+func TestS3InitNoEndpoint(t *testing.T) {
+	var s3a S3Archive
+	if _, err := s3a.Init(json.RawMessage(`{"kind": "s3", "bucket": "job-archive"}`)); err == nil {
+		t.Fatal("expected error for missing endpoint")
+	}
+}
+
+func TestS3Init(t *testing.T) {
+	s3a, _ := setupS3(t)
+	if len(s3a.clusters) != 1 || s3a.clusters[0] != "emmy" {
+		t.Fatalf("unexpected clusters %v", s3a.clusters)
+	}
+}
+
+func TestS3LoadJobMetaAndData(t *testing.T) {
+	s3a, _ := setupS3(t)
+
+	jobIn := schema.Job{BaseJob: schema.JobDefaults}
+	jobIn.StartTime = time.Unix(1608923076, 0)
+	jobIn.JobID = 1403244
+	jobIn.Cluster = "emmy"
+
+	job, err := s3a.LoadJobMeta(&jobIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.JobID != 1403244 || job.StartTime != 1608923076 {
+		t.Fail()
+	}
+
+	data, err := s3a.LoadJobData(&jobIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scopes := range data {
+		if _, exists := scopes[schema.MetricScopeNode]; !exists {
+			t.Fail()
+		}
+	}
+}
+
+func TestS3LoadCluster(t *testing.T) {
+	s3a, _ := setupS3(t)
+
+	cfg, err := s3a.LoadClusterCfg("emmy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SubClusters[0].CoresPerSocket != 4 {
+		t.Fail()
+	}
+}
+
+func TestS3Iter(t *testing.T) {
+	s3a, _ := setupS3(t)
+
+	cnt := 0
+	for job := range s3a.Iter(true) {
+		if job.Meta.Cluster != "emmy" || job.Data == nil || len(*job.Data) == 0 {
+			t.Fail()
+		}
+		cnt++
+	}
+	if cnt != 2 {
+		t.Fatalf("expected 2 jobs, got %d", cnt)
+	}
+}
+
+func TestS3ImportAndCompress(t *testing.T) {
+	s3a, fake := setupS3(t)
+
+	jobIn := schema.Job{BaseJob: schema.JobDefaults}
+	jobIn.StartTime = time.Unix(1608923076, 0)
+	jobIn.JobID = 1403244
+	jobIn.Cluster = "emmy"
+
+	jobMeta, err := s3a.LoadJobMeta(&jobIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobData, err := s3a.LoadJobData(&jobIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobMeta.JobID = 42
+	if err := s3a.ImportJob(jobMeta, &jobData); err != nil {
+		t.Fatal(err)
+	}
+
+	job := schema.Job{BaseJob: jobMeta.BaseJob, StartTime: time.Unix(jobMeta.StartTime, 0)}
+	if !s3a.Exists(&job) {
+		t.Fatal("imported job does not exist")
+	}
+	if _, ok := fake.objects["emmy/0/042/1608923076/data.json"]; !ok {
+		t.Fatal("data.json missing after import")
+	}
+
+	s3a.Compress([]*schema.Job{&job})
+	if _, ok := fake.objects["emmy/0/042/1608923076/data.json"]; ok {
+		t.Fatal("data.json still exists after compression")
+	}
+	if _, err := s3a.LoadJobData(&job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestS3CleanUpAndMove(t *testing.T) {
+	s3a, fake := setupS3(t)
+
+	jobs := []*schema.Job{{}, {}}
+	jobs[0].JobID = 1403244
+	jobs[0].Cluster = "emmy"
+	jobs[0].StartTime = time.Unix(1608923076, 0)
+	jobs[1].JobID = 1404397
+	jobs[1].Cluster = "emmy"
+	jobs[1].StartTime = time.Unix(1609300556, 0)
+
+	s3a.Move(jobs[1:], "retention")
+	if s3a.Exists(jobs[1]) {
+		t.Fatal("moved job still exists")
+	}
+	if _, ok := fake.objects["retention/emmy/1404/397/1609300556/meta.json"]; !ok {
+		t.Fatal("moved job missing at target")
+	}
+
+	s3a.CleanUp(jobs[:1])
+	if s3a.Exists(jobs[0]) {
+		t.Fatal("job still exists after cleanup")
+	}
+}
+
+func TestS3Clean(t *testing.T) {
+	s3a, _ := setupS3(t)
+
+	s3a.Clean(1609000000, 0)
+
+	cnt := 0
+	for job := range s3a.Iter(false) {
+		if job.Meta.StartTime < 1609000000 {
+			t.Fail()
+		}
+		cnt++
+	}
+	if cnt != 1 {
+		t.Fatalf("expected 1 job, got %d", cnt)
+	}
+}
+
+func TestS3JobDataResolution(t *testing.T) {
+	s3a, fake := setupS3(t)
+
+	job := &schema.Job{}
+	job.JobID = 1403244
+	job.Cluster = "emmy"
+	job.StartTime = time.Unix(1608923076, 0)
+
+	if _, err := s3a.LoadJobDataResolution(job, 4); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing resolution, got %v", err)
+	}
+
+	jobMeta, err := s3a.LoadJobMeta(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobData, err := s3a.LoadJobData(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reduced := jobData.Downsample(4)
+	if err := s3a.StoreJobDataResolution(jobMeta, 4, &reduced); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.objects["emmy/1403/244/1608923076/data.4x.json"]; !ok {
+		t.Fatal("missing resolution object")
+	}
+	if _, err := s3a.LoadJobDataResolution(job, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	s3a.CleanUp([]*schema.Job{job})
+	if _, ok := fake.objects["emmy/1403/244/1608923076/data.4x.json"]; ok {
+		t.Fatal("resolution object still exists after cleanup")
+	}
+}
+
+func TestS3CreateArchive(t *testing.T) {
+	fake := &fakeS3{bucket: "job-archive", objects: map[string][]byte{}, pageSize: 3}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	cfg := json.RawMessage(fmt.Sprintf(`{"kind": "s3", "endpoint": "%s", "bucket": "job-archive",
+		"accessKey": "test", "secretKey": "secret", "usePathStyle": true}`, srv.URL))
+	if err := createS3Archive(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if string(fake.objects["version.txt"]) != "1\n" {
+		t.Fatalf("unexpected version.txt %q", fake.objects["version.txt"])
+	}
+
+	var s3a S3Archive
+	if _, err := s3a.Init(cfg); err != nil || len(s3a.clusters) != 0 {
+		t.Fatalf("unexpected init result %v, %v", s3a.clusters, err)
+	}
+
+	// An existing archive is left alone, other buckets are refused
+	fake.objects["version.txt"] = []byte("1")
+	if err := createS3Archive(cfg); err != nil || string(fake.objects["version.txt"]) != "1" {
+		t.Fatalf("unexpected result for existing archive: %v", err)
+	}
+	delete(fake.objects, "version.txt")
+	fake.objects["data/file"] = []byte{}
+	if err := createS3Archive(cfg); err == nil {
+		t.Fatal("expected error for non-empty bucket")
+	}
+}
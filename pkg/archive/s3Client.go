@@ -0,0 +1,378 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package archive
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Minimal client for the subset of the S3 REST API needed by S3Archive.
+// Requests are signed with AWS Signature Version 4, which is understood
+// by AWS as well as by S3-compatible stores like MinIO or Ceph RGW.
+type s3Client struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	client    http.Client
+}
+
+type s3Object struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+type s3ListBucketResult struct {
+	Contents       []s3Object `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+const s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func newS3Client(config *S3ArchiveConfig) (*s3Client, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("ARCHIVE/S3 > invalid endpoint '%s'", config.Endpoint)
+	}
+
+	region := config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &s3Client{
+		endpoint:  endpoint,
+		bucket:    config.Bucket,
+		region:    region,
+		accessKey: config.AccessKey,
+		secretKey: config.SecretKey,
+		pathStyle: config.UsePathStyle,
+		client: http.Client{
+			Timeout: 60 * time.Second,
+		},
+	}, nil
+}
+
+func (c *s3Client) objectURL(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+	if c.pathStyle {
+		base += "/" + c.bucket
+	} else {
+		u.Host = c.bucket + "." + u.Host
+	}
+
+	if key != "" || !c.pathStyle {
+		u.Path = base + "/" + key
+	} else {
+		u.Path = base
+	}
+	u.RawPath = s3URIEncode(u.Path, false)
+	u.RawQuery = s3CanonicalQuery(query)
+	return &u
+}
+
+func (c *s3Client) do(
+	method string,
+	key string,
+	query url.Values,
+	body []byte,
+	header http.Header,
+) (*http.Response, error) {
+	u := c.objectURL(key, query)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	c.sign(req, u, body, time.Now().UTC())
+	return c.client.Do(req)
+}
+
+// Sign the request according to AWS Signature Version 4. Anonymous
+// access is used if no credentials are configured.
+func (c *s3Client) sign(req *http.Request, u *url.URL, body []byte, now time.Time) {
+	payloadHash := s3EmptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	if c.accessKey == "" {
+		return
+	}
+
+	headers := map[string]string{"host": u.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "content-type" || lk == "content-md5" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		u.EscapedPath(),
+		u.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	date := now.Format("20060102")
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crHash[:])
+
+	signingKey := s3HMAC([]byte("AWS4"+c.secretKey), date)
+	signingKey = s3HMAC(signingKey, c.region)
+	signingKey = s3HMAC(signingKey, "s3")
+	signingKey = s3HMAC(signingKey, "aws4_request")
+	signature := hex.EncodeToString(s3HMAC(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// URI encode every byte except the unreserved characters as required by
+// the SigV4 specification. The slash is kept for object key paths.
+func s3URIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' || (ch == '/' && !encodeSlash) {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3URIEncode(k, true)+"="+s3URIEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3ResponseError(res *http.Response, key string) error {
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("ARCHIVE/S3 > %s: %w", key, os.ErrNotExist)
+	}
+
+	var e s3Error
+	body, _ := io.ReadAll(res.Body)
+	if err := xml.Unmarshal(body, &e); err == nil && e.Code != "" {
+		return fmt.Errorf("ARCHIVE/S3 > %s: %s (%s)", key, e.Code, e.Message)
+	}
+	return fmt.Errorf("ARCHIVE/S3 > %s: HTTP Status: %s", key, res.Status)
+}
+
+func (c *s3Client) getObject(key string) ([]byte, error) {
+	res, err := c.do(http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, s3ResponseError(res, key)
+	}
+
+	return io.ReadAll(res.Body)
+}
+
+func (c *s3Client) putObject(key string, data []byte) error {
+	res, err := c.do(http.MethodPut, key, nil, data, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return s3ResponseError(res, key)
+	}
+
+	return nil
+}
+
+func (c *s3Client) deleteObject(key string) error {
+	res, err := c.do(http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		return s3ResponseError(res, key)
+	}
+
+	return nil
+}
+
+// Returns the size of the object or an error wrapping os.ErrNotExist.
+func (c *s3Client) headObject(key string) (int64, error) {
+	res, err := c.do(http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return 0, s3ResponseError(res, key)
+	}
+
+	return res.ContentLength, nil
+}
+
+func (c *s3Client) copyObject(src, dst string) error {
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", "/"+c.bucket+"/"+s3URIEncode(src, false))
+
+	res, err := c.do(http.MethodPut, dst, nil, nil, header)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return s3ResponseError(res, dst)
+	}
+
+	// S3 may report errors of a copy operation with status 200
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	var e s3Error
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Errorf("ARCHIVE/S3 > copy %s: %s (%s)", src, e.Code, e.Message)
+	}
+
+	return nil
+}
+
+// Call fn for every page of a ListObjectsV2 request. If delimiter is not
+// empty, keys sharing a prefix up to the delimiter are grouped into
+// prefixes.
+func (c *s3Client) listObjects(
+	prefix, delimiter string,
+	fn func(objects []s3Object, prefixes []string) error,
+) error {
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		res, err := c.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			err := s3ResponseError(res, prefix)
+			res.Body.Close()
+			return err
+		}
+
+		var result s3ListBucketResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		prefixes := make([]string, 0, len(result.CommonPrefixes))
+		for _, p := range result.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+
+		if err := fn(result.Contents, prefixes); err != nil {
+			return err
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package archive
+
+import (
+	"github.com/ClusterCockpit/cc-backend/internal/util"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+var archiveScopes []schema.ArchiveScopes
+
+// Scopes archived for clusters without an entry in the archive
+// configuration: node scope and core scope for jobs with up to 8 nodes.
+var defaultArchiveScopes = []schema.ArchiveScope{
+	{Scope: schema.MetricScopeNode},
+	{Scope: schema.MetricScopeCore, MaxNodes: 8},
+}
+
+func selectScopes(scopes []schema.ArchiveScope, numNodes int32) []schema.MetricScope {
+	res := make([]schema.MetricScope, 0, len(scopes))
+	for _, s := range scopes {
+		if s.MaxNodes == 0 || int(numNodes) <= s.MaxNodes {
+			res = append(res, s.Scope)
+		}
+	}
+	return res
+}
+
+// Returns the metrics to archive for job mapped to the scopes they are
+// archived at. Entries for the subcluster of the job take precedence over
+// entries for the whole cluster. If the cluster has entries in the archive
+// configuration, metrics not selected by any of them are not archived.
+func GetArchiveScopes(job *schema.Job) map[string][]schema.MetricScope {
+	res := make(map[string][]schema.MetricScope)
+	cluster := GetCluster(job.Cluster)
+	if cluster == nil {
+		return res
+	}
+
+	clusterRules := make([]schema.ArchiveScopes, 0)
+	subClusterRules := make([]schema.ArchiveScopes, 0)
+	for _, rule := range archiveScopes {
+		if rule.Cluster != job.Cluster {
+			continue
+		}
+		if rule.SubCluster == "" {
+			clusterRules = append(clusterRules, rule)
+		} else if rule.SubCluster == job.SubCluster {
+			subClusterRules = append(subClusterRules, rule)
+		}
+	}
+
+	for _, mc := range cluster.MetricConfig {
+		if len(clusterRules) == 0 && len(subClusterRules) == 0 {
+			res[mc.Name] = selectScopes(defaultArchiveScopes, job.NumNodes)
+			continue
+		}
+
+		for _, rule := range append(subClusterRules, clusterRules...) {
+			if len(rule.Metrics) == 0 || util.Contains(rule.Metrics, mc.Name) {
+				if scopes := selectScopes(rule.Scopes, job.NumNodes); len(scopes) > 0 {
+					res[mc.Name] = scopes
+				}
+				break
+			}
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,66 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package archive_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/archive"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+func TestGetArchiveScopesDefault(t *testing.T) {
+	setup(t)
+
+	job := &schema.Job{}
+	job.Cluster = "emmy"
+	job.NumNodes = 16
+	scopes := archive.GetArchiveScopes(job)
+	if len(scopes) != 13 || !reflect.DeepEqual(scopes["ipc"], []schema.MetricScope{schema.MetricScopeNode}) {
+		t.Fatalf("unexpected scopes %v", scopes)
+	}
+
+	job.NumNodes = 2
+	scopes = archive.GetArchiveScopes(job)
+	if !reflect.DeepEqual(scopes["ipc"], []schema.MetricScope{schema.MetricScopeNode, schema.MetricScopeCore}) {
+		t.Fatalf("unexpected scopes %v", scopes)
+	}
+}
+
+func TestGetArchiveScopes(t *testing.T) {
+	cfg := fmt.Sprintf(`{"kind": "file", "path": "%s", "scopes": [
+		{"cluster": "emmy", "metrics": ["ipc", "flops_any"], "scopes": [
+			{"scope": "node"}, {"scope": "socket", "maxNodes": 4}]},
+		{"cluster": "emmy", "subCluster": "icelake", "metrics": ["flops_any"], "scopes": [
+			{"scope": "hwthread"}]}]}`, "testdata/archive")
+	if err := archive.Init(json.RawMessage(cfg), false); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		archive.Init(json.RawMessage(`{"kind": "file", "path": "testdata/archive"}`), false)
+	})
+
+	job := &schema.Job{}
+	job.Cluster = "emmy"
+	job.SubCluster = "haswell"
+	job.NumNodes = 8
+	scopes := archive.GetArchiveScopes(job)
+	if len(scopes) != 2 || !reflect.DeepEqual(scopes["ipc"], []schema.MetricScope{schema.MetricScopeNode}) {
+		t.Fatalf("unexpected scopes %v", scopes)
+	}
+
+	job.SubCluster = "icelake"
+	job.NumNodes = 1
+	scopes = archive.GetArchiveScopes(job)
+	if !reflect.DeepEqual(scopes["ipc"], []schema.MetricScope{schema.MetricScopeNode, schema.MetricScopeSocket}) {
+		t.Fatalf("unexpected scopes %v", scopes)
+	}
+	if !reflect.DeepEqual(scopes["flops_any"], []schema.MetricScope{schema.MetricScopeHWThread}) {
+		t.Fatalf("unexpected scopes %v", scopes)
+	}
+}
@@ -0,0 +1,612 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/internal/config"
+	"github.com/ClusterCockpit/cc-backend/internal/util"
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type SqliteArchiveConfig struct {
+	Path string `json:"dbPath"`
+
+	// Store data.json blobs gzip compressed right away on import.
+	CompressData bool `json:"compressData"`
+}
+
+// SqliteArchive stores the meta.json and data.json documents of every job
+// as blobs in a single SQLite database file instead of a directory tree.
+type SqliteArchive struct {
+	db           *sql.DB
+	path         string
+	compressData bool
+	clusters     []string
+}
+
+const sqliteArchiveSchema string = `
+CREATE TABLE IF NOT EXISTS info (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS cluster (
+	name   TEXT PRIMARY KEY,
+	config BLOB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS job (
+	cluster    TEXT    NOT NULL,
+	job_id     INTEGER NOT NULL,
+	start_time INTEGER NOT NULL,
+	meta       BLOB    NOT NULL,
+	data       BLOB,
+	compressed INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (cluster, job_id, start_time)
+);
+CREATE INDEX IF NOT EXISTS job_start_time ON job (start_time);
+CREATE TABLE IF NOT EXISTS job_resolution (
+	cluster    TEXT    NOT NULL,
+	job_id     INTEGER NOT NULL,
+	start_time INTEGER NOT NULL,
+	factor     INTEGER NOT NULL,
+	data       BLOB    NOT NULL,
+	PRIMARY KEY (cluster, job_id, start_time, factor)
+);`
+
+func openSqliteArchive(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path+"?_journal=WAL&_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(sqliteArchiveSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// A fresh archive gets the current version, existing ones are checked.
+	if _, err := db.Exec(`INSERT OR IGNORE INTO info (key, value) VALUES ('version', ?)`,
+		strconv.FormatUint(Version, 10)); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (sa *SqliteArchive) Init(rawConfig json.RawMessage) (uint64, error) {
+	var config SqliteArchiveConfig
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		log.Warnf("Init() > Unmarshal error: %#v", err)
+		return 0, err
+	}
+	if config.Path == "" {
+		err := fmt.Errorf("Init() : empty config.Path")
+		log.Errorf("Init() > config.Path error: %v", err)
+		return 0, err
+	}
+	sa.path = config.Path
+	sa.compressData = config.CompressData
+
+	db, err := openSqliteArchive(sa.path)
+	if err != nil {
+		log.Errorf("sqliteBackend Init() - %v", err)
+		return 0, err
+	}
+	sa.db = db
+
+	var raw string
+	if err := sa.db.QueryRow(`SELECT value FROM info WHERE key = 'version'`).Scan(&raw); err != nil {
+		log.Errorf("sqliteBackend Init() - %v", err)
+		return 0, err
+	}
+
+	version, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		log.Errorf("sqliteBackend Init()- %v", err)
+		return 0, err
+	}
+
+	if version != Version {
+		return version, fmt.Errorf("unsupported version %d, need %d", version, Version)
+	}
+
+	rows, err := sa.db.Query(`SELECT name FROM cluster ORDER BY name`)
+	if err != nil {
+		log.Errorf("Init() > query clusters error: %v", err)
+		return 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return 0, err
+		}
+		sa.clusters = append(sa.clusters, name)
+	}
+
+	return version, nil
+}
+
+func (sa *SqliteArchive) Info() {
+	fmt.Printf("Job archive %s\n", sa.path)
+
+	rows, err := sa.db.Query(`SELECT cluster, count(*), min(start_time), max(start_time),
+		sum(length(meta) + ifnull(length(data), 0)) FROM job GROUP BY cluster`)
+	if err != nil {
+		log.Fatalf("Reading clusters failed: %s", err.Error())
+	}
+	defer rows.Close()
+
+	ci := make(map[string]*clusterInfo)
+	for rows.Next() {
+		var cluster string
+		var size int64
+		info := &clusterInfo{}
+		if err := rows.Scan(&cluster, &info.numJobs, &info.dateFirst, &info.dateLast, &size); err != nil {
+			log.Fatalf("Reading jobs failed: %s", err.Error())
+		}
+		info.diskSize = float64(size) * 1e-6
+		ci[cluster] = info
+	}
+
+	cit := clusterInfo{dateFirst: time.Now().Unix()}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintln(w, "cluster\t#jobs\tfrom\tto\tdu (MB)")
+	for cluster, clusterInfo := range ci {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%.2f\n", cluster,
+			clusterInfo.numJobs,
+			time.Unix(clusterInfo.dateFirst, 0),
+			time.Unix(clusterInfo.dateLast, 0),
+			clusterInfo.diskSize)
+
+		cit.numJobs += clusterInfo.numJobs
+		cit.dateFirst = util.Min(cit.dateFirst, clusterInfo.dateFirst)
+		cit.dateLast = util.Max(cit.dateLast, clusterInfo.dateLast)
+		cit.diskSize += clusterInfo.diskSize
+	}
+
+	fmt.Fprintf(w, "TOTAL\t%d\t%s\t%s\t%.2f\n",
+		cit.numJobs, time.Unix(cit.dateFirst, 0), time.Unix(cit.dateLast, 0), cit.diskSize)
+	w.Flush()
+}
+
+func (sa *SqliteArchive) Exists(job *schema.Job) bool {
+	var n int
+	err := sa.db.QueryRow(`SELECT count(*) FROM job WHERE cluster = ? AND job_id = ? AND start_time = ?`,
+		job.Cluster, job.JobID, job.StartTime.Unix()).Scan(&n)
+	return err == nil && n > 0
+}
+
+func (sa *SqliteArchive) Clean(before int64, after int64) {
+	if after == 0 {
+		after = math.MaxInt64
+	}
+
+	if _, err := sa.db.Exec(`DELETE FROM job_resolution WHERE start_time < ? OR start_time > ?`, before, after); err != nil {
+		log.Errorf("JobArchive Clean() error: %v", err)
+		return
+	}
+
+	res, err := sa.db.Exec(`DELETE FROM job WHERE start_time < ? OR start_time > ?`, before, after)
+	if err != nil {
+		log.Errorf("JobArchive Clean() error: %v", err)
+		return
+	}
+
+	if cnt, err := res.RowsAffected(); err == nil {
+		log.Infof("JobArchive Clean() - removed %d jobs", cnt)
+	}
+}
+
+// Move the jobs into the SQLite archive file at path, which is created if
+// it does not exist yet.
+func (sa *SqliteArchive) Move(jobs []*schema.Job, path string) {
+	target, err := openSqliteArchive(path)
+	if err != nil {
+		log.Errorf("JobArchive Move() error: %v", err)
+		return
+	}
+	defer target.Close()
+
+	for _, job := range jobs {
+		var meta, data []byte
+		var compressed bool
+		if err := sa.db.QueryRow(`SELECT meta, data, compressed FROM job
+			WHERE cluster = ? AND job_id = ? AND start_time = ?`,
+			job.Cluster, job.JobID, job.StartTime.Unix()).Scan(&meta, &data, &compressed); err != nil {
+			log.Errorf("JobArchive Move() error: %v", err)
+			continue
+		}
+
+		if _, err := target.Exec(`INSERT OR REPLACE INTO job
+			(cluster, job_id, start_time, meta, data, compressed) VALUES (?, ?, ?, ?, ?, ?)`,
+			job.Cluster, job.JobID, job.StartTime.Unix(), meta, data, compressed); err != nil {
+			log.Errorf("JobArchive Move() error: %v", err)
+			continue
+		}
+
+		if err := sa.moveResolutions(target, job); err != nil {
+			log.Errorf("JobArchive Move() error: %v", err)
+			continue
+		}
+
+		if err := sa.deleteJob(job); err != nil {
+			log.Errorf("JobArchive Move() error: %v", err)
+		}
+	}
+}
+
+func (sa *SqliteArchive) moveResolutions(target *sql.DB, job *schema.Job) error {
+	rows, err := sa.db.Query(`SELECT factor, data FROM job_resolution
+		WHERE cluster = ? AND job_id = ? AND start_time = ?`,
+		job.Cluster, job.JobID, job.StartTime.Unix())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var factor int
+		var data []byte
+		if err := rows.Scan(&factor, &data); err != nil {
+			return err
+		}
+
+		if _, err := target.Exec(`INSERT OR REPLACE INTO job_resolution
+			(cluster, job_id, start_time, factor, data) VALUES (?, ?, ?, ?, ?)`,
+			job.Cluster, job.JobID, job.StartTime.Unix(), factor, data); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Remove the job and all its downsampled resolutions.
+func (sa *SqliteArchive) deleteJob(job *schema.Job) error {
+	if _, err := sa.db.Exec(`DELETE FROM job_resolution WHERE cluster = ? AND job_id = ? AND start_time = ?`,
+		job.Cluster, job.JobID, job.StartTime.Unix()); err != nil {
+		return err
+	}
+
+	_, err := sa.db.Exec(`DELETE FROM job WHERE cluster = ? AND job_id = ? AND start_time = ?`,
+		job.Cluster, job.JobID, job.StartTime.Unix())
+	return err
+}
+
+func (sa *SqliteArchive) CleanUp(jobs []*schema.Job) {
+	start := time.Now()
+	for _, job := range jobs {
+		if err := sa.deleteJob(job); err != nil {
+			log.Errorf("JobArchive Cleanup() error: %v", err)
+		}
+	}
+
+	log.Infof("Retention Service - Remove %d files in %s", len(jobs), time.Since(start))
+}
+
+func compressBlob(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	if _, err := gzipWriter.Write(b); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (sa *SqliteArchive) Compress(jobs []*schema.Job) {
+	var cnt int
+	start := time.Now()
+
+	for _, job := range jobs {
+		var data []byte
+		if err := sa.db.QueryRow(`SELECT data FROM job
+			WHERE cluster = ? AND job_id = ? AND start_time = ? AND compressed = 0`,
+			job.Cluster, job.JobID, job.StartTime.Unix()).Scan(&data); err != nil {
+			continue
+		}
+		if len(data) <= 2000 {
+			continue
+		}
+
+		compressed, err := compressBlob(data)
+		if err != nil {
+			log.Errorf("JobArchive Compress() error: %v", err)
+			continue
+		}
+
+		if _, err := sa.db.Exec(`UPDATE job SET data = ?, compressed = 1
+			WHERE cluster = ? AND job_id = ? AND start_time = ?`,
+			compressed, job.Cluster, job.JobID, job.StartTime.Unix()); err != nil {
+			log.Errorf("JobArchive Compress() error: %v", err)
+			continue
+		}
+		cnt++
+	}
+
+	log.Infof("Compression Service - %d files took %s", cnt, time.Since(start))
+}
+
+func (sa *SqliteArchive) CompressLast(starttime int64) int64 {
+	var raw string
+	err := sa.db.QueryRow(`SELECT value FROM info WHERE key = 'compress'`).Scan(&raw)
+	if _, err := sa.db.Exec(`INSERT OR REPLACE INTO info (key, value) VALUES ('compress', ?)`,
+		strconv.FormatInt(starttime, 10)); err != nil {
+		log.Errorf("sqliteBackend Compress - %v", err)
+	}
+	if err != nil {
+		log.Errorf("sqliteBackend Compress - %v", err)
+		return starttime
+	}
+
+	last, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		log.Errorf("sqliteBackend Compress - %v", err)
+		return starttime
+	}
+
+	log.Infof("sqliteBackend Compress - start %d last %d", starttime, last)
+	return last
+}
+
+func decodeSqliteJobMeta(meta []byte) (*schema.JobMeta, error) {
+	if config.Keys.Validate {
+		if err := schema.Validate(schema.Meta, bytes.NewReader(meta)); err != nil {
+			return &schema.JobMeta{}, fmt.Errorf("validate job meta: %v", err)
+		}
+	}
+
+	return DecodeJobMeta(bytes.NewReader(meta))
+}
+
+func (sa *SqliteArchive) decodeJobData(data []byte, isCompressed bool, key string) (schema.JobData, error) {
+	if data == nil {
+		return nil, fmt.Errorf("ARCHIVE/SQLITE > no metric data for job %s", key)
+	}
+
+	if isCompressed {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			log.Errorf(" %v", err)
+			return nil, err
+		}
+		defer r.Close()
+
+		if data, err = io.ReadAll(r); err != nil {
+			log.Errorf(" %v", err)
+			return nil, err
+		}
+	}
+
+	if config.Keys.Validate {
+		if err := schema.Validate(schema.Data, bytes.NewReader(data)); err != nil {
+			return schema.JobData{}, fmt.Errorf("validate job data: %v", err)
+		}
+	}
+
+	return DecodeJobData(bytes.NewReader(data), "sqlite:"+sa.path+":"+key)
+}
+
+func sqliteJobKey(cluster string, jobId, startTime int64) string {
+	return fmt.Sprintf("%s/%d/%d", cluster, jobId, startTime)
+}
+
+func (sa *SqliteArchive) LoadJobData(job *schema.Job) (schema.JobData, error) {
+	var data []byte
+	var isCompressed bool
+	if err := sa.db.QueryRow(`SELECT data, compressed FROM job
+		WHERE cluster = ? AND job_id = ? AND start_time = ?`,
+		job.Cluster, job.JobID, job.StartTime.Unix()).Scan(&data, &isCompressed); err != nil {
+		log.Errorf("sqliteBackend LoadJobData()- %v", err)
+		return nil, err
+	}
+
+	return sa.decodeJobData(data, isCompressed, sqliteJobKey(job.Cluster, job.JobID, job.StartTime.Unix()))
+}
+
+func (sa *SqliteArchive) LoadJobDataResolution(job *schema.Job, factor int) (schema.JobData, error) {
+	var data []byte
+	err := sa.db.QueryRow(`SELECT data FROM job_resolution
+		WHERE cluster = ? AND job_id = ? AND start_time = ? AND factor = ?`,
+		job.Cluster, job.JobID, job.StartTime.Unix(), factor).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("ARCHIVE/SQLITE > no resolution %dx for job %d: %w", factor, job.JobID, os.ErrNotExist)
+	} else if err != nil {
+		log.Errorf("sqliteBackend LoadJobDataResolution()- %v", err)
+		return nil, err
+	}
+
+	return sa.decodeJobData(data, false,
+		fmt.Sprintf("%s/%dx", sqliteJobKey(job.Cluster, job.JobID, job.StartTime.Unix()), factor))
+}
+
+func (sa *SqliteArchive) LoadJobMeta(job *schema.Job) (*schema.JobMeta, error) {
+	var meta []byte
+	if err := sa.db.QueryRow(`SELECT meta FROM job
+		WHERE cluster = ? AND job_id = ? AND start_time = ?`,
+		job.Cluster, job.JobID, job.StartTime.Unix()).Scan(&meta); err != nil {
+		log.Errorf("loadJobMeta() > query error: %v", err)
+		return &schema.JobMeta{}, err
+	}
+
+	return decodeSqliteJobMeta(meta)
+}
+
+func (sa *SqliteArchive) LoadClusterCfg(name string) (*schema.Cluster, error) {
+	var b []byte
+	if err := sa.db.QueryRow(`SELECT config FROM cluster WHERE name = ?`, name).Scan(&b); err != nil {
+		log.Errorf("LoadClusterCfg() > query error: %v", err)
+		return &schema.Cluster{}, err
+	}
+	if config.Keys.Validate {
+		if err := schema.Validate(schema.ClusterCfg, bytes.NewReader(b)); err != nil {
+			log.Warnf("Validate cluster config: %v\n", err)
+			return &schema.Cluster{}, fmt.Errorf("validate cluster config: %v", err)
+		}
+	}
+
+	return DecodeCluster(bytes.NewReader(b))
+}
+
+func (sa *SqliteArchive) StoreClusterCfg(name string, cluster *schema.Cluster) error {
+	b, err := json.Marshal(cluster)
+	if err != nil {
+		log.Error("Error while encoding cluster config")
+		return err
+	}
+
+	if _, err := sa.db.Exec(`INSERT OR REPLACE INTO cluster (name, config) VALUES (?, ?)`, name, b); err != nil {
+		log.Error("Error while storing cluster config")
+		return err
+	}
+
+	if !util.Contains(sa.clusters, name) {
+		sa.clusters = append(sa.clusters, name)
+	}
+	return nil
+}
+
+func (sa *SqliteArchive) Iter(loadMetricData bool) <-chan JobContainer {
+	ch := make(chan JobContainer)
+	go func() {
+		q := `SELECT cluster, job_id, start_time, meta, NULL, 0 FROM job ORDER BY cluster, job_id, start_time`
+		if loadMetricData {
+			q = `SELECT cluster, job_id, start_time, meta, data, compressed FROM job ORDER BY cluster, job_id, start_time`
+		}
+
+		rows, err := sa.db.Query(q)
+		if err != nil {
+			log.Fatalf("Reading jobs failed: %s", err.Error())
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var cluster string
+			var jobId, startTime int64
+			var meta, data []byte
+			var isCompressed bool
+			if err := rows.Scan(&cluster, &jobId, &startTime, &meta, &data, &isCompressed); err != nil {
+				log.Fatalf("Reading jobs failed: %s", err.Error())
+			}
+
+			key := sqliteJobKey(cluster, jobId, startTime)
+			job, err := decodeSqliteJobMeta(meta)
+			if err != nil {
+				log.Errorf("in %s: %s", key, err.Error())
+			}
+
+			if loadMetricData {
+				jobData, err := sa.decodeJobData(data, isCompressed, key)
+				if err != nil {
+					log.Errorf("in %s: %s", key, err.Error())
+				}
+				ch <- JobContainer{Meta: job, Data: &jobData}
+			} else {
+				ch <- JobContainer{Meta: job, Data: nil}
+			}
+		}
+		close(ch)
+	}()
+	return ch
+}
+
+func (sa *SqliteArchive) StoreJobMeta(jobMeta *schema.JobMeta) error {
+	var buf bytes.Buffer
+	if err := EncodeJobMeta(&buf, jobMeta); err != nil {
+		log.Error("Error while encoding job metadata")
+		return err
+	}
+
+	res, err := sa.db.Exec(`UPDATE job SET meta = ? WHERE cluster = ? AND job_id = ? AND start_time = ?`,
+		buf.Bytes(), jobMeta.Cluster, jobMeta.JobID, jobMeta.StartTime)
+	if err != nil {
+		log.Error("Error while storing job metadata")
+		return err
+	}
+
+	if cnt, err := res.RowsAffected(); err == nil && cnt == 0 {
+		return errors.New("ARCHIVE/SQLITE > cannot store metadata of job not in archive")
+	}
+
+	return nil
+}
+
+func (sa *SqliteArchive) GetClusters() []string {
+	return sa.clusters
+}
+
+func (sa *SqliteArchive) ImportJob(
+	jobMeta *schema.JobMeta,
+	jobData *schema.JobData) error {
+
+	var meta, data bytes.Buffer
+	if err := EncodeJobMeta(&meta, jobMeta); err != nil {
+		log.Error("Error while encoding job metadata")
+		return err
+	}
+	if err := EncodeJobData(&data, jobData); err != nil {
+		log.Error("Error while encoding job metricdata")
+		return err
+	}
+
+	blob := data.Bytes()
+	if sa.compressData {
+		var err error
+		if blob, err = compressBlob(blob); err != nil {
+			log.Error("Error while compressing job metricdata")
+			return err
+		}
+	}
+
+	if _, err := sa.db.Exec(`INSERT OR REPLACE INTO job
+		(cluster, job_id, start_time, meta, data, compressed) VALUES (?, ?, ?, ?, ?, ?)`,
+		jobMeta.Cluster, jobMeta.JobID, jobMeta.StartTime, meta.Bytes(), blob, sa.compressData); err != nil {
+		log.Error("Error while inserting job into sqlite archive")
+		return err
+	}
+
+	return nil
+}
+
+func (sa *SqliteArchive) StoreJobDataResolution(
+	jobMeta *schema.JobMeta,
+	factor int,
+	jobData *schema.JobData) error {
+
+	var data bytes.Buffer
+	if err := EncodeJobData(&data, jobData); err != nil {
+		log.Error("Error while encoding job metricdata")
+		return err
+	}
+
+	if _, err := sa.db.Exec(`INSERT OR REPLACE INTO job_resolution
+		(cluster, job_id, start_time, factor, data) VALUES (?, ?, ?, ?, ?)`,
+		jobMeta.Cluster, jobMeta.JobID, jobMeta.StartTime, factor, data.Bytes()); err != nil {
+		log.Error("Error while inserting job resolution into sqlite archive")
+		return err
+	}
+
+	return nil
+}
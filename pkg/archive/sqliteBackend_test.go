@@ -0,0 +1,223 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+func setupSqlite(t *testing.T, compressData bool) *SqliteArchive {
+	var fsa FsArchive
+	if _, err := fsa.Init(json.RawMessage("{\"path\":\"testdata/archive\"}")); err != nil {
+		t.Fatal(err)
+	}
+
+	var sa SqliteArchive
+	dbPath := filepath.Join(t.TempDir(), "job-archive.db")
+	version, err := sa.Init(json.RawMessage(fmt.Sprintf(
+		"{\"kind\": \"sqlite\", \"dbPath\": \"%s\", \"compressData\": %v}", dbPath, compressData)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Fatalf("unexpected version %d", version)
+	}
+
+	cluster, err := fsa.LoadClusterCfg("emmy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sa.StoreClusterCfg("emmy", cluster); err != nil {
+		t.Fatal(err)
+	}
+
+	for job := range fsa.Iter(true) {
+		if err := sa.ImportJob(job.Meta, job.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &sa
+}
+
+func testJobs() []*schema.Job {
+	jobs := []*schema.Job{{}, {}}
+	jobs[0].JobID = 1403244
+	jobs[0].Cluster = "emmy"
+	jobs[0].StartTime = time.Unix(1608923076, 0)
+	jobs[1].JobID = 1404397
+	jobs[1].Cluster = "emmy"
+	jobs[1].StartTime = time.Unix(1609300556, 0)
+	return jobs
+}
+
+func TestSqliteInitEmptyPath(t *testing.T) {
+	var sa SqliteArchive
+	if _, err := sa.Init(json.RawMessage("{\"kind\": \"sqlite\"}")); err == nil {
+		t.Fatal("expected error for empty path")
+	}
+}
+
+func TestSqliteLoad(t *testing.T) {
+	sa := setupSqlite(t, false)
+	if len(sa.GetClusters()) != 1 || sa.GetClusters()[0] != "emmy" {
+		t.Fatalf("unexpected clusters %v", sa.GetClusters())
+	}
+
+	cfg, err := sa.LoadClusterCfg("emmy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SubClusters[0].CoresPerSocket != 4 {
+		t.Fail()
+	}
+
+	job := testJobs()[0]
+	meta, err := sa.LoadJobMeta(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.JobID != 1403244 || meta.StartTime != 1608923076 {
+		t.Fail()
+	}
+
+	data, err := sa.LoadJobData(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scopes := range data {
+		if _, exists := scopes[schema.MetricScopeNode]; !exists {
+			t.Fail()
+		}
+	}
+}
+
+func TestSqliteIterCompressed(t *testing.T) {
+	sa := setupSqlite(t, true)
+
+	cnt := 0
+	for job := range sa.Iter(true) {
+		if job.Meta.Cluster != "emmy" || job.Data == nil || len(*job.Data) == 0 {
+			t.Fail()
+		}
+		cnt++
+	}
+	if cnt != 2 {
+		t.Fatalf("expected 2 jobs, got %d", cnt)
+	}
+}
+
+func TestSqliteStoreJobMeta(t *testing.T) {
+	sa := setupSqlite(t, false)
+
+	job := testJobs()[0]
+	meta, err := sa.LoadJobMeta(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.MetaData = map[string]string{"jobScript": "#!/bin/bash"}
+	if err := sa.StoreJobMeta(meta); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err = sa.LoadJobMeta(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.MetaData["jobScript"] != "#!/bin/bash" {
+		t.Fail()
+	}
+}
+
+func TestSqliteCompress(t *testing.T) {
+	sa := setupSqlite(t, false)
+
+	jobs := testJobs()
+	sa.Compress(jobs)
+
+	var n int
+	if err := sa.db.QueryRow(`SELECT count(*) FROM job WHERE compressed = 1`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 compressed jobs, got %d", n)
+	}
+	if _, err := sa.LoadJobData(jobs[1]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSqliteCleanAndMove(t *testing.T) {
+	sa := setupSqlite(t, false)
+	jobs := testJobs()
+
+	target := filepath.Join(t.TempDir(), "retention.db")
+	sa.Move(jobs[1:], target)
+	if sa.Exists(jobs[1]) {
+		t.Fatal("moved job still exists")
+	}
+
+	var moved SqliteArchive
+	if _, err := moved.Init(json.RawMessage(fmt.Sprintf("{\"dbPath\": \"%s\"}", target))); err != nil {
+		t.Fatal(err)
+	}
+	if !moved.Exists(jobs[1]) {
+		t.Fatal("moved job missing at target")
+	}
+
+	sa.Clean(1609000000, 0)
+	if sa.Exists(jobs[0]) {
+		t.Fatal("job still exists after clean")
+	}
+}
+
+func TestSqliteJobDataResolution(t *testing.T) {
+	sa := setupSqlite(t, false)
+	jobs := testJobs()
+
+	if _, err := sa.LoadJobDataResolution(jobs[1], 16); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing resolution, got %v", err)
+	}
+
+	jobMeta, err := sa.LoadJobMeta(jobs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobData, err := sa.LoadJobData(jobs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	reduced := jobData.Downsample(16)
+	if err := sa.StoreJobDataResolution(jobMeta, 16, &reduced); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sa.LoadJobDataResolution(jobs[1], 16); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(t.TempDir(), "retention.db")
+	sa.Move(jobs[1:], target)
+
+	var moved SqliteArchive
+	if _, err := moved.Init(json.RawMessage(fmt.Sprintf("{\"dbPath\": \"%s\"}", target))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := moved.LoadJobDataResolution(jobs[1], 16); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := sa.db.QueryRow(`SELECT count(*) FROM job_resolution`).Scan(&n); err != nil || n != 0 {
+		t.Fatalf("resolution not removed after move: %d %v", n, err)
+	}
+}
@@ -63,10 +63,22 @@ type FilterRanges struct {
 	StartTime *TimeRange `json:"startTime"`
 }
 
+// A metric computed from other metrics of a cluster for every series and
+// timestep.
+type DerivedMetric struct {
+	Name string `json:"name"`
+	// Arithmetic expression using metric names and + - * / ( ),
+	// e.g. "flops_dp * 2 + flops_sp".
+	Expression string `json:"expression"`
+	// Unit string as understood by cc-units, e.g. "GF/s".
+	Unit string `json:"unit"`
+}
+
 type ClusterConfig struct {
 	Name                 string          `json:"name"`
 	FilterRanges         *FilterRanges   `json:"filterRanges"`
 	MetricDataRepository json.RawMessage `json:"metricDataRepository"`
+	DerivedMetrics       []DerivedMetric `json:"derivedMetrics"`
 }
 
 type Retention struct {
@@ -76,6 +88,21 @@ type Retention struct {
 	IncludeDB bool   `json:"includeDB"`
 }
 
+// Selects the metrics archived for jobs of a cluster (or only one of its
+// subclusters if SubCluster is set) and the scopes they are archived at.
+type ArchiveScopes struct {
+	Cluster    string         `json:"cluster"`
+	SubCluster string         `json:"subCluster"`
+	Metrics    []string       `json:"metrics"` // All metrics of the cluster if empty
+	Scopes     []ArchiveScope `json:"scopes"`
+}
+
+type ArchiveScope struct {
+	Scope MetricScope `json:"scope"`
+	// Only archive this scope for jobs with at most MaxNodes nodes (0: no limit)
+	MaxNodes int `json:"maxNodes"`
+}
+
 // Format of the configuration (file). See below for the defaults.
 type ProgramConfig struct {
 	// Address where the http (or https) server will listen on (for example: 'localhost:80').
@@ -110,6 +137,16 @@ type ProgramConfig struct {
 	// do not write to the job-archive.
 	DisableArchive bool `json:"disable-archive"`
 
+	// Number of workers archiving stopped jobs in parallel.
+	ArchiveWorkers int `json:"archive-workers"`
+
+	// Timeout in seconds for archiving a single job.
+	ArchiveTimeout int `json:"archive-timeout"`
+
+	// Percentiles computed across all series per timestep (in addition to
+	// min, mean and max) for metrics with many series.
+	StatisticsPercentiles []int `json:"statistics-percentiles"`
+
 	// Validate json input against schema
 	Validate bool `json:"validate"`
 
@@ -232,8 +232,8 @@ func (jd *JobData) AddNodeScope(metric string) bool {
 			max = math.Max(max, series.Statistics.Max)
 		}
 
-		n, m := 0, len(jm.Series[0].Data)
-		for _, series := range jm.Series {
+		n, m := 0, len(series[0].Data)
+		for _, series := range series {
 			if len(series.Data) > n {
 				n = len(series.Data)
 			}
@@ -242,10 +242,10 @@ func (jd *JobData) AddNodeScope(metric string) bool {
 			}
 		}
 
-		i, data := 0, make([]Float, len(series[0].Data))
+		i, data := 0, make([]Float, n)
 		for ; i < m; i++ {
 			x := Float(0.0)
-			for _, series := range jm.Series {
+			for _, series := range series {
 				x += series.Data[i]
 			}
 			data[i] = x
@@ -266,12 +266,16 @@ func (jd *JobData) AddNodeScope(metric string) bool {
 	return true
 }
 
+// AddPercentiles adds the percentiles ps (between 1 and 99) of all series
+// per timestep to the statistics series, which is added first if missing.
+// Like for min, mean and max, NaNs are ignored and timesteps with less than
+// three values result in NaN.
 func (jm *JobMetric) AddPercentiles(ps []int) bool {
 	if jm.StatisticsSeries == nil {
 		jm.AddStatisticsSeries()
 	}
 
-	if len(jm.Series) < 3 {
+	if jm.StatisticsSeries == nil || len(ps) == 0 {
 		return false
 	}
 
@@ -290,7 +294,7 @@ func (jm *JobMetric) AddPercentiles(ps []int) bool {
 	for i := 0; i < n; i++ {
 		vals := make([]float64, 0, len(jm.Series))
 		for _, series := range jm.Series {
-			if i < len(series.Data) {
+			if i < len(series.Data) && !series.Data[i].IsNaN() {
 				vals = append(vals, float64(series.Data[i]))
 			}
 		}
@@ -311,6 +315,10 @@ func (jm *JobMetric) AddPercentiles(ps []int) bool {
 		percentiles := make([]Float, n)
 		for i := 0; i < n; i++ {
 			sorted := data[i]
+			if len(sorted) < 3 {
+				percentiles[i] = NaN
+				continue
+			}
 			percentiles[i] = Float(sorted[(len(sorted)*p)/100])
 		}
 
@@ -319,3 +327,197 @@ func (jm *JobMetric) AddPercentiles(ps []int) bool {
 
 	return true
 }
+
+func bucketEnd(i, factor, n int) int {
+	if i+factor > n {
+		return n
+	}
+	return i + factor
+}
+
+// Mean of every bucket of factor consecutive values, ignoring NaNs. A bucket
+// consisting of NaNs only results in NaN.
+func downsampleMean(data []Float, factor int) []Float {
+	res := make([]Float, 0, (len(data)+factor-1)/factor)
+	for i := 0; i < len(data); i += factor {
+		sum, n := 0.0, 0
+		for _, x := range data[i:bucketEnd(i, factor, len(data))] {
+			if x.IsNaN() {
+				continue
+			}
+			sum += float64(x)
+			n++
+		}
+		if n == 0 {
+			res = append(res, NaN)
+		} else {
+			res = append(res, Float(sum/float64(n)))
+		}
+	}
+	return res
+}
+
+// Like downsampleMean, but keeps the minimum (or maximum if useMax is set) of
+// every bucket so that extreme values survive downsampling.
+func downsampleExtreme(data []Float, factor int, useMax bool) []Float {
+	res := make([]Float, 0, (len(data)+factor-1)/factor)
+	for i := 0; i < len(data); i += factor {
+		y := NaN
+		for _, x := range data[i:bucketEnd(i, factor, len(data))] {
+			if x.IsNaN() {
+				continue
+			}
+			if y.IsNaN() || (useMax && x > y) || (!useMax && x < y) {
+				y = x
+			}
+		}
+		res = append(res, y)
+	}
+	return res
+}
+
+// Like downsampleMean, but selects one sample of every bucket using the
+// Largest-Triangle-Three-Buckets algorithm: The sample forming the largest
+// triangle with the sample selected in the previous bucket and the mean of
+// the next bucket is kept. This preserves peaks and the visual shape of the
+// series better than averaging. The first and the last sample are always
+// kept.
+func downsampleLTTB(data []Float, factor int) []Float {
+	res := make([]Float, 0, (len(data)+factor-1)/factor)
+	// Position and value of the previously selected sample
+	ax, ay := 0.0, NaN
+	for i := 0; i < len(data); i += factor {
+		end := bucketEnd(i, factor, len(data))
+
+		// Mean of the next bucket at its center
+		cx, cy := 0.0, NaN
+		if end < len(data) {
+			next := bucketEnd(end, factor, len(data))
+			cx, cy = float64(end+next-1)/2, downsampleMean(data[end:next], factor)[0]
+			if cy.IsNaN() {
+				// Keep the sample deviating most from the previous one
+				cx, cy = float64(end), ay
+			}
+		}
+
+		sel, area := -1, -1.0
+		for j := i; j < end; j++ {
+			if data[j].IsNaN() {
+				continue
+			}
+			if ay.IsNaN() {
+				sel = j
+				break
+			}
+			if cy.IsNaN() {
+				// Last bucket
+				sel = j
+				continue
+			}
+
+			a := math.Abs((ax-cx)*float64(data[j]-ay) - (ax-float64(j))*float64(cy-ay))
+			if a > area {
+				sel, area = j, a
+			}
+		}
+
+		if sel < 0 {
+			res = append(res, NaN)
+			continue
+		}
+		ax, ay = float64(sel), data[sel]
+		res = append(res, data[sel])
+	}
+	return res
+}
+
+// Downsample returns a copy of the metric with a factor times coarser
+// timestep. The series data is averaged over buckets of factor samples
+// while the per series statistics are kept. The statistics series is
+// computed from the full resolution data (if not present already) and
+// downsampled keeping the minimum and maximum of every bucket.
+func (jm *JobMetric) Downsample(factor int) *JobMetric {
+	return jm.downsample(factor, downsampleMean)
+}
+
+// DownsampleTo returns a copy of the metric with at most maxPoints samples
+// per series. Unlike Downsample, the series data is reduced using LTTB, see
+// downsampleLTTB, which keeps the shape of the series for plotting.
+func (jm *JobMetric) DownsampleTo(maxPoints int) *JobMetric {
+	n := 0
+	for _, series := range jm.Series {
+		if len(series.Data) > n {
+			n = len(series.Data)
+		}
+	}
+	if maxPoints <= 0 || n <= maxPoints {
+		return jm
+	}
+
+	return jm.downsample((n+maxPoints-1)/maxPoints, downsampleLTTB)
+}
+
+func (jm *JobMetric) downsample(factor int, reduce func(data []Float, factor int) []Float) *JobMetric {
+	if factor <= 1 {
+		return jm
+	}
+
+	full := *jm
+	full.AddStatisticsSeries()
+
+	res := &JobMetric{
+		Unit:     jm.Unit,
+		Timestep: jm.Timestep * factor,
+		Series:   make([]Series, 0, len(jm.Series)),
+	}
+	for _, series := range jm.Series {
+		res.Series = append(res.Series, Series{
+			Hostname:   series.Hostname,
+			Id:         series.Id,
+			Statistics: series.Statistics,
+			Data:       reduce(series.Data, factor),
+		})
+	}
+
+	if stats := full.StatisticsSeries; stats != nil {
+		res.StatisticsSeries = &StatsSeries{
+			Mean: downsampleMean(stats.Mean, factor),
+			Min:  downsampleExtreme(stats.Min, factor, false),
+			Max:  downsampleExtreme(stats.Max, factor, true),
+		}
+		if stats.Percentiles != nil {
+			res.StatisticsSeries.Percentiles = make(map[int][]Float, len(stats.Percentiles))
+			for p, data := range stats.Percentiles {
+				res.StatisticsSeries.Percentiles[p] = downsampleMean(data, factor)
+			}
+		}
+	}
+
+	return res
+}
+
+// DownsampleTo returns a copy of the job data with all metrics reduced to at
+// most maxPoints samples per series, see JobMetric.DownsampleTo.
+func (jd *JobData) DownsampleTo(maxPoints int) JobData {
+	res := make(JobData, len(*jd))
+	for metric, scopes := range *jd {
+		res[metric] = make(map[MetricScope]*JobMetric, len(scopes))
+		for scope, jm := range scopes {
+			res[metric][scope] = jm.DownsampleTo(maxPoints)
+		}
+	}
+	return res
+}
+
+// Downsample returns a copy of the job data with all metrics downsampled
+// by factor, see JobMetric.Downsample.
+func (jd *JobData) Downsample(factor int) JobData {
+	res := make(JobData, len(*jd))
+	for metric, scopes := range *jd {
+		res[metric] = make(map[MetricScope]*JobMetric, len(scopes))
+		for scope, jm := range scopes {
+			res[metric][scope] = jm.Downsample(factor)
+		}
+	}
+	return res
+}
@@ -0,0 +1,129 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package schema
+
+import (
+	"testing"
+)
+
+func TestDownsample(t *testing.T) {
+	jm := &JobMetric{Timestep: 60}
+	for i := 0; i < 4; i++ {
+		data := make([]Float, 10)
+		for j := range data {
+			data[j] = Float(i * j)
+		}
+		data[9] = NaN
+		jm.Series = append(jm.Series, Series{
+			Hostname:   "host",
+			Statistics: MetricStatistics{Min: 0, Avg: 1, Max: float64(i * 8)},
+			Data:       data,
+		})
+	}
+
+	res := jm.Downsample(4)
+	if res.Timestep != 240 || len(res.Series) != 4 {
+		t.Fatalf("unexpected metric %+v", res)
+	}
+	if jm.StatisticsSeries != nil {
+		t.Fatal("original metric modified")
+	}
+
+	data := res.Series[3].Data
+	if len(data) != 3 || data[0] != 4.5 || data[1] != 16.5 || data[2] != 24 {
+		t.Fatalf("unexpected series data %v", data)
+	}
+	if res.Series[3].Statistics.Max != 24 {
+		t.Fatal("series statistics not kept")
+	}
+
+	stats := res.StatisticsSeries
+	if stats == nil || len(stats.Min) != 3 {
+		t.Fatal("missing statistics series")
+	}
+	if stats.Min[1] != 0 || stats.Max[1] != 21 || stats.Mean[1] != 8.25 {
+		t.Fatalf("unexpected statistics series %+v", stats)
+	}
+}
+
+func TestDownsampleTo(t *testing.T) {
+	jm := &JobMetric{Timestep: 60, Series: []Series{
+		{Hostname: "host", Data: []Float{1, 0, 0, 0, 10, 0, NaN, NaN, NaN, 0, 0, 2}},
+	}}
+
+	if jm.DownsampleTo(12) != jm || jm.DownsampleTo(0) != jm {
+		t.Fatal("expected metric to be unchanged")
+	}
+
+	res := jm.DownsampleTo(4)
+	data := res.Series[0].Data
+	if res.Timestep != 180 || len(data) != 4 {
+		t.Fatalf("unexpected metric %+v", res)
+	}
+	if data[0] != 1 || data[1] != 10 || !data[2].IsNaN() || data[3] != 2 {
+		t.Fatalf("unexpected series data %v", data)
+	}
+}
+
+func TestAddPercentiles(t *testing.T) {
+	jm := &JobMetric{Timestep: 60}
+	for i := 0; i < 10; i++ {
+		data := []Float{Float(i), NaN}
+		if i < 2 {
+			data[1] = Float(i)
+		}
+		jm.Series = append(jm.Series, Series{Hostname: "host", Data: data})
+	}
+
+	if !jm.AddPercentiles([]int{10, 50, 90}) {
+		t.Fatal("expected percentiles to be added")
+	}
+	ps := jm.StatisticsSeries.Percentiles
+	if len(ps) != 3 || ps[10][0] != 1 || ps[50][0] != 5 || ps[90][0] != 9 {
+		t.Fatalf("unexpected percentiles %v", ps)
+	}
+	if !ps[50][1].IsNaN() {
+		t.Fatal("expected NaN for less than three values")
+	}
+
+	if (&JobMetric{Series: jm.Series[:2]}).AddPercentiles([]int{50}) {
+		t.Fatal("expected no percentiles for two series")
+	}
+}
+
+func TestAddNodeScope(t *testing.T) {
+	id0, id1 := "0", "1"
+	jd := JobData{"flops_any": {MetricScopeCore: &JobMetric{
+		Timestep: 60,
+		Series: []Series{
+			{Hostname: "n1", Id: &id0, Statistics: MetricStatistics{Min: 1, Avg: 2, Max: 3}, Data: []Float{1, 2, 3}},
+			{Hostname: "n1", Id: &id1, Statistics: MetricStatistics{Min: 2, Avg: 4, Max: 6}, Data: []Float{2, 4, 6}},
+			{Hostname: "n2", Id: &id0, Statistics: MetricStatistics{Min: 10, Avg: 10, Max: 10}, Data: []Float{10}},
+		},
+	}}}
+
+	if !jd.AddNodeScope("flops_any") || jd.AddNodeScope("mem_bw") {
+		t.Fatal("unexpected result of AddNodeScope")
+	}
+
+	jm := jd["flops_any"][MetricScopeNode]
+	if jm == nil || len(jm.Series) != 2 || jm.Timestep != 60 {
+		t.Fatalf("unexpected node metric %+v", jm)
+	}
+	for _, series := range jm.Series {
+		switch series.Hostname {
+		case "n1":
+			if len(series.Data) != 3 || series.Data[0] != 3 || series.Data[2] != 9 || series.Statistics.Avg != 3 {
+				t.Fatalf("unexpected series of n1 %+v", series)
+			}
+		case "n2":
+			if len(series.Data) != 1 || series.Data[0] != 10 || series.Statistics.Max != 10 {
+				t.Fatalf("unexpected series of n2 %+v", series)
+			}
+		default:
+			t.Fatalf("unexpected host %s", series.Hostname)
+		}
+	}
+}
@@ -33,7 +33,9 @@ func parseDate(in string) int64 {
 
 func main() {
 	var srcPath, flagConfigFile, flagLogLevel, flagRemoveCluster, flagRemoveAfter, flagRemoveBefore string
-	var flagLogDateTime, flagValidate bool
+	var flagSrcConfig, flagMigrate, flagMigrateState, flagQuarantine string
+	var flagLogDateTime, flagValidate, flagMigrateCreate, flagVerifyMigration, flagVerify, flagFlagDB bool
+	var flagReconcile, flagImportOrphans, flagMarkMissing, flagUseIndex, flagRebuildIndex bool
 
 	flag.StringVar(&srcPath, "s", "./var/job-archive", "Specify the source job archive path. Default is ./var/job-archive")
 	flag.BoolVar(&flagLogDateTime, "logdate", false, "Set this flag to add date and time to log messages")
@@ -43,9 +45,26 @@ func main() {
 	flag.StringVar(&flagRemoveBefore, "remove-before", "", "Remove all jobs with start time before date (Format: 2006-Jan-04)")
 	flag.StringVar(&flagRemoveAfter, "remove-after", "", "Remove all jobs with start time after date (Format: 2006-Jan-04)")
 	flag.BoolVar(&flagValidate, "validate", false, "Set this flag to validate a job archive against the json schema")
+	flag.BoolVar(&flagUseIndex, "use-index", false, "Use and maintain the index of the job archive given by -s, see the useIndex archive option")
+	flag.BoolVar(&flagRebuildIndex, "rebuild-index", false, "Rebuild the index of the job archive given by -s, implies -use-index")
+	flag.StringVar(&flagSrcConfig, "src-config", "", "Specify the source archive config as json, e.g. `{\"kind\": \"s3\", ...}`. Overwrites -s")
+	flag.StringVar(&flagMigrate, "migrate", "", "Copy all jobs and cluster configs to the archive given as json config and verify the result afterwards")
+	flag.StringVar(&flagMigrateState, "migrate-state", "", "Record migrated jobs in this file and skip jobs already listed to resume an interrupted migration")
+	flag.BoolVar(&flagMigrateCreate, "migrate-create", false, "Create the -migrate destination archive with version.txt if it is empty")
+	flag.BoolVar(&flagVerifyMigration, "verify-migration", false, "Only compare the job statistics of source and -migrate destination archive")
+	flag.BoolVar(&flagVerify, "verify", false, "Check checksums and decodability of all jobs and report broken jobs (file archives only, others are reported as unsupported)")
+	flag.StringVar(&flagQuarantine, "quarantine", "", "Move jobs failing -verify to this directory (must be outside of the archive)")
+	flag.BoolVar(&flagFlagDB, "flag-db", false, "Set the monitoring status of jobs failing -verify to archiving failed in the database")
+	flag.BoolVar(&flagReconcile, "reconcile", false, "Report jobs only present in either the database or the job archive")
+	flag.BoolVar(&flagImportOrphans, "import-orphans", false, "Insert jobs found by -reconcile only in the job archive into the database")
+	flag.BoolVar(&flagMarkMissing, "mark-missing", false, "Set the monitoring status of archived jobs missing in the job archive to archiving failed")
 	flag.Parse()
 
-	archiveCfg := fmt.Sprintf("{\"kind\": \"file\",\"path\": \"%s\"}", srcPath)
+	archiveCfg := fmt.Sprintf("{\"kind\": \"file\",\"path\": \"%s\", \"useIndex\": %t, \"rebuildIndex\": %t}",
+		srcPath, flagUseIndex || flagRebuildIndex, flagRebuildIndex)
+	if flagSrcConfig != "" {
+		archiveCfg = flagSrcConfig
+	}
 
 	log.Init(flagLogLevel, flagLogDateTime)
 	config.Init(flagConfigFile)
@@ -63,6 +82,41 @@ func main() {
 		os.Exit(0)
 	}
 
+	if flagReconcile {
+		if err := reconcile(flagImportOrphans, flagMarkMissing); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	if flagVerify {
+		if err := verify(ar, flagQuarantine, flagFlagDB); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	if flagMigrate != "" {
+		initBackend := archive.InitBackend
+		if flagMigrateCreate {
+			initBackend = archive.CreateBackend
+		}
+		dst, err := initBackend(json.RawMessage(flagMigrate))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if !flagVerifyMigration {
+			if err := migrate(ar, dst, flagMigrateState); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := verifyMigration(ar, dst); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
 	if flagRemoveBefore != "" || flagRemoveAfter != "" {
 		ar.Clean(parseDate(flagRemoveBefore), parseDate(flagRemoveAfter))
 		os.Exit(0)
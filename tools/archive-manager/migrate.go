@@ -0,0 +1,226 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/archive"
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+const progressInterval = 100
+
+func jobKey(job *schema.JobMeta) string {
+	return fmt.Sprintf("%s/%d/%d", job.Cluster, job.JobID, job.StartTime)
+}
+
+// Read the keys of all jobs that were already migrated successfully.
+func loadMigrationState(filename string) (map[string]bool, error) {
+	done := make(map[string]bool)
+	if filename == "" {
+		return done, nil
+	}
+
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return done, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			done[line] = true
+		}
+	}
+
+	return done, scanner.Err()
+}
+
+// Copy all cluster configurations and jobs, including the downsampled
+// resolutions of their metric data, from src to dst. Jobs listed in
+// the state file are skipped and every migrated job is appended to it, so
+// an interrupted migration can be resumed by running it again.
+func migrate(src, dst archive.ArchiveBackend, stateFile string) error {
+	done, err := loadMigrationState(stateFile)
+	if err != nil {
+		log.Errorf("Error while reading migration state %s", stateFile)
+		return err
+	}
+
+	var state *os.File
+	if stateFile != "" {
+		state, err = os.OpenFile(stateFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Errorf("Error while opening migration state %s", stateFile)
+			return err
+		}
+		defer state.Close()
+	}
+
+	for _, name := range src.GetClusters() {
+		cluster, err := src.LoadClusterCfg(name)
+		if err != nil {
+			log.Errorf("Error while loading cluster config %s", name)
+			return err
+		}
+		if err := dst.StoreClusterCfg(name, cluster); err != nil {
+			log.Errorf("Error while storing cluster config %s", name)
+			return err
+		}
+	}
+
+	if len(done) > 0 {
+		fmt.Printf("Resuming migration, %d jobs already done\n", len(done))
+	}
+
+	t := time.Now()
+	migrated, skipped, failed := 0, 0, 0
+	// The metric data is only loaded for jobs not migrated yet
+	for job := range src.Iter(false) {
+		key := jobKey(job.Meta)
+		if done[key] {
+			skipped++
+			continue
+		}
+
+		j := schema.Job{BaseJob: job.Meta.BaseJob, StartTime: time.Unix(job.Meta.StartTime, 0)}
+		data, err := src.LoadJobData(&j)
+		if err != nil {
+			log.Errorf("Migrate %s: %s", key, err.Error())
+			failed++
+			continue
+		}
+		if len(data) == 0 {
+			log.Errorf("Migrate %s: no metric data", key)
+			failed++
+			continue
+		}
+
+		if err := dst.ImportJob(job.Meta, &data); err != nil {
+			log.Errorf("Migrate %s: %s", key, err.Error())
+			failed++
+			continue
+		}
+		if err := migrateResolutions(src, dst, &j, job.Meta); err != nil {
+			log.Errorf("Migrate %s: %s", key, err.Error())
+			failed++
+			continue
+		}
+
+		if state != nil {
+			if _, err := fmt.Fprintln(state, key); err != nil {
+				log.Errorf("Error while writing migration state %s", stateFile)
+				return err
+			}
+		}
+
+		migrated++
+		if migrated%progressInterval == 0 {
+			fmt.Printf("%d jobs migrated (%d skipped, %d failed), %.1f jobs/s\n",
+				migrated, skipped, failed, float64(migrated)/time.Since(t).Seconds())
+		}
+	}
+
+	fmt.Printf("Migration done in %s: %d jobs migrated, %d skipped, %d failed\n",
+		time.Since(t).Round(time.Second), migrated, skipped, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("migration of %d jobs failed", failed)
+	}
+	return nil
+}
+
+// Copy the downsampled resolutions stored for job, see archive.Resolutions.
+// Jobs archived without them are skipped.
+func migrateResolutions(src, dst archive.ArchiveBackend, job *schema.Job, jobMeta *schema.JobMeta) error {
+	for _, factor := range archive.Resolutions {
+		data, err := src.LoadJobDataResolution(job, factor)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if err := dst.StoreJobDataResolution(jobMeta, factor, &data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func equalStatistics(a, b map[string]schema.JobStatistics) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	equal := func(x, y float64) bool {
+		return x == y || math.Abs(x-y) <= 1e-9*math.Max(math.Abs(x), math.Abs(y))
+	}
+
+	for metric, sa := range a {
+		sb, ok := b[metric]
+		if !ok || !equal(sa.Avg, sb.Avg) || !equal(sa.Min, sb.Min) || !equal(sa.Max, sb.Max) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Check that every job of src exists in dst with identical job statistics
+// and that both archives hold the same number of jobs.
+func verifyMigration(src, dst archive.ArchiveBackend) error {
+	t := time.Now()
+	checked, mismatched := 0, 0
+	for job := range src.Iter(false) {
+		key := jobKey(job.Meta)
+		checked++
+
+		j := schema.Job{BaseJob: job.Meta.BaseJob, StartTime: time.Unix(job.Meta.StartTime, 0)}
+		meta, err := dst.LoadJobMeta(&j)
+		if err != nil {
+			log.Errorf("Verify %s: %s", key, err.Error())
+			mismatched++
+			continue
+		}
+
+		if meta.Duration != job.Meta.Duration || meta.NumNodes != job.Meta.NumNodes ||
+			!equalStatistics(meta.Statistics, job.Meta.Statistics) {
+			log.Errorf("Verify %s: job differs between source and destination", key)
+			mismatched++
+		}
+
+		if checked%progressInterval == 0 {
+			fmt.Printf("%d jobs verified (%d mismatches)\n", checked, mismatched)
+		}
+	}
+
+	total := 0
+	for range dst.Iter(false) {
+		total++
+	}
+
+	fmt.Printf("Verification done in %s: %d jobs checked, %d mismatches, %d jobs in destination\n",
+		time.Since(t).Round(time.Second), checked, mismatched, total)
+
+	if mismatched > 0 {
+		return fmt.Errorf("%d jobs differ between source and destination", mismatched)
+	}
+	if total != checked {
+		return fmt.Errorf("job count differs: %d in source, %d in destination", checked, total)
+	}
+	return nil
+}
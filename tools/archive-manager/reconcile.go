@@ -0,0 +1,50 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package main
+
+import (
+	"fmt"
+
+	"github.com/ClusterCockpit/cc-backend/internal/config"
+	"github.com/ClusterCockpit/cc-backend/internal/importer"
+	"github.com/ClusterCockpit/cc-backend/internal/repository"
+)
+
+// Print all inconsistencies between job table and job archive and
+// optionally repair them.
+func reconcile(importOrphans, markMissing bool) error {
+	repository.Connect(config.Keys.DBDriver, config.Keys.DB)
+
+	report, err := importer.Reconcile()
+	if err != nil {
+		return err
+	}
+
+	for _, job := range report.Orphans {
+		fmt.Printf("orphan\t%s\t%d\t%d\n", job.Cluster, job.JobID, job.StartTime)
+	}
+	for _, job := range report.Missing {
+		fmt.Printf("missing\t%s\t%d\t%d\n", job.Cluster, job.JobID, job.StartTime.Unix())
+	}
+	fmt.Printf("%d jobs only in archive, %d archived jobs missing in archive\n",
+		len(report.Orphans), len(report.Missing))
+
+	if importOrphans {
+		n, err := importer.ImportOrphans(report)
+		fmt.Printf("%d orphans imported into database\n", n)
+		if err != nil {
+			return err
+		}
+	}
+
+	if markMissing {
+		if err := importer.MarkMissing(report); err != nil {
+			return err
+		}
+		fmt.Printf("%d missing jobs marked as archiving failed\n", len(report.Missing))
+	}
+
+	return nil
+}
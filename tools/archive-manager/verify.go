@@ -0,0 +1,67 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/ClusterCockpit/cc-backend/internal/config"
+	"github.com/ClusterCockpit/cc-backend/internal/repository"
+	"github.com/ClusterCockpit/cc-backend/pkg/archive"
+	"github.com/ClusterCockpit/cc-backend/pkg/log"
+	"github.com/ClusterCockpit/cc-backend/pkg/schema"
+)
+
+// Check all jobs of the archive. Broken jobs are moved to the quarantine
+// directory if given and flagged as failed in the database if flagDB is
+// set. Only archives recording checksums (the file archive) are supported.
+func verify(ar archive.ArchiveBackend, quarantine string, flagDB bool) error {
+	v, ok := ar.(archive.Verifier)
+	if !ok {
+		fmt.Printf("Verification unsupported: %T records no checksums, only file archives can be verified\n", ar)
+		return fmt.Errorf("verify is unsupported for %T", ar)
+	}
+
+	var r *repository.JobRepository
+	if flagDB {
+		repository.Connect(config.Keys.DBDriver, config.Keys.DB)
+		r = repository.GetJobRepository()
+	}
+
+	cnt := 0
+	for issue := range v.Verify() {
+		cnt++
+		fmt.Printf("%s: %s\n", issue.Path, issue.Err.Error())
+
+		if quarantine != "" {
+			if err := v.Quarantine(issue, quarantine); err != nil {
+				log.Errorf("Quarantine %s: %s", issue.Path, err.Error())
+			}
+		}
+
+		if r != nil {
+			job, err := r.Find(&issue.JobID, &issue.Cluster, &issue.StartTime)
+			if errors.Is(err, sql.ErrNoRows) {
+				log.Warnf("Job %s not found in database", issue.Path)
+				continue
+			} else if err != nil {
+				log.Errorf("Find %s: %s", issue.Path, err.Error())
+				continue
+			}
+
+			if err := r.UpdateMonitoringStatus(job.ID, schema.MonitoringStatusArchivingFailed); err != nil {
+				log.Errorf("Flag %s: %s", issue.Path, err.Error())
+			}
+		}
+	}
+
+	fmt.Printf("Verification done: %d broken jobs\n", cnt)
+	if cnt > 0 {
+		return fmt.Errorf("found %d broken jobs", cnt)
+	}
+	return nil
+}